@@ -2,20 +2,32 @@ package cosmicpythongo
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
 	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/abbasegbeyemi/cosmic-python-go/services"
 )
 
-type service interface {
-	Allocate(orderId domain.Reference, sku domain.Sku, quantity int) (domain.Reference, error)
-	AddBatch(reference domain.Reference, sku domain.Sku, quantity int, eta time.Time) error
+type messageBus interface {
+	Handle(commands.Command) (any, error)
+}
+
+// stockQueries are the read-only use cases, the server calls them directly instead of sending them through the bus
+type stockQueries interface {
+	RecallReport(recallID domain.Reference) (domain.RecallReport, error)
+	BundleAvailability(sku domain.Sku) (int, error)
+	Simulate(orders []domain.Order, mode domain.AllocationMode) ([]services.SimulatedOrder, error)
+	DeliveryPromise(sku domain.Sku, quantity int, unit domain.Unit) (domain.Promise, error)
 }
 
 type Server struct {
-	service service
+	bus     messageBus
+	queries stockQueries
 }
 
 func (s *Server) AllocationsHandler(w http.ResponseWriter, r *http.Request) {
@@ -29,7 +41,127 @@ func (s *Server) AllocationsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	batchRef, err := s.service.Allocate(orderLine.OrderID, orderLine.Sku, orderLine.Quantity)
+	if r.URL.Query().Get("split") == "true" {
+		s.allocateSplit(w, orderLine)
+		return
+	}
+
+	if r.URL.Query().Get("preempt") == "true" {
+		s.allocatePreempting(w, orderLine)
+		return
+	}
+
+	result, err := s.bus.Handle(commands.Allocate{
+		OrderID:    orderLine.OrderID,
+		CustomerID: domain.Reference(r.URL.Query().Get("customerId")),
+		Sku:        orderLine.Sku,
+		Quantity:   orderLine.Quantity,
+		Unit:       orderLine.Unit,
+		Priority:   orderLine.Priority,
+		Region:     domain.Region(r.URL.Query().Get("region")),
+	})
+
+	if errors.As(err, &services.BackorderedError{}) {
+		writeBackordered(w, err)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(201)
+	switch result := result.(type) {
+	case []domain.LineAllocation:
+		json.NewEncoder(w).Encode(map[string]any{"components": lineAllocationsResponse(result)})
+	case domain.AllocationResult:
+		json.NewEncoder(w).Encode(allocationResponse{
+			BatchRef:        result.BatchRef,
+			Sku:             result.Sku,
+			Substituted:     result.Substituted(),
+			promiseResponse: newPromiseResponse(result.Promise),
+		})
+	}
+}
+
+type allocationResponse struct {
+	BatchRef    domain.Reference `json:"batchRef"`
+	Sku         domain.Sku       `json:"sku"`
+	Substituted bool             `json:"substituted"`
+	promiseResponse
+}
+
+// promiseResponse is when an order line can be dispatched, the ETA of stock in the warehouse is "in stock"
+type promiseResponse struct {
+	ETA          string    `json:"eta"`
+	DispatchDate time.Time `json:"dispatchDate"`
+}
+
+func newPromiseResponse(promise domain.Promise) promiseResponse {
+	if promise.InStock {
+		return promiseResponse{ETA: "in stock", DispatchDate: promise.DispatchDate}
+	}
+	return promiseResponse{ETA: promise.ETA.Format(time.RFC3339Nano), DispatchDate: promise.DispatchDate}
+}
+
+type batchAllocationResponse struct {
+	BatchRef domain.Reference `json:"batchRef"`
+	Quantity int              `json:"quantity"`
+}
+
+// allocateSplit allocates the order line across as many batches as needed and responds with every part
+func (s *Server) allocateSplit(w http.ResponseWriter, orderLine domain.OrderLine) {
+	result, err := s.bus.Handle(commands.AllocateSplit{
+		OrderID:  orderLine.OrderID,
+		Sku:      orderLine.Sku,
+		Quantity: orderLine.Quantity,
+		Unit:     orderLine.Unit,
+		Priority: orderLine.Priority,
+	})
+
+	if errors.As(err, &services.BackorderedError{}) {
+		writeBackordered(w, err)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	var allocations []batchAllocationResponse
+	for _, allocation := range result.([]domain.BatchAllocation) {
+		allocations = append(allocations, batchAllocationResponse{BatchRef: allocation.BatchRef, Quantity: allocation.Quantity})
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{"allocations": allocations})
+}
+
+type displacementResponse struct {
+	OrderID      domain.Reference `json:"orderId"`
+	Quantity     int              `json:"quantity"`
+	FromBatchRef domain.Reference `json:"fromBatchRef"`
+	ToBatchRef   domain.Reference `json:"toBatchRef,omitempty"`
+}
+
+// allocatePreempting allocates the order line to warehouse stock and responds with every line it displaced
+func (s *Server) allocatePreempting(w http.ResponseWriter, orderLine domain.OrderLine) {
+	result, err := s.bus.Handle(commands.AllocatePreempting{
+		OrderID:  orderLine.OrderID,
+		Sku:      orderLine.Sku,
+		Quantity: orderLine.Quantity,
+		Unit:     orderLine.Unit,
+		Priority: orderLine.Priority,
+	})
+
+	if errors.As(err, &services.BackorderedError{}) {
+		writeBackordered(w, err)
+		return
+	}
 
 	if err != nil {
 		w.WriteHeader(http.StatusUnprocessableEntity)
@@ -37,8 +169,336 @@ func (s *Server) AllocationsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	allocation := result.(domain.PreemptiveAllocation)
+	displacements := []displacementResponse{}
+	for _, displacement := range allocation.Displacements {
+		displacements = append(displacements, displacementResponse{
+			OrderID:      displacement.OrderLine.OrderID,
+			Quantity:     displacement.OrderLine.Quantity,
+			FromBatchRef: displacement.FromBatchRef,
+			ToBatchRef:   displacement.ToBatchRef,
+		})
+	}
+
 	w.WriteHeader(201)
-	fmt.Fprintf(w, `{"batchRef": %q}`, string(batchRef))
+	json.NewEncoder(w).Encode(map[string]any{"batchRef": allocation.BatchRef, "displacements": displacements})
+}
+
+// writeBackordered accepts an order line that has been backordered, it is allocated once stock arrives
+func writeBackordered(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"message": %q, "backordered": true}`, err)
+}
+
+type orderRequest struct {
+	OrderID domain.Reference
+	Lines   []domain.OrderLine
+	Mode    domain.AllocationMode
+}
+
+type lineAllocationResponse struct {
+	Sku      domain.Sku       `json:"sku"`
+	Quantity int              `json:"quantity"`
+	BatchRef domain.Reference `json:"batchRef,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// OrdersHandler allocates every line of an order and responds with the outcome of each line
+func (s *Server) OrdersHandler(w http.ResponseWriter, r *http.Request) {
+	var order orderRequest
+
+	err := json.NewDecoder(r.Body).Decode(&order)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	result, err := s.bus.Handle(commands.AllocateOrder{
+		OrderID: order.OrderID,
+		Lines:   order.Lines,
+		Mode:    order.Mode,
+	})
+
+	var notAllocatedError services.OrderNotAllocatedError
+	if errors.As(err, &notAllocatedError) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{"message": err.Error(), "lines": lineAllocationsResponse(notAllocatedError.Lines)})
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{"lines": lineAllocationsResponse(result.([]domain.LineAllocation))})
+}
+
+func lineAllocationsResponse(lineAllocations []domain.LineAllocation) []lineAllocationResponse {
+	var lines []lineAllocationResponse
+	for _, lineAllocation := range lineAllocations {
+		line := lineAllocationResponse{Sku: lineAllocation.Sku, Quantity: lineAllocation.Quantity, BatchRef: lineAllocation.BatchRef}
+		if lineAllocation.Err != nil {
+			line.Error = lineAllocation.Err.Error()
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// AmendOrderLineHandler changes the quantity of an allocated order line and responds with the batch it is now in
+func (s *Server) AmendOrderLineHandler(w http.ResponseWriter, r *http.Request) {
+	var orderLine domain.OrderLine
+
+	err := json.NewDecoder(r.Body).Decode(&orderLine)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	result, err := s.bus.Handle(commands.AmendOrderLine{
+		OrderID:  orderLine.OrderID,
+		Sku:      orderLine.Sku,
+		Quantity: orderLine.Quantity,
+		Unit:     orderLine.Unit,
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, `{"batchRef": %q}`, string(result.(domain.Reference)))
+}
+
+// CancelOrderHandler cancels the order given by the orderId query parameter and responds with every part of its lines
+// that was deallocated
+func (s *Server) CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	orderID := domain.Reference(r.URL.Query().Get("orderId"))
+	result, err := s.bus.Handle(commands.CancelOrder{OrderID: orderID})
+
+	if errors.As(err, &services.UnknownOrderError{}) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	lines := lineAllocationsResponse(result.([]domain.LineAllocation))
+	if lines == nil {
+		lines = []lineAllocationResponse{}
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{"orderId": orderID, "deallocated": lines})
+}
+
+type simulationRequest struct {
+	Orders []orderRequest
+	Mode   domain.AllocationMode
+}
+
+type simulatedOrderResponse struct {
+	OrderID domain.Reference         `json:"orderId"`
+	Lines   []lineAllocationResponse `json:"lines"`
+}
+
+// SimulationsHandler reports how the orders would be allocated if they arrived now without changing any stock
+func (s *Server) SimulationsHandler(w http.ResponseWriter, r *http.Request) {
+	var simulation simulationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&simulation)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	var orders []domain.Order
+	for _, order := range simulation.Orders {
+		orders = append(orders, domain.NewOrder(order.OrderID, order.Lines...))
+	}
+	simulated, err := s.queries.Simulate(orders, simulation.Mode)
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	response := []simulatedOrderResponse{}
+	for _, order := range simulated {
+		response = append(response, simulatedOrderResponse{OrderID: order.OrderID, Lines: lineAllocationsResponse(order.Lines)})
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{"orders": response})
+}
+
+// BundlesHandler responds with the number of whole bundles of the sku given by the sku query parameter
+// that can be made up from stock
+func (s *Server) BundlesHandler(w http.ResponseWriter, r *http.Request) {
+	sku := domain.Sku(r.URL.Query().Get("sku"))
+	available, err := s.queries.BundleAvailability(sku)
+
+	if errors.As(err, &services.UnknownBundleError{}) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(200)
+	fmt.Fprintf(w, `{"sku": %q, "available": %d}`, sku, available)
+}
+
+// AvailabilityHandler responds with the earliest date the quantity of the sku given by the sku, quantity and unit
+// query parameters could be dispatched, without allocating it
+func (s *Server) AvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	sku := domain.Sku(r.URL.Query().Get("sku"))
+	quantity, err := strconv.Atoi(r.URL.Query().Get("quantity"))
+
+	if err != nil || quantity <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"message": %q}`, "quantity must be a positive whole number")
+		return
+	}
+
+	promise, err := s.queries.DeliveryPromise(sku, quantity, domain.Unit(r.URL.Query().Get("unit")))
+
+	if errors.As(err, &services.InvalidSkuError{}) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(struct {
+		Sku      domain.Sku `json:"sku"`
+		Quantity int        `json:"quantity"`
+		promiseResponse
+	}{Sku: sku, Quantity: quantity, promiseResponse: newPromiseResponse(promise)})
+}
+
+type recallRequest struct {
+	RecallID  domain.Reference
+	BatchRefs []domain.Reference
+	Policy    domain.RecallPolicy
+}
+
+type recalledLineResponse struct {
+	OrderID    domain.Reference `json:"orderId"`
+	Sku        domain.Sku       `json:"sku"`
+	Quantity   int              `json:"quantity"`
+	BatchRef   domain.Reference `json:"batchRef"`
+	ToBatchRef domain.Reference `json:"toBatchRef,omitempty"`
+}
+
+type recallReportResponse struct {
+	RecallID   domain.Reference       `json:"recallId"`
+	Policy     domain.RecallPolicy    `json:"policy"`
+	RecalledAt time.Time              `json:"recalledAt"`
+	BatchRefs  []domain.Reference     `json:"batchRefs"`
+	OrderIDs   []domain.Reference     `json:"orderIds"`
+	Lines      []recalledLineResponse `json:"lines"`
+}
+
+// RecallsHandler recalls the batches and responds with the report of the recall
+func (s *Server) RecallsHandler(w http.ResponseWriter, r *http.Request) {
+	var recall recallRequest
+
+	err := json.NewDecoder(r.Body).Decode(&recall)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	result, err := s.bus.Handle(commands.Recall{
+		RecallID:  recall.RecallID,
+		BatchRefs: recall.BatchRefs,
+		Policy:    recall.Policy,
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(recallResponse(result.(domain.RecallReport)))
+}
+
+// RecallReportHandler responds with the report of the recall given by the recallId query parameter
+func (s *Server) RecallReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.queries.RecallReport(domain.Reference(r.URL.Query().Get("recallId")))
+
+	if errors.As(err, &services.UnknownRecallError{}) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"message": %q}`, err)
+		return
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(recallResponse(report))
+}
+
+func recallResponse(report domain.RecallReport) recallReportResponse {
+	response := recallReportResponse{
+		RecallID:   report.RecallID,
+		Policy:     report.Policy,
+		RecalledAt: report.RecalledAt,
+		BatchRefs:  []domain.Reference{},
+		OrderIDs:   report.OrderIDs(),
+		Lines:      []recalledLineResponse{},
+	}
+	if response.OrderIDs == nil {
+		response.OrderIDs = []domain.Reference{}
+	}
+	for _, batch := range report.Batches {
+		response.BatchRefs = append(response.BatchRefs, batch.Reference)
+	}
+	for _, line := range report.Lines {
+		response.Lines = append(response.Lines, recalledLineResponse{
+			OrderID:    line.OrderID,
+			Sku:        line.Sku,
+			Quantity:   line.Quantity,
+			BatchRef:   line.BatchRef,
+			ToBatchRef: line.ToBatchRef,
+		})
+	}
+	return response
 }
 
 func (s *Server) StocksHandler(w http.ResponseWriter, r *http.Request) {
@@ -52,7 +512,17 @@ func (s *Server) StocksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = s.service.AddBatch(batch.Reference, batch.Sku, batch.Quantity, batch.ETA); err != nil {
+	_, err = s.bus.Handle(commands.CreateBatch{
+		Reference:  batch.Reference,
+		Sku:        batch.Sku,
+		Quantity:   batch.Quantity,
+		Unit:       batch.Unit,
+		ETA:        batch.ETA,
+		BestBefore: batch.BestBefore,
+		Warehouse:  batch.Warehouse,
+	})
+
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, `{"message": %q}`, err)
 		return
@@ -67,16 +67,14 @@ func TestAPI(t *testing.T) {
 
 		earlyBatchRef := randomBatchRef(t, "earlyBatchRef")
 
-		repo := repos.NewFakeRepository(
+		uow := repos.NewFakeUnitOfWork(
 			repos.WithBatch(earlyBatchRef, sku, 100, time.Time{}.AddDate(2025, 2, 21)),
 			repos.WithBatch(randomBatchRef(t, "random"), sku, 100, time.Time{}.AddDate(2025, 4, 22)),
 			repos.WithBatch(randomBatchRef(t, "random"), otherSku, 100, time.Time{}.AddDate(2025, 5, 21)),
 		)
 
-		service := services.NewStockService(repo)
-
 		server := Server{
-			service: &service,
+			bus: services.NewMessageBus(uow),
 		}
 
 		orderJson := generateOrderLineJson(t, randomOrderId(t, "random"), sku, 10)
@@ -97,15 +95,13 @@ func TestAPI(t *testing.T) {
 		orderId := randomOrderId(t, "")
 		order1 := generateOrderLineJson(t, orderId, unknownSku, 10)
 
-		repo := repos.NewFakeRepository(
+		uow := repos.NewFakeUnitOfWork(
 			repos.WithBatch(randomBatchRef(t, ""), randomSku(t, ""), 10, time.Time{}.AddDate(2025, 2, 21)),
 			repos.WithBatch(randomBatchRef(t, ""), randomSku(t, ""), 10, time.Time{}.AddDate(2025, 2, 21)),
 		)
 
-		service := services.NewStockService(repo)
-
 		server := Server{
-			service: &service,
+			bus: services.NewMessageBus(uow),
 		}
 
 		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(order1))
@@ -122,4 +118,491 @@ func TestAPI(t *testing.T) {
 
 		assert.Contains(t, responseRecord["message"], fmt.Sprintf("%s sku is invalid", unknownSku))
 	})
+
+	t.Run("stocks handler adds batch that can then be allocated", func(t *testing.T) {
+		sku := randomSku(t, "")
+		batchRef := randomBatchRef(t, "")
+
+		uow := repos.NewFakeUnitOfWork()
+
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		batchJson, err := json.Marshal(domain.Batch{Reference: batchRef, Sku: sku, Quantity: 100})
+		assert.Nil(t, err)
+
+		request, _ := http.NewRequest(http.MethodPost, "/stocks", bytes.NewReader(batchJson))
+		response := httptest.NewRecorder()
+		server.StocksHandler(response, request)
+
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		orderJson := generateOrderLineJson(t, randomOrderId(t, ""), sku, 10)
+		request, _ = http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response = httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+		assert.Equal(t, string(batchRef), getBatchRef(t, response))
+	})
+
+	t.Run("split allocation returns 201 and every part of the line", func(t *testing.T) {
+		sku := randomSku(t, "")
+		earlyBatchRef := randomBatchRef(t, "early")
+		laterBatchRef := randomBatchRef(t, "later")
+
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch(earlyBatchRef, sku, 10, time.Time{}.AddDate(2025, 2, 21)),
+			repos.WithBatch(laterBatchRef, sku, 10, time.Time{}.AddDate(2025, 4, 22)),
+		)
+
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		orderJson := generateOrderLineJson(t, randomOrderId(t, ""), sku, 15)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate?split=true", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		var body struct {
+			Allocations []batchAllocationResponse `json:"allocations"`
+		}
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, []batchAllocationResponse{{BatchRef: earlyBatchRef, Quantity: 10}, {BatchRef: laterBatchRef, Quantity: 5}}, body.Allocations)
+	})
+	t.Run("orders handler returns 201 and the allocation of every line", func(t *testing.T) {
+		tableSku, lampSku := randomSku(t, "table"), randomSku(t, "lamp")
+		tableBatchRef, lampBatchRef := randomBatchRef(t, "table"), randomBatchRef(t, "lamp")
+
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch(tableBatchRef, tableSku, 10, time.Time{}),
+			repos.WithBatch(lampBatchRef, lampSku, 5, time.Time{}),
+		)
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		orderJson, _ := json.Marshal(orderRequest{
+			OrderID: randomOrderId(t, ""),
+			Lines:   []domain.OrderLine{{Sku: tableSku, Quantity: 4}, {Sku: lampSku, Quantity: 8}},
+			Mode:    domain.BestEffort,
+		})
+		request, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.OrdersHandler(response, request)
+
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		var body struct {
+			Lines []lineAllocationResponse `json:"lines"`
+		}
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, lineAllocationResponse{Sku: tableSku, Quantity: 4, BatchRef: tableBatchRef}, body.Lines[0])
+		assert.Empty(t, body.Lines[1].BatchRef)
+		assert.Contains(t, body.Lines[1].Error, "out of stock")
+	})
+
+	t.Run("orders handler returns 422 when an all-or-nothing order cannot be allocated", func(t *testing.T) {
+		tableSku, lampSku := randomSku(t, "table"), randomSku(t, "lamp")
+
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch(randomBatchRef(t, "table"), tableSku, 10, time.Time{}),
+			repos.WithBatch(randomBatchRef(t, "lamp"), lampSku, 5, time.Time{}),
+		)
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		orderJson, _ := json.Marshal(orderRequest{
+			OrderID: randomOrderId(t, ""),
+			Lines:   []domain.OrderLine{{Sku: tableSku, Quantity: 4}, {Sku: lampSku, Quantity: 8}},
+		})
+		request, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.OrdersHandler(response, request)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Result().StatusCode)
+
+		var body struct {
+			Message string                   `json:"message"`
+			Lines   []lineAllocationResponse `json:"lines"`
+		}
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Contains(t, body.Message, "could not be allocated")
+		assert.Empty(t, body.Lines[0].BatchRef)
+		assert.Contains(t, body.Lines[1].Error, "out of stock")
+	})
+	t.Run("out of stock returns 202 and backorders the line", func(t *testing.T) {
+		sku := randomSku(t, "")
+		batchRef := randomBatchRef(t, "")
+
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch(batchRef, sku, 5, time.Time{}))
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		orderJson := generateOrderLineJson(t, randomOrderId(t, ""), sku, 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+
+		assert.Equal(t, http.StatusAccepted, response.Result().StatusCode)
+
+		responseRecord := make(map[string]any)
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &responseRecord))
+		assert.Equal(t, true, responseRecord["backordered"])
+
+		product, _ := uow.GetProduct(sku)
+		assert.Len(t, product.Backorders, 1)
+	})
+	t.Run("preemptive allocation returns 201 and every displaced line", func(t *testing.T) {
+		sku := randomSku(t, "")
+		inStockBatchRef := randomBatchRef(t, "in-stock")
+		shipmentBatchRef := randomBatchRef(t, "shipment")
+		bulkOrderId := randomOrderId(t, "bulk")
+
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch(inStockBatchRef, sku, 10, time.Time{}),
+			repos.WithBatch(shipmentBatchRef, sku, 10, time.Time{}.AddDate(2025, 4, 22)),
+		)
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		bulkJson, _ := json.Marshal(domain.OrderLine{OrderID: bulkOrderId, Sku: sku, Quantity: 8, Priority: domain.Bulk})
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(bulkJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		expressJson, _ := json.Marshal(domain.OrderLine{OrderID: randomOrderId(t, "express"), Sku: sku, Quantity: 5, Priority: domain.Express})
+		request, _ = http.NewRequest(http.MethodPost, "/allocate?preempt=true", bytes.NewReader(expressJson))
+		response = httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		var body struct {
+			BatchRef      domain.Reference       `json:"batchRef"`
+			Displacements []displacementResponse `json:"displacements"`
+		}
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, inStockBatchRef, body.BatchRef)
+		assert.Equal(t, []displacementResponse{{OrderID: bulkOrderId, Quantity: 8, FromBatchRef: inStockBatchRef, ToBatchRef: shipmentBatchRef}}, body.Displacements)
+	})
+
+	t.Run("region allocation prefers the warehouse nearest the region", func(t *testing.T) {
+		sku := randomSku(t, "")
+		leedsBatchRef := randomBatchRef(t, "leeds")
+		londonBatchRef := randomBatchRef(t, "london")
+
+		uow := repos.NewFakeUnitOfWork()
+		server := Server{
+			bus: services.NewMessageBus(uow, services.WithWarehousePreferences(domain.WarehousePreferences{
+				"north": {"leeds", "london"},
+				"south": {"london", "leeds"},
+			})),
+		}
+
+		for _, batch := range []domain.Batch{
+			{Reference: londonBatchRef, Sku: sku, Quantity: 100, Warehouse: "london"},
+			{Reference: leedsBatchRef, Sku: sku, Quantity: 100, Warehouse: "leeds"},
+		} {
+			batchJson, err := json.Marshal(batch)
+			assert.Nil(t, err)
+			request, _ := http.NewRequest(http.MethodPost, "/stocks", bytes.NewReader(batchJson))
+			response := httptest.NewRecorder()
+			server.StocksHandler(response, request)
+			assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+		}
+
+		orderJson := generateOrderLineJson(t, randomOrderId(t, ""), sku, 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate?region=north", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+		assert.Equal(t, string(leedsBatchRef), getBatchRef(t, response))
+	})
+
+	t.Run("recalls handler returns 201 and the report that can be queried later", func(t *testing.T) {
+		sku := randomSku(t, "")
+		batchRef := randomBatchRef(t, "")
+		orderId := randomOrderId(t, "")
+
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch(batchRef, sku, 100, time.Time{}))
+		queries := services.NewStockService(uow)
+		server := Server{
+			bus:     services.NewMessageBus(uow),
+			queries: &queries,
+		}
+
+		orderJson := generateOrderLineJson(t, orderId, sku, 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		recallJson, err := json.Marshal(map[string]any{"recallId": "recall-001", "batchRefs": []domain.Reference{batchRef}, "policy": domain.FlagRecalled})
+		assert.Nil(t, err)
+		request, _ = http.NewRequest(http.MethodPost, "/recalls", bytes.NewReader(recallJson))
+		response = httptest.NewRecorder()
+		server.RecallsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		request, _ = http.NewRequest(http.MethodGet, "/recalls?recallId=recall-001", nil)
+		response = httptest.NewRecorder()
+		server.RecallReportHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+
+		var body recallReportResponse
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, domain.Reference("recall-001"), body.RecallID)
+		assert.Equal(t, []domain.Reference{batchRef}, body.BatchRefs)
+		assert.Equal(t, []domain.Reference{orderId}, body.OrderIDs)
+		assert.Equal(t, []recalledLineResponse{{OrderID: orderId, Sku: sku, Quantity: 10, BatchRef: batchRef}}, body.Lines)
+	})
+
+	t.Run("recall report handler returns 404 for an unknown recall", func(t *testing.T) {
+		queries := services.NewStockService(repos.NewFakeUnitOfWork())
+		server := Server{
+			queries: &queries,
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/recalls?recallId=recall-404", nil)
+		response := httptest.NewRecorder()
+		server.RecallReportHandler(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Result().StatusCode)
+	})
+
+	t.Run("allocations handler allocates every component of a bundle", func(t *testing.T) {
+		giftSet := domain.Bundle{Sku: "GIFT-SET", Components: []domain.BundleComponent{{Sku: "RETRO-CLOCK", Quantity: 1}, {Sku: "BLUE-LAMP", Quantity: 2}}}
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		queries := services.NewStockService(uow, services.WithBundle(giftSet))
+		server := Server{
+			bus:     services.NewMessageBus(uow, services.WithBundle(giftSet)),
+			queries: &queries,
+		}
+
+		orderJson := generateOrderLineJson(t, "order-001", "GIFT-SET", 2)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		var body struct{ Components []lineAllocationResponse }
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Equal(t, []lineAllocationResponse{
+			{Sku: "RETRO-CLOCK", Quantity: 2, BatchRef: "clock-batch"},
+			{Sku: "BLUE-LAMP", Quantity: 4, BatchRef: "lamp-batch"},
+		}, body.Components)
+
+		request, _ = http.NewRequest(http.MethodGet, "/bundles?sku=GIFT-SET", nil)
+		response = httptest.NewRecorder()
+		server.BundlesHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+		assert.JSONEq(t, `{"sku": "GIFT-SET", "available": 3}`, response.Body.String())
+	})
+
+	t.Run("bundles handler returns 404 for a sku that is not a bundle", func(t *testing.T) {
+		queries := services.NewStockService(repos.NewFakeUnitOfWork())
+		server := Server{
+			queries: &queries,
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/bundles?sku=RETRO-CLOCK", nil)
+		response := httptest.NewRecorder()
+		server.BundlesHandler(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Result().StatusCode)
+	})
+
+	t.Run("allocations handler reports the sku substituted for a customer", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("retro-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("modern-batch", "MODERN-CLOCK", 20, time.Time{}),
+		)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		server := Server{
+			bus: services.NewMessageBus(uow,
+				services.WithSubstitutes("RETRO-CLOCK", "MODERN-CLOCK"),
+				services.WithSubstitutionOptIn("customer-001"),
+				services.WithClock(func() time.Time { return now }),
+			),
+		}
+
+		orderJson := generateOrderLineJson(t, "order-001", "RETRO-CLOCK", 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate?customerId=customer-001", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+		assert.JSONEq(t, `{
+			"batchRef": "modern-batch",
+			"sku": "MODERN-CLOCK",
+			"substituted": true,
+			"eta": "in stock",
+			"dispatchDate": "2024-01-01T00:00:00Z"
+		}`, response.Body.String())
+	})
+
+	t.Run("cancel order handler deallocates every line of the order", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 20, time.Time{}))
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		orderJson := generateOrderLineJson(t, "order-001", "RETRO-CLOCK", 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		request, _ = http.NewRequest(http.MethodDelete, "/orders?orderId=order-001", nil)
+		response = httptest.NewRecorder()
+		server.CancelOrderHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+		assert.JSONEq(t, `{"orderId": "order-001", "deallocated": [{"sku": "RETRO-CLOCK", "quantity": 10, "batchRef": "batch-001"}]}`, response.Body.String())
+	})
+
+	t.Run("cancel order handler returns 404 for an unknown order", func(t *testing.T) {
+		server := Server{
+			bus: services.NewMessageBus(repos.NewFakeUnitOfWork()),
+		}
+
+		request, _ := http.NewRequest(http.MethodDelete, "/orders?orderId=order-404", nil)
+		response := httptest.NewRecorder()
+		server.CancelOrderHandler(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Result().StatusCode)
+	})
+
+	t.Run("amend order line handler changes the quantity of an allocated line", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 20, time.Time{}))
+		server := Server{
+			bus: services.NewMessageBus(uow),
+		}
+
+		orderJson := generateOrderLineJson(t, "order-001", "RETRO-CLOCK", 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+
+		amendJson := generateOrderLineJson(t, "order-001", "RETRO-CLOCK", 15)
+		request, _ = http.NewRequest(http.MethodPatch, "/allocate", bytes.NewReader(amendJson))
+		response = httptest.NewRecorder()
+		server.AmendOrderLineHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+		assert.JSONEq(t, `{"batchRef": "batch-001"}`, response.Body.String())
+
+		amendJson = generateOrderLineJson(t, "order-001", "RETRO-CLOCK", 25)
+		request, _ = http.NewRequest(http.MethodPatch, "/allocate", bytes.NewReader(amendJson))
+		response = httptest.NewRecorder()
+		server.AmendOrderLineHandler(response, request)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Result().StatusCode)
+	})
+
+	t.Run("simulations handler reports the outcome of every line without allocating", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 20, time.Time{}))
+		queries := services.NewStockService(uow)
+		server := Server{
+			queries: &queries,
+		}
+
+		simulationJson, err := json.Marshal(map[string]any{
+			"mode": domain.BestEffort,
+			"orders": []map[string]any{
+				{"orderId": "order-001", "lines": []map[string]any{{"sku": "RETRO-CLOCK", "quantity": 15}}},
+				{"orderId": "order-002", "lines": []map[string]any{{"sku": "RETRO-CLOCK", "quantity": 10}}},
+			},
+		})
+		assert.Nil(t, err)
+		request, _ := http.NewRequest(http.MethodPost, "/simulations", bytes.NewReader(simulationJson))
+		response := httptest.NewRecorder()
+		server.SimulationsHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+
+		var body struct{ Orders []simulatedOrderResponse }
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+		assert.Len(t, body.Orders, 2)
+		assert.Equal(t, []lineAllocationResponse{{Sku: "RETRO-CLOCK", Quantity: 15, BatchRef: "batch-001"}}, body.Orders[0].Lines)
+		assert.Contains(t, body.Orders[1].Lines[0].Error, "out of stock")
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 20, batch.AvailableQuantity())
+	})
+
+	t.Run("allocations handler promises the dispatch date of the batch", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)))
+		server := Server{
+			bus: services.NewMessageBus(uow,
+				services.WithClock(func() time.Time { return now }),
+				services.WithLeadTime(domain.LeadTime{Sku: "RETRO-CLOCK", Inbound: 48 * time.Hour}),
+			),
+		}
+
+		orderJson := generateOrderLineJson(t, "order-001", "RETRO-CLOCK", 10)
+		request, _ := http.NewRequest(http.MethodPost, "/allocate", bytes.NewReader(orderJson))
+		response := httptest.NewRecorder()
+		server.AllocationsHandler(response, request)
+		assert.Equal(t, http.StatusCreated, response.Result().StatusCode)
+		assert.JSONEq(t, `{
+			"batchRef": "batch-001",
+			"sku": "RETRO-CLOCK",
+			"substituted": false,
+			"eta": "2024-01-08T00:00:00Z",
+			"dispatchDate": "2024-01-10T00:00:00Z"
+		}`, response.Body.String())
+	})
+
+	t.Run("availability handler promises the earliest dispatch date without allocating", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		)
+		queries := services.NewStockService(uow,
+			services.WithClock(func() time.Time { return now }),
+			services.WithLeadTime(domain.LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}),
+		)
+		server := Server{
+			queries: &queries,
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/availability?sku=RETRO-CLOCK&quantity=5", nil)
+		response := httptest.NewRecorder()
+		server.AvailabilityHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+		assert.JSONEq(t, `{"sku": "RETRO-CLOCK", "quantity": 5, "eta": "in stock", "dispatchDate": "2024-01-02T00:00:00Z"}`, response.Body.String())
+
+		request, _ = http.NewRequest(http.MethodGet, "/availability?sku=RETRO-CLOCK&quantity=10", nil)
+		response = httptest.NewRecorder()
+		server.AvailabilityHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Result().StatusCode)
+		assert.JSONEq(t, `{"sku": "RETRO-CLOCK", "quantity": 10, "eta": "2024-01-08T00:00:00Z", "dispatchDate": "2024-01-10T00:00:00Z"}`, response.Body.String())
+
+		request, _ = http.NewRequest(http.MethodGet, "/availability?sku=RETRO-CLOCK&quantity=30", nil)
+		response = httptest.NewRecorder()
+		server.AvailabilityHandler(response, request)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Result().StatusCode)
+
+		request, _ = http.NewRequest(http.MethodGet, "/availability?sku=BLUE-LAMP&quantity=1", nil)
+		response = httptest.NewRecorder()
+		server.AvailabilityHandler(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Result().StatusCode)
+
+		request, _ = http.NewRequest(http.MethodGet, "/availability?sku=RETRO-CLOCK", nil)
+		response = httptest.NewRecorder()
+		server.AvailabilityHandler(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Result().StatusCode)
+
+		batch, _ := uow.GetBatch("in-stock-batch")
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+	})
 }
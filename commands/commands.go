@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+)
+
+// Command is a request for the system to do something, it is handled by exactly one handler
+type Command interface {
+	command()
+}
+
+// CreateBatch adds a batch of stock, a zero BestBefore is stock that does not expire
+// and a Quantity without a Unit is in the base unit of the sku
+type CreateBatch struct {
+	Reference  domain.Reference
+	Sku        domain.Sku
+	Quantity   int
+	Unit       domain.Unit
+	ETA        time.Time
+	BestBefore time.Time
+	Warehouse  domain.Warehouse
+}
+
+// Allocate allocates an order line, preferring the warehouses nearest the Region if one is given.
+// An out of stock line may be substituted when the CustomerID has opted in to substitutes.
+type Allocate struct {
+	OrderID    domain.Reference
+	CustomerID domain.Reference
+	Sku        domain.Sku
+	Quantity   int
+	Unit       domain.Unit
+	Priority   domain.Priority
+	Region     domain.Region
+}
+
+// AllocatePreempting allows the order line to displace lines of a lower priority from warehouse stock
+type AllocatePreempting struct {
+	OrderID  domain.Reference
+	Sku      domain.Sku
+	Quantity int
+	Unit     domain.Unit
+	Priority domain.Priority
+}
+
+// AllocateSplit opts in to splitting the order line across several batches when no single batch can take it
+type AllocateSplit struct {
+	OrderID  domain.Reference
+	Sku      domain.Sku
+	Quantity int
+	Unit     domain.Unit
+	Priority domain.Priority
+}
+
+type ChangeBatchQuantity struct {
+	Reference domain.Reference
+	Quantity  int
+}
+
+type Deallocate struct {
+	OrderID  domain.Reference
+	Sku      domain.Sku
+	Quantity int
+	Unit     domain.Unit
+	Priority domain.Priority
+	BatchRef domain.Reference
+}
+
+// DeallocateOrderLine removes every part of the order's line of the sku, wherever it was allocated
+type DeallocateOrderLine struct {
+	OrderID domain.Reference
+	Sku     domain.Sku
+}
+
+// AllocateOrder allocates every line of an order, an empty Mode means all-or-nothing
+type AllocateOrder struct {
+	OrderID domain.Reference
+	Lines   []domain.OrderLine
+	Mode    domain.AllocationMode
+}
+
+// Hold reserves stock for the order line for Duration, a zero Duration uses the default hold duration
+type Hold struct {
+	OrderID  domain.Reference
+	Sku      domain.Sku
+	Quantity int
+	Duration time.Duration
+}
+
+type ConfirmHold struct {
+	OrderID domain.Reference
+	Sku     domain.Sku
+}
+
+// ReleaseExpiredHolds releases every hold that has expired
+type ReleaseExpiredHolds struct{}
+
+// ReviewExpiry flags the batches expiring within Warning and empties the expired ones, a zero Warning uses the default
+type ReviewExpiry struct {
+	Warning time.Duration
+}
+
+// QuarantineBatch stops the batch from being allocated to and moves its order lines to other batches
+type QuarantineBatch struct {
+	Reference domain.Reference
+}
+
+// ChangeBatchStatus sets the status of the batch, an available batch can be allocated to again
+type ChangeBatchStatus struct {
+	Reference domain.Reference
+	Status    domain.BatchStatus
+}
+
+// Recall marks the batches as recalled and deals with their order lines according to the Policy
+type Recall struct {
+	RecallID  domain.Reference
+	BatchRefs []domain.Reference
+	Policy    domain.RecallPolicy
+}
+
+// TransferStock moves Quantity of the batch to a new batch with the TransferRef, in transit to the Destination until the ETA
+type TransferStock struct {
+	Reference   domain.Reference
+	TransferRef domain.Reference
+	Quantity    int
+	Destination domain.Warehouse
+	ETA         time.Time
+}
+
+// CancelOrder takes every line of the order off the stock
+type CancelOrder struct {
+	OrderID domain.Reference
+}
+
+// AmendOrderLine changes the quantity of an allocated order line, a Quantity without a Unit is in the base unit of the sku
+type AmendOrderLine struct {
+	OrderID  domain.Reference
+	Sku      domain.Sku
+	Quantity int
+	Unit     domain.Unit
+}
+
+// Rebalance reallocates the order lines of the sku so that as many of them as possible are fulfilled
+type Rebalance struct {
+	Sku domain.Sku
+}
+
+func (CreateBatch) command()         {}
+func (Allocate) command()            {}
+func (AllocatePreempting) command()  {}
+func (AllocateSplit) command()       {}
+func (ChangeBatchQuantity) command() {}
+func (Deallocate) command()          {}
+func (DeallocateOrderLine) command() {}
+func (AllocateOrder) command()       {}
+func (Hold) command()                {}
+func (ConfirmHold) command()         {}
+func (ReleaseExpiredHolds) command() {}
+func (ReviewExpiry) command()        {}
+func (QuarantineBatch) command()     {}
+func (ChangeBatchStatus) command()   {}
+func (Recall) command()              {}
+func (TransferStock) command()       {}
+func (CancelOrder) command()         {}
+func (AmendOrderLine) command()      {}
+func (Rebalance) command()           {}
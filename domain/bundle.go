@@ -0,0 +1,77 @@
+package domain
+
+import "fmt"
+
+// BundleComponent is the quantity of a sku that goes into every bundle, a Quantity without a Unit is in the base
+// unit of the sku
+type BundleComponent struct {
+	Sku      Sku
+	Quantity int
+	Unit     Unit
+}
+
+// Bundle is a sku that is not stocked itself but made up of the stock of its components
+type Bundle struct {
+	Sku        Sku
+	Components []BundleComponent
+}
+
+// Validate checks that the bundle has components, that each is a positive quantity of another sku
+// and that no sku is a component twice
+func (b Bundle) Validate() error {
+	if len(b.Components) == 0 {
+		return fmt.Errorf("bundle %s has no components", b.Sku)
+	}
+	skus := make(map[Sku]bool, len(b.Components))
+	for _, component := range b.Components {
+		if component.Sku == b.Sku {
+			return fmt.Errorf("bundle %s cannot be a component of itself", b.Sku)
+		}
+		if component.Quantity <= 0 {
+			return fmt.Errorf("component %s of bundle %s must have a positive quantity", component.Sku, b.Sku)
+		}
+		if skus[component.Sku] {
+			return fmt.Errorf("bundle %s has more than one component of %s", b.Sku, component.Sku)
+		}
+		skus[component.Sku] = true
+	}
+	return nil
+}
+
+// Lines returns the order lines of the components needed for an order line of the bundle.
+// Bundles are only counted in whole bundles, so a line in any other unit cannot be converted.
+func (b Bundle) Lines(orderLine OrderLine) ([]OrderLine, error) {
+	if orderLine.Unit != "" {
+		return nil, UnitConversionError{Sku: b.Sku, Quantity: orderLine.Quantity, From: orderLine.Unit}
+	}
+	lines := make([]OrderLine, 0, len(b.Components))
+	for _, component := range b.Components {
+		lines = append(lines, OrderLine{
+			OrderID:  orderLine.OrderID,
+			Sku:      component.Sku,
+			Quantity: orderLine.Quantity * component.Quantity,
+			Unit:     component.Unit,
+			Priority: orderLine.Priority,
+		})
+	}
+	return lines, nil
+}
+
+// Available returns the number of whole bundles that can be made up from the available stock of the component
+// products, a component without a product has no stock
+func (b Bundle) Available(products map[Sku]*Product) (int, error) {
+	var available int
+	for i, component := range b.Components {
+		var quantity int
+		if product := products[component.Sku]; product != nil {
+			var err error
+			if quantity, err = product.AvailableQuantity(component.Unit, RoundDown); err != nil {
+				return 0, fmt.Errorf("could not count component %s of bundle %s: %w", component.Sku, b.Sku, err)
+			}
+		}
+		if bundles := quantity / component.Quantity; i == 0 || bundles < available {
+			available = bundles
+		}
+	}
+	return available, nil
+}
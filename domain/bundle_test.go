@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var giftSet = Bundle{Sku: "GIFT-SET", Components: []BundleComponent{
+	{Sku: "RETRO-CLOCK", Quantity: 1},
+	{Sku: "BLUE-LAMP", Quantity: 2},
+}}
+
+func TestBundle_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		bundle Bundle
+	}{
+		{name: "no components", bundle: Bundle{Sku: "GIFT-SET"}},
+		{name: "a component of itself", bundle: Bundle{Sku: "GIFT-SET", Components: []BundleComponent{{Sku: "GIFT-SET", Quantity: 1}}}},
+		{name: "a component without quantity", bundle: Bundle{Sku: "GIFT-SET", Components: []BundleComponent{{Sku: "BLUE-LAMP"}}}},
+		{name: "a component twice", bundle: Bundle{Sku: "GIFT-SET", Components: []BundleComponent{{Sku: "BLUE-LAMP", Quantity: 1}, {Sku: "BLUE-LAMP", Quantity: 2}}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run("returns error for "+testCase.name, func(t *testing.T) {
+			assert.Error(t, testCase.bundle.Validate())
+		})
+	}
+
+	t.Run("accepts a bundle of other skus", func(t *testing.T) {
+		assert.Nil(t, giftSet.Validate())
+	})
+}
+
+func TestBundle_Lines(t *testing.T) {
+	t.Run("returns a line of every component for the bundles ordered", func(t *testing.T) {
+		lines, err := giftSet.Lines(OrderLine{OrderID: "order-001", Sku: "GIFT-SET", Quantity: 3, Priority: Express})
+		assert.Nil(t, err)
+		assert.Equal(t, []OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3, Priority: Express},
+			{OrderID: "order-001", Sku: "BLUE-LAMP", Quantity: 6, Priority: Express},
+		}, lines)
+	})
+
+	t.Run("returns error for a line in a unit", func(t *testing.T) {
+		_, err := giftSet.Lines(OrderLine{OrderID: "order-001", Sku: "GIFT-SET", Quantity: 1, Unit: "case"})
+		assert.ErrorAs(t, err, &UnitConversionError{})
+	})
+}
+
+func TestBundle_Available(t *testing.T) {
+	clocks := NewProduct("RETRO-CLOCK", []Batch{NewBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{})})
+	lamps := NewProduct("BLUE-LAMP", []Batch{NewBatch("lamp-batch", "BLUE-LAMP", 9, time.Time{})})
+
+	t.Run("counts the bundles the scarcest component can make up", func(t *testing.T) {
+		available, err := giftSet.Available(map[Sku]*Product{"RETRO-CLOCK": &clocks, "BLUE-LAMP": &lamps})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, available)
+	})
+
+	t.Run("counts components in their unit", func(t *testing.T) {
+		caseOfLamps := Bundle{Sku: "LAMP-CASE", Components: []BundleComponent{{Sku: "BLUE-LAMP", Quantity: 1, Unit: "case"}}}
+		lamps := NewProduct("BLUE-LAMP", nil)
+		lamps.Units = UnitsOfMeasure{Base: "each", Factors: map[Unit]int{"case": 4}}
+		assert.Nil(t, lamps.AddBatch(NewBatch("lamp-batch", "BLUE-LAMP", 9, time.Time{})))
+
+		available, err := caseOfLamps.Available(map[Sku]*Product{"BLUE-LAMP": &lamps})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, available)
+	})
+
+	t.Run("has none available without stock of a component", func(t *testing.T) {
+		available, err := giftSet.Available(map[Sku]*Product{"RETRO-CLOCK": &clocks})
+		assert.Nil(t, err)
+		assert.Equal(t, 0, available)
+	})
+}
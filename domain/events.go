@@ -0,0 +1,172 @@
+package domain
+
+import "time"
+
+// Event is a fact recorded by an aggregate that other parts of the system can react to
+type Event interface {
+	event()
+}
+
+type BatchCreated struct {
+	Reference Reference
+	Sku       Sku
+	Quantity  int
+	ETA       time.Time
+}
+
+// BatchExpiring is recorded once for a batch when its best before date comes within the warning period
+type BatchExpiring struct {
+	Reference  Reference
+	Sku        Sku
+	BestBefore time.Time
+}
+
+// BatchExpired is recorded when a batch that still had stock allocated or held reaches its best before date
+type BatchExpired struct {
+	Reference  Reference
+	Sku        Sku
+	BestBefore time.Time
+}
+
+// StockTransferred is recorded when stock of a batch is moved to a new batch in transit to another warehouse
+type StockTransferred struct {
+	FromBatchRef Reference
+	BatchRef     Reference
+	Sku          Sku
+	Quantity     int
+	Destination  Warehouse
+	ETA          time.Time
+}
+
+// BatchStatusChanged is recorded when a batch is taken out of allocation or made available again
+type BatchStatusChanged struct {
+	Reference Reference
+	Sku       Sku
+	Status    BatchStatus
+}
+
+// OrderLineRecalled is recorded for every order line allocated to a batch when it is recalled
+type OrderLineRecalled struct {
+	OrderID  Reference
+	Sku      Sku
+	Quantity int
+	BatchRef Reference
+	Policy   RecallPolicy
+}
+
+// OrderLineAmended is recorded when the quantity of an allocated order line is changed,
+// Reallocated is true when the line had to move to another batch
+type OrderLineAmended struct {
+	OrderID     Reference
+	Sku         Sku
+	Quantity    int
+	BatchRef    Reference
+	Reallocated bool
+}
+
+// StockRebalanced is recorded when the order lines of a product are reallocated to fulfil as many as possible,
+// Moved is the number of lines that changed batch or left or joined the backorders
+type StockRebalanced struct {
+	Sku       Sku
+	Fulfilled int
+	Moved     int
+}
+
+// OrderCancelled is recorded when every line of the order is taken off the product
+type OrderCancelled struct {
+	OrderID Reference
+	Sku     Sku
+}
+
+// OrderLineSubstituted is recorded when an order line of an out of stock sku is allocated as a substitute sku instead
+type OrderLineSubstituted struct {
+	OrderID       Reference
+	CustomerID    Reference
+	Sku           Sku
+	SubstituteSku Sku
+	Quantity      int
+	BatchRef      Reference
+}
+
+type BatchQuantityChanged struct {
+	Reference Reference
+	Sku       Sku
+	Quantity  int
+}
+
+type Allocated struct {
+	OrderID  Reference
+	Sku      Sku
+	Quantity int
+	BatchRef Reference
+}
+
+type Deallocated struct {
+	OrderID  Reference
+	Sku      Sku
+	Quantity int
+	BatchRef Reference
+}
+
+type Held struct {
+	OrderID   Reference
+	Sku       Sku
+	Quantity  int
+	BatchRef  Reference
+	ExpiresAt time.Time
+}
+
+// HoldReleased is recorded when a hold gives its stock back, because it expired or its batch shrank
+type HoldReleased struct {
+	OrderID  Reference
+	Sku      Sku
+	Quantity int
+	BatchRef Reference
+}
+
+// Preempted is recorded when an order line is deallocated from a batch to make room for a line of a higher priority
+type Preempted struct {
+	OrderID     Reference
+	Sku         Sku
+	Quantity    int
+	BatchRef    Reference
+	PreemptedBy Reference
+}
+
+type OutOfStock struct {
+	Sku Sku
+}
+
+type Backordered struct {
+	OrderID  Reference
+	Sku      Sku
+	Quantity int
+}
+
+// BackorderFilled is recorded when a backordered line is allocated to a batch that now has room for it
+type BackorderFilled struct {
+	OrderID  Reference
+	Sku      Sku
+	Quantity int
+	BatchRef Reference
+}
+
+func (BatchCreated) event()         {}
+func (BatchExpiring) event()        {}
+func (BatchExpired) event()         {}
+func (StockTransferred) event()     {}
+func (BatchQuantityChanged) event() {}
+func (BatchStatusChanged) event()   {}
+func (OrderLineRecalled) event()    {}
+func (OrderLineSubstituted) event() {}
+func (OrderCancelled) event()       {}
+func (OrderLineAmended) event()     {}
+func (StockRebalanced) event()      {}
+func (Allocated) event()            {}
+func (Deallocated) event()          {}
+func (Held) event()                 {}
+func (HoldReleased) event()         {}
+func (Preempted) event()            {}
+func (OutOfStock) event()           {}
+func (Backordered) event()          {}
+func (BackorderFilled) event()      {}
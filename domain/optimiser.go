@@ -0,0 +1,313 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+)
+
+// maxOptimiserSteps bounds the search of Optimise, once reached the best assignment found so far is returned
+const maxOptimiserSteps = 200_000
+
+// Optimise assigns the order lines to the batches so that as many lines as possible are fulfilled, without splitting
+// any line. Of the assignments that fulfil the most lines, the one whose lines wait the least in total for the ETAs of
+// their batches is preferred.
+// Each batch is taken with the room it has left. It returns the reference of the batch each line is assigned to,
+// empty for the lines that cannot be fulfilled.
+func Optimise(lines []OrderLine, batches []Batch) []Reference {
+	batches = slices.Clone(batches)
+	slices.SortStableFunc(batches, compareETA)
+
+	optimiser := optimiser{
+		lines:      lines,
+		batches:    batches,
+		order:      make([]int, len(lines)),
+		room:       make([]int, len(batches)),
+		costs:      etaCosts(batches),
+		assignment: make([]int, len(lines)),
+	}
+	for i := range lines {
+		optimiser.order[i] = i
+		optimiser.assignment[i] = -1
+	}
+	// Trying the smallest lines first fulfils the most lines soonest and keeps the bound on the rest tight
+	slices.SortStableFunc(optimiser.order, func(a, b int) int {
+		return lines[a].Quantity - lines[b].Quantity
+	})
+	for j, batch := range batches {
+		optimiser.room[j] = max(batch.AvailableQuantity(), 0)
+	}
+
+	optimiser.best = optimiser.greedy()
+	optimiser.search(0, 0, 0)
+
+	refs := make([]Reference, len(lines))
+	for i, j := range optimiser.best.assignment {
+		if j >= 0 {
+			refs[i] = batches[j].Reference
+		}
+	}
+	return refs
+}
+
+type optimiser struct {
+	lines   []OrderLine
+	batches []Batch
+	// order holds the indices of the lines from the smallest to the largest
+	order      []int
+	room       []int
+	costs      []int
+	assignment []int
+	best       assignment
+	steps      int
+}
+
+// assignment is the batch index of each line, -1 when the line is not fulfilled.
+// Its cost is the sum of the ETA costs of the batches its lines are assigned to.
+type assignment struct {
+	assignment []int
+	fulfilled  int
+	cost       int
+}
+
+func (a assignment) betterThan(other assignment) bool {
+	return a.fulfilled > other.fulfilled || a.fulfilled == other.fulfilled && a.cost < other.cost
+}
+
+// greedy assigns each line, smallest first, to the earliest batch with room for it
+func (o *optimiser) greedy() assignment {
+	room := slices.Clone(o.room)
+	result := assignment{assignment: slices.Clone(o.assignment)}
+	for _, i := range o.order {
+		for j := range o.batches {
+			if o.fits(i, j, room) {
+				room[j] -= o.lines[i].Quantity
+				result.assignment[i] = j
+				result.fulfilled++
+				result.cost += o.costs[j]
+				break
+			}
+		}
+	}
+	return result
+}
+
+// search tries every batch for the next line and leaving it unfulfilled, pruning the branches that cannot beat the
+// best assignment found
+func (o *optimiser) search(next int, fulfilled int, cost int) {
+	o.steps++
+	if next == len(o.order) {
+		if current := (assignment{fulfilled: fulfilled, cost: cost}); current.betterThan(o.best) {
+			current.assignment = slices.Clone(o.assignment)
+			o.best = current
+		}
+		return
+	}
+	if o.steps > maxOptimiserSteps || !(assignment{fulfilled: fulfilled + o.fittable(next), cost: cost}).betterThan(o.best) {
+		return
+	}
+
+	i := o.order[next]
+	tried := make(map[int]bool)
+	for j := range o.batches {
+		// Batches left with the same room are interchangeable for the lines still to come, the earliest costs the least
+		if !o.fits(i, j, o.room) || tried[o.room[j]] {
+			continue
+		}
+		tried[o.room[j]] = true
+		o.room[j] -= o.lines[i].Quantity
+		o.assignment[i] = j
+		o.search(next+1, fulfilled+1, cost+o.costs[j])
+		o.assignment[i] = -1
+		o.room[j] += o.lines[i].Quantity
+	}
+	o.search(next+1, fulfilled, cost)
+}
+
+// fittable is the most lines from next on that could still be fulfilled, ignoring how the room is split across batches
+func (o *optimiser) fittable(next int) int {
+	var room int
+	for _, batchRoom := range o.room {
+		room += batchRoom
+	}
+	var fittable int
+	for _, i := range o.order[next:] {
+		if o.lines[i].Quantity > room {
+			break
+		}
+		room -= o.lines[i].Quantity
+		fittable++
+	}
+	return fittable
+}
+
+func (o *optimiser) fits(i int, j int, room []int) bool {
+	line, batch := o.lines[i], o.batches[j]
+	return line.Sku == batch.Sku && batch.Status.IsAvailable() && line.Quantity <= room[j]
+}
+
+// PlannedLine is where an order line is allocated now and where a rebalance would allocate it,
+// an empty batch reference is a backorder
+type PlannedLine struct {
+	OrderLine
+	FromBatchRef Reference
+	ToBatchRef   Reference
+}
+
+// RebalancePlan is the assignment of the order lines of a product that fulfils the most of them
+type RebalancePlan struct {
+	Sku   Sku
+	Lines []PlannedLine
+}
+
+// Fulfilled returns the number of lines the plan allocates
+func (r RebalancePlan) Fulfilled() int {
+	var fulfilled int
+	for _, line := range r.Lines {
+		if line.ToBatchRef != "" {
+			fulfilled++
+		}
+	}
+	return fulfilled
+}
+
+// Moves returns the lines the plan allocates to another batch than the one they are in now
+func (r RebalancePlan) Moves() []PlannedLine {
+	var moves []PlannedLine
+	for _, line := range r.Lines {
+		if line.FromBatchRef != line.ToBatchRef {
+			moves = append(moves, line)
+		}
+	}
+	return moves
+}
+
+// PlanRebalance works out how the lines allocated to the batches of the product and its backorders could be
+// reallocated with Optimise. Lines split across batches, including those with a split backorder, and lines in batches
+// that can no longer be allocated to are left where they are. The lines stay where they are unless the optimised plan
+// fulfils more lines, or as many waiting less for their batches.
+func (p *Product) PlanRebalance() RebalancePlan {
+	plan := RebalancePlan{Sku: p.Sku}
+	clone := p.Clone()
+
+	parts := make(map[Reference]int)
+	for _, batch := range clone.allocatableBatches() {
+		for _, orderLine := range batch.Allocations.ToSlice() {
+			parts[orderLine.OrderID]++
+		}
+	}
+	for _, orderLine := range p.Backorders {
+		if orderLine.Split {
+			parts[orderLine.OrderID]++
+		}
+	}
+	for _, batch := range clone.allocatableBatches() {
+		for _, orderLine := range batch.Allocations.ToSlice() {
+			if parts[orderLine.OrderID] == 1 {
+				plan.Lines = append(plan.Lines, PlannedLine{OrderLine: orderLine, FromBatchRef: batch.Reference})
+			}
+		}
+	}
+	for _, orderLine := range p.Backorders {
+		if !orderLine.Split {
+			plan.Lines = append(plan.Lines, PlannedLine{OrderLine: orderLine})
+		}
+	}
+	slices.SortFunc(plan.Lines, comparePlannedLines)
+
+	lines := make([]OrderLine, len(plan.Lines))
+	for i, line := range plan.Lines {
+		lines[i] = line.OrderLine
+		if line.FromBatchRef != "" {
+			batch, _ := clone.Batch(line.FromBatchRef)
+			batch.Deallocate(line.OrderLine)
+		}
+	}
+	batches := clone.allocatableBatches()
+	refs := Optimise(lines, batches)
+
+	current, optimised := plan, RebalancePlan{Sku: p.Sku, Lines: slices.Clone(plan.Lines)}
+	for i := range optimised.Lines {
+		optimised.Lines[i].ToBatchRef = refs[i]
+	}
+	for i := range current.Lines {
+		current.Lines[i].ToBatchRef = current.Lines[i].FromBatchRef
+	}
+	if optimised.score(batches).betterThan(current.score(batches)) {
+		return optimised
+	}
+	return current
+}
+
+// Rebalance reallocates the order lines of the product as planned by PlanRebalance. The lines that move are
+// deallocated from their batches first, then allocated to their planned batch or backordered.
+// When a line cannot be moved as planned the product is left as it was.
+func (p *Product) Rebalance() (RebalancePlan, error) {
+	plan := p.PlanRebalance()
+	moves := plan.Moves()
+	if len(moves) == 0 {
+		return plan, nil
+	}
+
+	rebalanced := p.Clone()
+	for _, move := range moves {
+		if move.FromBatchRef == "" {
+			continue
+		}
+		if err := rebalanced.deallocate(move.FromBatchRef, move.OrderLine); err != nil {
+			return RebalancePlan{}, fmt.Errorf("could not rebalance order %s: %w", move.OrderID, err)
+		}
+	}
+	for _, move := range moves {
+		if move.ToBatchRef == "" {
+			rebalanced.addBackorder(move.OrderLine)
+			continue
+		}
+		if err := rebalanced.allocateTo(move.ToBatchRef, move.OrderLine); err != nil {
+			return RebalancePlan{}, fmt.Errorf("could not rebalance order %s: %w", move.OrderID, err)
+		}
+		if move.FromBatchRef == "" {
+			rebalanced.removeBackorder(move.OrderLine)
+			rebalanced.Events = append(rebalanced.Events, BackorderFilled{OrderID: move.OrderID, Sku: move.Sku, Quantity: move.Quantity, BatchRef: move.ToBatchRef})
+		}
+	}
+
+	rebalanced.VersionNumber++
+	rebalanced.Events = append(rebalanced.Events, StockRebalanced{Sku: p.Sku, Fulfilled: plan.Fulfilled(), Moved: len(moves)})
+	*p = rebalanced
+	return plan, nil
+}
+
+// score returns the plan as an assignment to the batches, in the order Optimise ranks them
+func (r RebalancePlan) score(batches []Batch) assignment {
+	batches = slices.Clone(batches)
+	slices.SortStableFunc(batches, compareETA)
+
+	costs := etaCosts(batches)
+	var score assignment
+	for _, line := range r.Lines {
+		if j := slices.IndexFunc(batches, func(batch Batch) bool { return batch.Reference == line.ToBatchRef }); j >= 0 {
+			score.fulfilled++
+			score.cost += costs[j]
+		}
+	}
+	return score
+}
+
+// etaCosts is how many seconds each of the batches, sorted by ETA, arrives after the earliest of them
+func etaCosts(batches []Batch) []int {
+	costs := make([]int, len(batches))
+	for j, batch := range batches {
+		costs[j] = int(batch.ETA.Unix() - batches[0].ETA.Unix())
+	}
+	return costs
+}
+
+func comparePlannedLines(aLine, bLine PlannedLine) int {
+	if aLine.OrderID < bLine.OrderID {
+		return -1
+	}
+	if aLine.OrderID > bLine.OrderID {
+		return 1
+	}
+	return 0
+}
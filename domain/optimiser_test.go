@@ -0,0 +1,187 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimise(t *testing.T) {
+	early := NewBatch("early-batch", "RETRO-CLOCK", 10, time.Time{})
+	late := NewBatch("late-batch", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0))
+
+	t.Run("fulfils every line where allocating them in turn would not", func(t *testing.T) {
+		lines := []OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 7},
+			{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 6},
+		}
+
+		refs := Optimise(lines, []Batch{late, early})
+		assert.NotContains(t, refs, Reference(""))
+
+		allocated := map[Reference]int{}
+		for i, ref := range refs {
+			allocated[ref] += lines[i].Quantity
+		}
+		assert.LessOrEqual(t, allocated["early-batch"], 10)
+		assert.LessOrEqual(t, allocated["late-batch"], 10)
+	})
+
+	t.Run("prefers the earliest batch", func(t *testing.T) {
+		refs := Optimise([]OrderLine{{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5}}, []Batch{late, early})
+		assert.Equal(t, []Reference{"early-batch"}, refs)
+	})
+
+	t.Run("prefers the lines waiting the least for their batches", func(t *testing.T) {
+		today := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		batches := []Batch{
+			NewBatch("early-batch", "RETRO-CLOCK", 11, today),
+			NewBatch("next-day-batch", "RETRO-CLOCK", 10, today.AddDate(0, 0, 1)),
+			NewBatch("next-month-batch", "RETRO-CLOCK", 11, today.AddDate(0, 1, 0)),
+		}
+
+		refs := Optimise([]OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5},
+			{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5},
+			{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 11},
+		}, batches)
+		assert.Equal(t, []Reference{"next-day-batch", "next-day-batch", "early-batch"}, refs)
+	})
+
+	t.Run("fulfils the most lines rather than the largest", func(t *testing.T) {
+		refs := Optimise([]OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10},
+			{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 4},
+			{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 6},
+		}, []Batch{early})
+		assert.Equal(t, []Reference{"", "early-batch", "early-batch"}, refs)
+	})
+
+	t.Run("leaves out batches of other skus and unavailable batches", func(t *testing.T) {
+		lamps := NewBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{})
+		quarantined := NewBatch("quarantined-batch", "RETRO-CLOCK", 10, time.Time{})
+		quarantined.Status = Quarantined
+
+		refs := Optimise([]OrderLine{{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5}}, []Batch{lamps, quarantined})
+		assert.Equal(t, []Reference{""}, refs)
+	})
+}
+
+func TestProduct_PlanRebalance(t *testing.T) {
+	t.Run("plans to move lines so that the backorder is fulfilled", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{}),
+			NewBatch("batch-002", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		})
+		for _, orderLine := range []OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 7},
+		} {
+			_, err := product.Allocate(orderLine)
+			assert.Nil(t, err)
+		}
+		backorder := OrderLine{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 6}
+		_, err := product.Allocate(backorder)
+		assert.ErrorAs(t, err, &OutOfStockError{})
+		assert.Nil(t, product.Backorder(backorder))
+
+		plan := product.PlanRebalance()
+		assert.Equal(t, 4, plan.Fulfilled())
+		assert.Equal(t, []PlannedLine{
+			{OrderLine: OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3}, FromBatchRef: "batch-001", ToBatchRef: "batch-002"},
+			{OrderLine: OrderLine{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 6}, ToBatchRef: "batch-001"},
+		}, plan.Moves())
+
+		batch, _ := product.Batch("batch-001")
+		assert.Equal(t, 4, batch.AvailableQuantity())
+		assert.Len(t, product.Backorders, 1)
+	})
+
+	t.Run("leaves the parts and the split backorder of a split line where they are", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{}),
+			NewBatch("batch-002", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		})
+		for _, orderLine := range []OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 7},
+		} {
+			_, err := product.Allocate(orderLine)
+			assert.Nil(t, err)
+		}
+		_, err := product.BackorderSplit(OrderLine{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Nil(t, err)
+
+		plan := product.PlanRebalance()
+		for _, line := range plan.Lines {
+			assert.NotEqual(t, Reference("order-004"), line.OrderID)
+		}
+		assert.Equal(t, 3, plan.Fulfilled())
+	})
+
+	t.Run("keeps the lines where they are when they cannot be improved on", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{})})
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Nil(t, err)
+
+		plan := product.PlanRebalance()
+		assert.Equal(t, 1, plan.Fulfilled())
+		assert.Empty(t, plan.Moves())
+	})
+}
+
+func TestProduct_Rebalance(t *testing.T) {
+	t.Run("reallocates the planned lines and fills the backorder", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{}),
+			NewBatch("batch-002", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		})
+		for _, orderLine := range []OrderLine{
+			{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3},
+			{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 7},
+		} {
+			_, err := product.Allocate(orderLine)
+			assert.Nil(t, err)
+		}
+		backorder := OrderLine{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 6}
+		_, err := product.Allocate(backorder)
+		assert.ErrorAs(t, err, &OutOfStockError{})
+		assert.Nil(t, product.Backorder(backorder))
+		product.Events = nil
+		version := product.VersionNumber
+
+		plan, err := product.Rebalance()
+		assert.Nil(t, err)
+		assert.Equal(t, 4, plan.Fulfilled())
+
+		early, _ := product.Batch("batch-001")
+		late, _ := product.Batch("batch-002")
+		assert.Equal(t, 1, early.AvailableQuantity())
+		assert.Equal(t, 0, late.AvailableQuantity())
+		assert.True(t, late.IsAllocated(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3}))
+		assert.Empty(t, product.Backorders)
+		assert.Equal(t, version+1, product.VersionNumber)
+		assert.Equal(t, []Event{
+			Deallocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3, BatchRef: "batch-001"},
+			Allocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3, BatchRef: "batch-002"},
+			Allocated{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 6, BatchRef: "batch-001"},
+			BackorderFilled{OrderID: "order-004", Sku: "RETRO-CLOCK", Quantity: 6, BatchRef: "batch-001"},
+			StockRebalanced{Sku: "RETRO-CLOCK", Fulfilled: 4, Moved: 2},
+		}, product.Events)
+	})
+
+	t.Run("changes nothing when no line moves", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{})})
+
+		_, err := product.Rebalance()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, product.VersionNumber)
+		assert.Empty(t, product.Events)
+	})
+}
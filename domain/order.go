@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"fmt"
+)
+
+// Order is a customer order made of one line per sku
+type Order struct {
+	OrderID Reference
+	Lines   []OrderLine
+}
+
+func NewOrder(orderID Reference, lines ...OrderLine) Order {
+	order := Order{
+		OrderID: orderID,
+	}
+	for _, line := range lines {
+		line.OrderID = orderID
+		order.Lines = append(order.Lines, line)
+	}
+	return order
+}
+
+// Validate checks that the order has lines, that they all belong to it and that no sku is ordered twice
+func (o Order) Validate() error {
+	if len(o.Lines) == 0 {
+		return fmt.Errorf("order %s has no lines", o.OrderID)
+	}
+	skus := make(map[Sku]bool, len(o.Lines))
+	for _, line := range o.Lines {
+		if line.OrderID != o.OrderID {
+			return fmt.Errorf("line of %s belongs to order %s, not %s", line.Sku, line.OrderID, o.OrderID)
+		}
+		if line.Quantity <= 0 {
+			return fmt.Errorf("line of %s in order %s must have a positive quantity", line.Sku, o.OrderID)
+		}
+		if !line.Priority.IsValid() {
+			return fmt.Errorf("line of %s in order %s has an unknown priority %q", line.Sku, o.OrderID, line.Priority)
+		}
+		if skus[line.Sku] {
+			return fmt.Errorf("order %s has more than one line of %s", o.OrderID, line.Sku)
+		}
+		skus[line.Sku] = true
+	}
+	return nil
+}
+
+// AllocationMode decides what happens to the rest of an order when one of its lines cannot be allocated
+type AllocationMode string
+
+const (
+	// AllOrNothing allocates no line of the order unless every line can be allocated
+	AllOrNothing AllocationMode = "all-or-nothing"
+	// BestEffort allocates every line that can be allocated
+	BestEffort AllocationMode = "best-effort"
+)
+
+// LineAllocation is the outcome of allocating a single line of an order
+type LineAllocation struct {
+	Sku      Sku
+	Quantity int
+	BatchRef Reference
+	Err      error
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOrder(t *testing.T) {
+	order := NewOrder("order-001",
+		OrderLine{Sku: "SMALL-TABLE", Quantity: 2},
+		OrderLine{Sku: "BIG-LAMP", Quantity: 1},
+	)
+
+	assert.Len(t, order.Lines, 2)
+	for _, line := range order.Lines {
+		assert.Equal(t, Reference("order-001"), line.OrderID)
+	}
+}
+
+func TestOrder_Validate(t *testing.T) {
+	t.Run("accepts an order with one line per sku", func(t *testing.T) {
+		order := NewOrder("order-001", OrderLine{Sku: "SMALL-TABLE", Quantity: 2}, OrderLine{Sku: "BIG-LAMP", Quantity: 1})
+		assert.Nil(t, order.Validate())
+	})
+
+	t.Run("rejects an order without lines", func(t *testing.T) {
+		assert.Error(t, NewOrder("order-001").Validate())
+	})
+
+	t.Run("rejects an order with two lines of the same sku", func(t *testing.T) {
+		order := NewOrder("order-001", OrderLine{Sku: "SMALL-TABLE", Quantity: 2}, OrderLine{Sku: "SMALL-TABLE", Quantity: 1})
+		assert.Error(t, order.Validate())
+	})
+
+	t.Run("rejects a line of another order", func(t *testing.T) {
+		order := Order{OrderID: "order-001", Lines: []OrderLine{{OrderID: "order-002", Sku: "SMALL-TABLE", Quantity: 2}}}
+		assert.Error(t, order.Validate())
+	})
+
+	t.Run("rejects a line without a positive quantity", func(t *testing.T) {
+		order := NewOrder("order-001", OrderLine{Sku: "SMALL-TABLE", Quantity: 0})
+		assert.Error(t, order.Validate())
+	})
+}
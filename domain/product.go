@@ -0,0 +1,959 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// Product is the aggregate that owns every batch of a single sku
+type Product struct {
+	Sku           Sku
+	Batches       []Batch
+	VersionNumber int
+	Events        []Event
+	Strategy      AllocationStrategy
+	Backorders    []OrderLine
+	Clock         func() time.Time
+	Units         UnitsOfMeasure
+	LeadTime      LeadTime
+}
+
+func NewProduct(sku Sku, batches []Batch) Product {
+	return Product{
+		Sku:     sku,
+		Batches: batches,
+	}
+}
+
+// Clone returns a copy of the product that can be changed without affecting the original
+func (p *Product) Clone() Product {
+	clone := *p
+	clone.Batches = make([]Batch, len(p.Batches))
+	for i, batch := range p.Batches {
+		clone.Batches[i] = batch.Clone()
+	}
+	clone.Events = slices.Clone(p.Events)
+	clone.Backorders = slices.Clone(p.Backorders)
+	return clone
+}
+
+// AddBatch adds a new batch of stock to the product
+func (p *Product) AddBatch(batch Batch) error {
+	if batch.Sku != p.Sku {
+		return fmt.Errorf("batch of %s cannot be added to product %s", batch.Sku, p.Sku)
+	}
+	if _, ok := p.Batch(batch.Reference); ok {
+		return fmt.Errorf("batch %s already exists for product %s", batch.Reference, p.Sku)
+	}
+	quantity, err := p.units().ToBase(batch.Quantity, batch.Unit)
+	if err != nil {
+		return err
+	}
+	batch.Quantity, batch.Unit = quantity, p.Units.Base
+	p.Batches = append(p.Batches, batch)
+	p.VersionNumber++
+	p.Events = append(p.Events, BatchCreated{Reference: batch.Reference, Sku: batch.Sku, Quantity: batch.Quantity, ETA: batch.ETA})
+	p.fillBackorders()
+	return nil
+}
+
+// Batch returns the batch of the product with the given reference
+func (p *Product) Batch(reference Reference) (*Batch, bool) {
+	index := slices.IndexFunc[[]Batch](p.Batches, func(batch Batch) bool {
+		return batch.Reference == reference
+	})
+	if index == -1 {
+		return nil, false
+	}
+	return &p.Batches[index], true
+}
+
+// Allocate allocates an order line to the most suitable batch of the product
+func (p *Product) Allocate(orderLine OrderLine) (Reference, error) {
+	if orderLine.Sku != p.Sku {
+		return "", fmt.Errorf("order of %s cannot be allocated to product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return "", err
+	}
+	batchRef, err := AllocateWithStrategy(orderLine, p.allocatableBatches(), p.strategy())
+	if err != nil {
+		p.Events = append(p.Events, OutOfStock{Sku: p.Sku})
+		return "", err
+	}
+	p.VersionNumber++
+	p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batchRef})
+	return batchRef, nil
+}
+
+// AllocateSplit allocates an order line to a single batch when one can take it whole, otherwise the line is split
+// across as many batches as it needs, taken in the order preferred by the strategy
+func (p *Product) AllocateSplit(orderLine OrderLine) ([]BatchAllocation, error) {
+	if orderLine.Sku != p.Sku {
+		return nil, fmt.Errorf("order of %s cannot be allocated to product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return nil, err
+	}
+
+	batches := p.allocatableBatches()
+	if batchRef, err := AllocateWithStrategy(orderLine, batches, p.strategy()); err == nil {
+		p.VersionNumber++
+		p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batchRef})
+		return []BatchAllocation{{BatchRef: batchRef, Quantity: orderLine.Quantity}}, nil
+	}
+
+	plan, parts := p.planParts(orderLine, batches)
+	if remaining := orderLine.Quantity - allocatedQuantity(parts); remaining > 0 {
+		p.Events = append(p.Events, OutOfStock{Sku: p.Sku})
+		return nil, OutOfStockError{p.Sku}
+	}
+
+	p.allocateParts(orderLine, batches, plan, parts)
+	p.VersionNumber++
+	return parts, nil
+}
+
+// BackorderSplit allocates as much of the order line as the batches have room for now, split across them like
+// AllocateSplit, and backorders the rest. The rest stays Split, so that it is allocated across batches as stock arrives.
+func (p *Product) BackorderSplit(orderLine OrderLine) ([]BatchAllocation, error) {
+	if orderLine.Sku != p.Sku {
+		return nil, fmt.Errorf("order of %s cannot be backordered for product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(p.Backorders, func(backorder OrderLine) bool {
+		return backorder.OrderID == orderLine.OrderID
+	}) {
+		return nil, fmt.Errorf("order %s is already backordered for product %s", orderLine.OrderID, p.Sku)
+	}
+
+	batches := p.allocatableBatches()
+	plan, parts := p.planParts(orderLine, batches)
+	p.allocateParts(orderLine, batches, plan, parts)
+	if remaining := orderLine.Quantity - allocatedQuantity(parts); remaining > 0 {
+		shortfall := orderLine
+		shortfall.Quantity, shortfall.Split = remaining, true
+		p.addBackorder(shortfall)
+	}
+	p.VersionNumber++
+	return parts, nil
+}
+
+// planParts splits as much of the order line as the batches have room for across them, in the order preferred by
+// the strategy. It returns the index of the batch of each part.
+func (p *Product) planParts(orderLine OrderLine, batches []Batch) ([]int, []BatchAllocation) {
+	p.strategy().Rank(orderLine, batches)
+
+	var plan []int
+	var parts []BatchAllocation
+	remaining := orderLine.Quantity
+	for i := range batches {
+		if remaining == 0 {
+			break
+		}
+		part := orderLine
+		part.Quantity = min(batches[i].AvailableQuantity(), remaining)
+		if part.Quantity <= 0 || batches[i].Sku != part.Sku || !batches[i].Status.IsAvailable() {
+			continue
+		}
+		plan = append(plan, i)
+		parts = append(parts, BatchAllocation{BatchRef: batches[i].Reference, Quantity: part.Quantity})
+		remaining -= part.Quantity
+	}
+	return plan, parts
+}
+
+// allocateParts allocates the planned parts of the order line to their batches
+func (p *Product) allocateParts(orderLine OrderLine, batches []Batch, plan []int, parts []BatchAllocation) {
+	for i, batchIndex := range plan {
+		part := orderLine
+		part.Quantity, part.Split = parts[i].Quantity, false
+		batches[batchIndex].addPart(part)
+		p.Events = append(p.Events, Allocated{OrderID: part.OrderID, Sku: part.Sku, Quantity: part.Quantity, BatchRef: parts[i].BatchRef})
+	}
+}
+
+func allocatedQuantity(parts []BatchAllocation) int {
+	var quantity int
+	for _, part := range parts {
+		quantity += part.Quantity
+	}
+	return quantity
+}
+
+// AllocatePreempting allocates the order line to warehouse stock, displacing lines of a lower priority when no
+// warehouse batch has room for it. Displaced lines are moved to shipments, or backordered when no shipment can take them.
+// When no warehouse batch can be freed either, the line is allocated like any other.
+func (p *Product) AllocatePreempting(orderLine OrderLine) (PreemptiveAllocation, error) {
+	if orderLine.Sku != p.Sku {
+		return PreemptiveAllocation{}, fmt.Errorf("order of %s cannot be allocated to product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return PreemptiveAllocation{}, err
+	}
+	batches := p.allocatableBatches()
+	p.strategy().Rank(orderLine, batches)
+
+	for i := range batches {
+		batch := &batches[i]
+		if batch.InWarehouse() && batch.Allocate(orderLine) == nil {
+			p.VersionNumber++
+			p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batch.Reference})
+			return PreemptiveAllocation{BatchRef: batch.Reference}, nil
+		}
+	}
+
+	for i := range batches {
+		batch := &batches[i]
+		if !batch.InWarehouse() {
+			continue
+		}
+		displaced, ok := batch.preemptableFor(orderLine)
+		if !ok {
+			continue
+		}
+
+		for _, line := range displaced {
+			batch.Deallocate(line)
+			p.Events = append(p.Events, Preempted{OrderID: line.OrderID, Sku: line.Sku, Quantity: line.Quantity, BatchRef: batch.Reference, PreemptedBy: orderLine.OrderID})
+		}
+		batch.Allocate(orderLine)
+		p.VersionNumber++
+		p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batch.Reference})
+
+		return PreemptiveAllocation{BatchRef: batch.Reference, Displacements: p.moveToShipments(batch.Reference, displaced)}, nil
+	}
+
+	batchRef, err := p.Allocate(orderLine)
+	return PreemptiveAllocation{BatchRef: batchRef}, err
+}
+
+// moveToShipments allocates the order lines displaced from a batch to the shipments of the product, or backorders them
+func (p *Product) moveToShipments(from Reference, orderLines []OrderLine) []Displacement {
+	shipments := slices.DeleteFunc(p.allocatableBatches(), func(b Batch) bool {
+		return b.InWarehouse()
+	})
+	return p.moveOrderLines(from, orderLines, shipments)
+}
+
+// moveOrderLines allocates the order lines displaced from a batch to the given batches, or backorders them
+func (p *Product) moveOrderLines(from Reference, orderLines []OrderLine, batches []Batch) []Displacement {
+	var displacements []Displacement
+	for _, orderLine := range orderLines {
+		displacement := Displacement{OrderLine: orderLine, FromBatchRef: from}
+		batchRef, err := AllocateWithStrategy(orderLine, batches, p.strategy())
+		if err != nil {
+			p.Events = append(p.Events, OutOfStock{Sku: p.Sku})
+			p.addBackorder(orderLine)
+		} else {
+			displacement.ToBatchRef = batchRef
+			p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batchRef})
+		}
+		displacements = append(displacements, displacement)
+	}
+	return displacements
+}
+
+// Deallocate removes an order line from the batch of the product with the given reference,
+// the stock freed is used to fill the backorders
+func (p *Product) Deallocate(reference Reference, orderLine OrderLine) error {
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return err
+	}
+	if err := p.deallocate(reference, orderLine); err != nil {
+		return err
+	}
+	p.VersionNumber++
+	p.fillBackorders()
+	return nil
+}
+
+// deallocate removes the order's line of the sku from the batch of the product with the given reference
+func (p *Product) deallocate(reference Reference, orderLine OrderLine) error {
+	batch, ok := p.Batch(reference)
+	if !ok {
+		return fmt.Errorf("batch %s does not belong to product %s", reference, p.Sku)
+	}
+	allocated, ok := batch.AllocationOf(orderLine.OrderID, orderLine.Sku)
+	if !ok {
+		return fmt.Errorf("order line is not allocated to batch %s", reference)
+	}
+	batch.Deallocate(allocated)
+	p.Events = append(p.Events, Deallocated{OrderID: allocated.OrderID, Sku: allocated.Sku, Quantity: allocated.Quantity, BatchRef: reference})
+	return nil
+}
+
+// allocateTo allocates the order line to the batch of the product with the given reference,
+// as long as order lines can be allocated to the batch now
+func (p *Product) allocateTo(reference Reference, orderLine OrderLine) error {
+	batches := p.allocatableBatches()
+	i := slices.IndexFunc(batches, func(batch Batch) bool {
+		return batch.Reference == reference
+	})
+	if i < 0 {
+		return fmt.Errorf("batch %s of product %s cannot be allocated to", reference, p.Sku)
+	}
+	if err := batches[i].Allocate(orderLine); err != nil {
+		return err
+	}
+	p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: reference})
+	return nil
+}
+
+// DeallocateOrderLine removes every part of the order's line from the batches of the product at once,
+// the stock freed is used to fill the backorders
+func (p *Product) DeallocateOrderLine(orderID Reference) ([]BatchAllocation, error) {
+	var deallocated []BatchAllocation
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		for _, orderLine := range batch.Allocations.ToSlice() {
+			if orderLine.OrderID != orderID {
+				continue
+			}
+			batch.Deallocate(orderLine)
+			deallocated = append(deallocated, BatchAllocation{BatchRef: batch.Reference, Quantity: orderLine.Quantity})
+			p.Events = append(p.Events, Deallocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batch.Reference})
+		}
+	}
+
+	if len(deallocated) == 0 {
+		return nil, fmt.Errorf("order %s has no allocations of %s", orderID, p.Sku)
+	}
+	p.VersionNumber++
+	p.fillBackorders()
+	return deallocated, nil
+}
+
+// AmendOrderLine changes the quantity of the order's allocated line to that of the given line, keeping its priority.
+// The line stays in its batch when the batch has room for the new quantity, otherwise it is reallocated like Allocate.
+// A line split across batches is amended like amendSplitLine instead.
+// When no batch can take the new quantity the line is left as it was and an OutOfStockError is returned.
+// The stock freed by the amendment is used to fill the backorders.
+func (p *Product) AmendOrderLine(orderLine OrderLine) (Reference, error) {
+	if orderLine.Sku != p.Sku {
+		return "", fmt.Errorf("order of %s cannot be amended on product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return "", err
+	}
+	if orderLine.Quantity <= 0 {
+		return "", fmt.Errorf("order %s of %s must keep a positive quantity", orderLine.OrderID, p.Sku)
+	}
+
+	var parts []linePart
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		if batch.Allocations == nil {
+			continue
+		}
+		if allocated, ok := batch.AllocationOf(orderLine.OrderID, orderLine.Sku); ok {
+			parts = append(parts, linePart{batch: batch, orderLine: allocated})
+		}
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("order %s has no allocations of %s", orderLine.OrderID, p.Sku)
+	}
+
+	orderLine.Priority = parts[0].orderLine.Priority
+	if len(parts) > 1 {
+		batchRef, err := p.amendSplitLine(orderLine, parts)
+		if err != nil {
+			return "", fmt.Errorf("could not amend order %s to %d of %s: %w", orderLine.OrderID, orderLine.Quantity, p.Sku, err)
+		}
+		p.amended(orderLine, batchRef, false)
+		return batchRef, nil
+	}
+
+	part := parts[0]
+	part.batch.Deallocate(part.orderLine)
+
+	batches := p.allocatableBatches()
+	var batchRef Reference
+	if i := slices.IndexFunc(batches, func(batch Batch) bool {
+		return batch.Reference == part.batch.Reference
+	}); i >= 0 && batches[i].Allocate(orderLine) == nil {
+		batchRef = batches[i].Reference
+	}
+	reallocated := batchRef == ""
+	if reallocated {
+		if batchRef, err = AllocateWithStrategy(orderLine, batches, p.strategy()); err != nil {
+			part.batch.Allocations.Add(part.orderLine)
+			return "", fmt.Errorf("could not amend order %s to %d of %s: %w", orderLine.OrderID, orderLine.Quantity, p.Sku, err)
+		}
+	}
+
+	p.amended(orderLine, batchRef, reallocated)
+	return batchRef, nil
+}
+
+// linePart is the part of an order line allocated to one of the batches of the product
+type linePart struct {
+	batch     *Batch
+	orderLine OrderLine
+}
+
+// amendSplitLine changes the quantity of an order line split across several batches without moving its parts.
+// A decrease shrinks the parts in the batches arriving latest first, an increase is split across the batches with
+// room like AllocateSplit. It returns the batch of the earliest part.
+func (p *Product) amendSplitLine(orderLine OrderLine, parts []linePart) (Reference, error) {
+	slices.SortStableFunc(parts, func(aPart, bPart linePart) int {
+		return compareETA(*aPart.batch, *bPart.batch)
+	})
+	var allocated int
+	for _, part := range parts {
+		allocated += part.orderLine.Quantity
+	}
+
+	if extra := orderLine.Quantity - allocated; extra > 0 {
+		topUp := orderLine
+		topUp.Quantity = extra
+		batches := p.allocatableBatches()
+		plan, topUps := p.planParts(topUp, batches)
+		if allocatedQuantity(topUps) < extra {
+			return "", OutOfStockError{p.Sku}
+		}
+		for i, batchIndex := range plan {
+			part := topUp
+			part.Quantity = topUps[i].Quantity
+			batches[batchIndex].addPart(part)
+		}
+	}
+
+	excess := allocated - orderLine.Quantity
+	for i := len(parts) - 1; i >= 0 && excess > 0; i-- {
+		part := parts[i]
+		part.batch.Deallocate(part.orderLine)
+		shrunk := min(part.orderLine.Quantity, excess)
+		if part.orderLine.Quantity > shrunk {
+			part.orderLine.Quantity -= shrunk
+			part.batch.Allocations.Add(part.orderLine)
+		}
+		excess -= shrunk
+	}
+	return parts[0].batch.Reference, nil
+}
+
+// amended records the amendment of the order line and fills the backorders with the stock it freed
+func (p *Product) amended(orderLine OrderLine, batchRef Reference, reallocated bool) {
+	p.VersionNumber++
+	p.Events = append(p.Events, OrderLineAmended{
+		OrderID:     orderLine.OrderID,
+		Sku:         p.Sku,
+		Quantity:    orderLine.Quantity,
+		BatchRef:    batchRef,
+		Reallocated: reallocated,
+	})
+	p.fillBackorders()
+}
+
+// CancelOrder takes every line of the order off the product: its allocations are deallocated, its holds released and
+// its backorders dropped. The stock freed is used to fill the backorders of other orders.
+// It returns the parts of the lines that were deallocated and false if the product had nothing of the order.
+func (p *Product) CancelOrder(orderID Reference) ([]BatchAllocation, bool) {
+	var deallocated []BatchAllocation
+	var cancelled bool
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		if batch.Allocations != nil {
+			for _, orderLine := range batch.Allocations.ToSlice() {
+				if orderLine.OrderID != orderID {
+					continue
+				}
+				batch.Deallocate(orderLine)
+				deallocated = append(deallocated, BatchAllocation{BatchRef: batch.Reference, Quantity: orderLine.Quantity})
+				p.Events = append(p.Events, Deallocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batch.Reference})
+				cancelled = true
+			}
+		}
+		if batch.Holds != nil {
+			for _, hold := range batch.Holds.ToSlice() {
+				if hold.OrderID == orderID {
+					p.release(batch, hold)
+					cancelled = true
+				}
+			}
+		}
+	}
+	if backorders := len(p.Backorders); backorders > 0 {
+		p.Backorders = slices.DeleteFunc(p.Backorders, func(orderLine OrderLine) bool {
+			return orderLine.OrderID == orderID
+		})
+		cancelled = cancelled || len(p.Backorders) < backorders
+	}
+
+	if !cancelled {
+		return nil, false
+	}
+	p.VersionNumber++
+	p.Events = append(p.Events, OrderCancelled{OrderID: orderID, Sku: p.Sku})
+	p.fillBackorders()
+	return deallocated, true
+}
+
+// ChangeBatchQuantity sets the quantity of the batch with the given reference.
+// When the batch no longer has room, its holds are released first, then the fewest order lines needed to fit are
+// deallocated from it and allocated to the other batches of the product where possible, or backordered.
+// When the batch has more room, it is used to fill the backorders.
+func (p *Product) ChangeBatchQuantity(reference Reference, quantity int) error {
+	batch, ok := p.Batch(reference)
+	if !ok {
+		return fmt.Errorf("batch %s does not belong to product %s", reference, p.Sku)
+	}
+	if quantity < 0 {
+		return fmt.Errorf("cannot set batch %s to a negative quantity", reference)
+	}
+	batch.Quantity = quantity
+	p.VersionNumber++
+	p.Events = append(p.Events, BatchQuantityChanged{Reference: reference, Sku: p.Sku, Quantity: quantity})
+
+	for batch.AvailableQuantity() < 0 && batch.HeldQuantity() > 0 {
+		p.release(batch, batch.largestHold())
+	}
+
+	var deallocated []OrderLine
+	for batch.AvailableQuantity() < 0 {
+		orderLine := batch.largestAllocation()
+		batch.Deallocate(orderLine)
+		deallocated = append(deallocated, orderLine)
+		p.Events = append(p.Events, Deallocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: reference})
+	}
+
+	otherBatches := slices.DeleteFunc(p.allocatableBatches(), func(b Batch) bool {
+		return b.Reference == reference
+	})
+	p.moveOrderLines(reference, deallocated, otherBatches)
+
+	p.fillBackorders()
+	return nil
+}
+
+// ChangeBatchStatus sets the status of the batch with the given reference.
+// A batch that can no longer be allocated to gives up its holds, and its order lines are moved to the other batches
+// of the product where possible, or backordered. A batch that is made available again is used to fill the backorders.
+func (p *Product) ChangeBatchStatus(reference Reference, status BatchStatus) (StatusChange, error) {
+	batch, ok := p.Batch(reference)
+	if !ok {
+		return StatusChange{}, fmt.Errorf("batch %s does not belong to product %s", reference, p.Sku)
+	}
+	if !status.IsValid() {
+		return StatusChange{}, fmt.Errorf("unknown batch status %q", status)
+	}
+	change := StatusChange{BatchRef: reference, Status: status}
+	if batch.Status == status || batch.Status.IsAvailable() && status.IsAvailable() {
+		return change, nil
+	}
+
+	batch.Status = status
+	p.VersionNumber++
+	p.Events = append(p.Events, BatchStatusChanged{Reference: reference, Sku: p.Sku, Status: status})
+
+	if status.IsAvailable() {
+		p.fillBackorders()
+		return change, nil
+	}
+
+	for _, hold := range batch.Holds.ToSlice() {
+		p.release(batch, hold)
+	}
+	var deallocated []OrderLine
+	for _, orderLine := range batch.Allocations.ToSlice() {
+		batch.Deallocate(orderLine)
+		deallocated = append(deallocated, orderLine)
+		p.Events = append(p.Events, Deallocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: reference})
+	}
+	slices.SortFunc(deallocated, func(aLine, bLine OrderLine) int {
+		if aLine.Priority != bLine.Priority {
+			return bLine.Priority.level() - aLine.Priority.level()
+		}
+		return strings.Compare(string(aLine.OrderID), string(bLine.OrderID))
+	})
+
+	change.Displacements = p.moveOrderLines(reference, deallocated, p.allocatableBatches())
+	return change, nil
+}
+
+// TransferStock moves quantity of the batch to a new batch in transit to the destination warehouse, arriving at the eta.
+// The source batch shrinks like it does in ChangeBatchQuantity, so the order lines that no longer fit on it are
+// moved to the other batches, including the transfer, or backordered. Stock that cannot be allocated is not transferred.
+func (p *Product) TransferStock(reference Reference, transferRef Reference, quantity int, destination Warehouse, eta time.Time) error {
+	source, ok := p.Batch(reference)
+	if !ok {
+		return fmt.Errorf("batch %s does not belong to product %s", reference, p.Sku)
+	}
+	if !source.Status.IsAvailable() {
+		return BatchUnavailableError{Reference: reference, Status: source.Status}
+	}
+	if _, ok := p.Batch(transferRef); ok {
+		return fmt.Errorf("batch %s already exists for product %s", transferRef, p.Sku)
+	}
+	if quantity <= 0 || quantity > source.Quantity {
+		return fmt.Errorf("cannot transfer %d of the %d %s in batch %s", quantity, source.Quantity, p.Sku, reference)
+	}
+	if destination == "" || eta.IsZero() {
+		return fmt.Errorf("a transfer needs a destination and an eta")
+	}
+
+	transfer := NewPerishableBatch(transferRef, p.Sku, quantity, eta, source.BestBefore)
+	transfer.Warehouse = destination
+	remaining := source.Quantity - quantity
+
+	p.Batches = append(p.Batches, transfer)
+	p.Events = append(p.Events,
+		BatchCreated{Reference: transferRef, Sku: p.Sku, Quantity: quantity, ETA: eta},
+		StockTransferred{FromBatchRef: reference, BatchRef: transferRef, Sku: p.Sku, Quantity: quantity, Destination: destination, ETA: eta},
+	)
+	return p.ChangeBatchQuantity(reference, remaining)
+}
+
+// Hold reserves stock for the order line on the most suitable batch of the product until expiresAt
+func (p *Product) Hold(orderLine OrderLine, expiresAt time.Time) (Reference, error) {
+	if orderLine.Sku != p.Sku {
+		return "", fmt.Errorf("order of %s cannot be held on product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return "", err
+	}
+	batches := p.allocatableBatches()
+	p.strategy().Rank(orderLine, batches)
+	for i := range batches {
+		batch := &batches[i]
+		if err := batch.Hold(orderLine, expiresAt); err != nil {
+			continue
+		}
+		p.VersionNumber++
+		p.Events = append(p.Events, Held{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batch.Reference, ExpiresAt: expiresAt})
+		return batch.Reference, nil
+	}
+	p.Events = append(p.Events, OutOfStock{Sku: p.Sku})
+	return "", OutOfStockError{p.Sku}
+}
+
+// ConfirmHold turns the hold of the order into an allocation to the same batch, unless the hold has expired by now
+func (p *Product) ConfirmHold(orderID Reference, now time.Time) (Reference, error) {
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		hold, ok := batch.HoldOf(orderID)
+		if !ok {
+			continue
+		}
+		if hold.HasExpired(now) {
+			return "", HoldExpiredError{OrderID: orderID, Sku: p.Sku, ExpiresAt: hold.ExpiresAt}
+		}
+		if batch.IsAllocated(hold.OrderLine) {
+			return "", fmt.Errorf("order %s is already allocated to batch %s", orderID, batch.Reference)
+		}
+		batch.Release(hold)
+		batch.Allocations.Add(hold.OrderLine)
+		p.VersionNumber++
+		p.Events = append(p.Events, Allocated{OrderID: orderID, Sku: p.Sku, Quantity: hold.Quantity, BatchRef: batch.Reference})
+		return batch.Reference, nil
+	}
+	return "", fmt.Errorf("order %s has no hold on product %s", orderID, p.Sku)
+}
+
+// ReleaseExpiredHolds releases every hold that has expired by now and uses the stock they free to fill the backorders
+func (p *Product) ReleaseExpiredHolds(now time.Time) []Hold {
+	var released []Hold
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		if batch.Holds == nil {
+			continue
+		}
+		for _, hold := range batch.Holds.ToSlice() {
+			if hold.HasExpired(now) {
+				p.release(batch, hold)
+				released = append(released, hold)
+			}
+		}
+	}
+
+	if len(released) > 0 {
+		p.VersionNumber++
+		p.fillBackorders()
+	}
+	return released
+}
+
+// ReviewExpiry flags the batches that expire within the warning period and empties the batches that have expired,
+// as their stock can no longer be dispatched. The holds on an expired batch are released and its order lines are
+// allocated to other batches, or backordered. It returns true if the product has changed.
+func (p *Product) ReviewExpiry(warning time.Duration) bool {
+	now := p.now()
+	changed := false
+
+	var displaced []OrderLine
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		if batch.BestBefore.IsZero() {
+			continue
+		}
+		if !batch.FlaggedExpiring && batch.BestBefore.Before(now.Add(warning)) {
+			batch.FlaggedExpiring = true
+			changed = true
+			p.Events = append(p.Events, BatchExpiring{Reference: batch.Reference, Sku: batch.Sku, BestBefore: batch.BestBefore})
+		}
+		if !batch.HasExpired(now) || batch.AllocatedQuantity() == 0 && batch.HeldQuantity() == 0 {
+			continue
+		}
+
+		changed = true
+		p.Events = append(p.Events, BatchExpired{Reference: batch.Reference, Sku: batch.Sku, BestBefore: batch.BestBefore})
+		for _, hold := range batch.Holds.ToSlice() {
+			p.release(batch, hold)
+		}
+		for _, orderLine := range batch.Allocations.ToSlice() {
+			batch.Deallocate(orderLine)
+			displaced = append(displaced, orderLine)
+			p.Events = append(p.Events, Deallocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batch.Reference})
+		}
+	}
+
+	batches := p.allocatableBatches()
+	for _, orderLine := range displaced {
+		batchRef, err := AllocateWithStrategy(orderLine, batches, p.strategy())
+		if err != nil {
+			p.Events = append(p.Events, OutOfStock{Sku: p.Sku})
+			p.addBackorder(orderLine)
+			continue
+		}
+		p.Events = append(p.Events, Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batchRef})
+	}
+
+	if changed {
+		p.VersionNumber++
+	}
+	return changed
+}
+
+func (p *Product) release(batch *Batch, hold Hold) {
+	batch.Release(hold)
+	p.Events = append(p.Events, HoldReleased{OrderID: hold.OrderID, Sku: hold.Sku, Quantity: hold.Quantity, BatchRef: batch.Reference})
+}
+
+// Backorder parks an order line that cannot be allocated yet, it is allocated as soon as a batch has room for it
+func (p *Product) Backorder(orderLine OrderLine) error {
+	if orderLine.Sku != p.Sku {
+		return fmt.Errorf("order of %s cannot be backordered for product %s", orderLine.Sku, p.Sku)
+	}
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(p.Backorders, func(backorder OrderLine) bool {
+		return backorder.OrderID == orderLine.OrderID
+	}) {
+		return fmt.Errorf("order %s is already backordered for product %s", orderLine.OrderID, p.Sku)
+	}
+	p.addBackorder(orderLine)
+	p.VersionNumber++
+	return nil
+}
+
+func (p *Product) addBackorder(orderLine OrderLine) {
+	p.Backorders = append(p.Backorders, orderLine)
+	p.Events = append(p.Events, Backordered{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity})
+}
+
+// removeBackorder takes the order's line of the sku off the backorders
+func (p *Product) removeBackorder(orderLine OrderLine) {
+	p.Backorders = slices.DeleteFunc(p.Backorders, func(backorder OrderLine) bool {
+		return backorder.OrderID == orderLine.OrderID && backorder.Sku == orderLine.Sku
+	})
+}
+
+// fillBackorders allocates every backordered line a batch has room for, the highest priority lines first and
+// lines of the same priority in the order they were backordered.
+// A line that still does not fit stays in the queue without holding up the lines behind it,
+// a Split line is allocated as far as the batches have room and the rest of it stays in the queue.
+func (p *Product) fillBackorders() {
+	queue := slices.Clone(p.Backorders)
+	slices.SortStableFunc(queue, func(aLine, bLine OrderLine) int {
+		return bLine.Priority.level() - aLine.Priority.level()
+	})
+
+	batches := p.allocatableBatches()
+	filled := make(map[Reference]bool)
+	for _, orderLine := range queue {
+		if orderLine.Split {
+			p.fillSplitBackorder(orderLine, batches)
+			continue
+		}
+		batchRef, err := AllocateWithStrategy(orderLine, batches, p.strategy())
+		if err != nil {
+			continue
+		}
+		filled[orderLine.OrderID] = true
+		p.Events = append(p.Events,
+			Allocated{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batchRef},
+			BackorderFilled{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: orderLine.Quantity, BatchRef: batchRef},
+		)
+	}
+	p.Backorders = slices.DeleteFunc(p.Backorders, func(orderLine OrderLine) bool {
+		return filled[orderLine.OrderID]
+	})
+}
+
+// fillSplitBackorder allocates as much of the split backordered line as the batches have room for,
+// the line is taken off the backorders once it is allocated in full
+func (p *Product) fillSplitBackorder(orderLine OrderLine, batches []Batch) {
+	plan, parts := p.planParts(orderLine, batches)
+	if len(parts) == 0 {
+		return
+	}
+	p.allocateParts(orderLine, batches, plan, parts)
+	for _, part := range parts {
+		p.Events = append(p.Events, BackorderFilled{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Quantity: part.Quantity, BatchRef: part.BatchRef})
+	}
+
+	remaining := orderLine.Quantity - allocatedQuantity(parts)
+	i := slices.IndexFunc(p.Backorders, func(backorder OrderLine) bool {
+		return backorder.OrderID == orderLine.OrderID && backorder.Sku == orderLine.Sku
+	})
+	if remaining > 0 {
+		p.Backorders[i].Quantity = remaining
+		return
+	}
+	p.Backorders = slices.Delete(p.Backorders, i, i+1)
+}
+
+// allocatableBatches returns the batches order lines can be allocated to now, leaving out unavailable and expired stock
+// and the batches the strategy does not accept. The holds that have expired by now no longer reserve stock of the
+// batches, they stay on the product until ReleaseExpiredHolds releases them.
+// The batches share their allocations and holds with the batches of the product.
+func (p *Product) allocatableBatches() []Batch {
+	now := p.now()
+	filter, _ := p.strategy().(BatchFilter)
+
+	var batches []Batch
+	for i := range p.Batches {
+		batch := &p.Batches[i]
+		if batch.Allocations == nil {
+			batch.Allocations = mapset.NewSet[OrderLine]()
+		}
+		if batch.Holds == nil {
+			batch.Holds = mapset.NewSet[Hold]()
+		}
+		if !batch.Status.IsAvailable() || batch.HasExpired(now) || filter != nil && !filter.Accepts(*batch, now) {
+			continue
+		}
+		allocatable := *batch
+		allocatable.heldAt = now
+		batches = append(batches, allocatable)
+	}
+	return batches
+}
+
+// AvailableQuantity returns the stock of the product that can be allocated now, in the unit with the given rounding
+func (p *Product) AvailableQuantity(unit Unit, rounding Rounding) (int, error) {
+	var available int
+	for _, batch := range p.allocatableBatches() {
+		available += max(batch.AvailableQuantity(), 0)
+	}
+	return p.units().FromBase(available, unit, rounding)
+}
+
+// inBaseUnit returns the order line with its quantity in the base unit of the product,
+// so that order lines given in any of the Units can be compared with the batches
+func (p *Product) inBaseUnit(orderLine OrderLine) (OrderLine, error) {
+	quantity, err := p.units().ToBase(orderLine.Quantity, orderLine.Unit)
+	if err != nil {
+		return orderLine, err
+	}
+	orderLine.Quantity, orderLine.Unit = quantity, p.Units.Base
+	return orderLine, nil
+}
+
+func (p *Product) units() UnitsOfMeasure {
+	units := p.Units
+	units.Sku = p.Sku
+	return units
+}
+
+// now returns the time of the Clock of the product, or time.Now when no Clock is set
+func (p *Product) now() time.Time {
+	if p.Clock == nil {
+		return time.Now()
+	}
+	return p.Clock()
+}
+
+// strategy returns the Strategy order lines are allocated with, or the ETAStrategy when none is set
+func (p *Product) strategy() AllocationStrategy {
+	if p.Strategy == nil {
+		return ETAStrategy{}
+	}
+	return p.Strategy
+}
+
+// PopEvents returns the events recorded by the product and clears them
+func (p *Product) PopEvents() []Event {
+	events := p.Events
+	p.Events = nil
+	return events
+}
+
+// PreemptiveAllocation is the batch an order line was allocated to along with every line it displaced from it
+type PreemptiveAllocation struct {
+	BatchRef      Reference
+	Displacements []Displacement
+}
+
+// StatusChange is the status a batch was given along with every order line moved off it
+type StatusChange struct {
+	BatchRef      Reference
+	Status        BatchStatus
+	Displacements []Displacement
+}
+
+// Unmoved returns the order lines that no other batch could take, they have been backordered
+func (s StatusChange) Unmoved() []OrderLine {
+	var unmoved []OrderLine
+	for _, displacement := range s.Displacements {
+		if displacement.ToBatchRef == "" {
+			unmoved = append(unmoved, displacement.OrderLine)
+		}
+	}
+	return unmoved
+}
+
+// Displacement is an order line moved off a batch, to make room for a line of a higher priority or because the
+// batch can no longer be allocated to. ToBatchRef is empty when no batch could take the line and it was backordered.
+type Displacement struct {
+	OrderLine    OrderLine
+	FromBatchRef Reference
+	ToBatchRef   Reference
+}
+
+// ConcurrencyError is returned when a product has been changed by another writer since it was loaded
+type ConcurrencyError struct {
+	Sku           Sku
+	VersionNumber int
+}
+
+func (c ConcurrencyError) Error() string {
+	return fmt.Sprintf("product %s was modified concurrently, version %d is stale", c.Sku, c.VersionNumber)
+}
+
+// HoldExpiredError is returned when a hold is confirmed after it has expired
+type HoldExpiredError struct {
+	OrderID   Reference
+	Sku       Sku
+	ExpiresAt time.Time
+}
+
+func (h HoldExpiredError) Error() string {
+	return fmt.Sprintf("hold of order %s on %s expired at %s", h.OrderID, h.Sku, h.ExpiresAt.Format(time.RFC3339))
+}
@@ -0,0 +1,1246 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProduct_Clone(t *testing.T) {
+	product := NewProduct("SMALL-TABLE", []Batch{NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{})})
+
+	clone := product.Clone()
+	_, err := clone.Allocate(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 4})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 6, clone.Batches[0].AvailableQuantity())
+	assert.Equal(t, 10, product.Batches[0].AvailableQuantity())
+	assert.Empty(t, product.Events)
+}
+
+func TestProduct_AddBatch(t *testing.T) {
+	t.Run("should add a batch of the same sku", func(t *testing.T) {
+		product := NewProduct("SMALL-TABLE", nil)
+
+		err := product.AddBatch(NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{}))
+		assert.Nil(t, err)
+		assert.Len(t, product.Batches, 1)
+	})
+
+	t.Run("records a batch created event", func(t *testing.T) {
+		product := NewProduct("SMALL-TABLE", nil)
+
+		err := product.AddBatch(NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{}))
+		assert.Nil(t, err)
+		assert.Equal(t, []Event{BatchCreated{Reference: "batch-001", Sku: "SMALL-TABLE", Quantity: 10}}, product.Events)
+	})
+
+	t.Run("should not add a batch of a different sku", func(t *testing.T) {
+		product := NewProduct("SMALL-TABLE", nil)
+
+		err := product.AddBatch(NewBatch("batch-001", "BIG-TABLE", 10, time.Time{}))
+		assert.Error(t, err)
+		assert.Len(t, product.Batches, 0)
+	})
+
+	t.Run("should not add the same batch twice", func(t *testing.T) {
+		product := NewProduct("SMALL-TABLE", []Batch{NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{})})
+
+		err := product.AddBatch(NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{}))
+		assert.Error(t, err)
+		assert.Len(t, product.Batches, 1)
+	})
+}
+
+func TestProduct_Allocate(t *testing.T) {
+	t.Run("allocate prefers current stock batches to shipments", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch-001", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 4, 1)),
+			NewBatch("in-stock-batch-001", "RETRO-CLOCK", 100, time.Time{}),
+		})
+
+		batchRef, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("in-stock-batch-001"), batchRef)
+
+		inStockBatch, _ := product.Batch("in-stock-batch-001")
+		shipmentBatch, _ := product.Batch("shipment-batch-001")
+		assert.Equal(t, 90, inStockBatch.AvailableQuantity())
+		assert.Equal(t, 100, shipmentBatch.AvailableQuantity())
+	})
+
+	t.Run("increments the version number", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+		product.VersionNumber = 7
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, 8, product.VersionNumber)
+	})
+
+	t.Run("records an allocated event", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, []Event{Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "batch-001"}}, product.Events)
+	})
+
+	t.Run("records an out of stock event if unable to allocate", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 4, time.Time{})})
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Error(t, err)
+		assert.Equal(t, []Event{OutOfStock{Sku: "RETRO-CLOCK"}}, product.Events)
+	})
+
+	t.Run("does not increment the version number when out of stock", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 4, time.Time{})})
+		product.VersionNumber = 7
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Error(t, err)
+		assert.Equal(t, 7, product.VersionNumber)
+	})
+
+	t.Run("should not allocate an order line of a different sku", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "TEDDY-BEAR", Quantity: 10})
+		assert.Error(t, err)
+	})
+
+	t.Run("returns out of stock error if unable to allocate", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 4, time.Time{})})
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.ErrorIs(t, err, OutOfStockError{"RETRO-CLOCK"})
+	})
+}
+
+func TestProduct_Deallocate(t *testing.T) {
+	t.Run("should deallocate an allocated order line", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+
+		batchRef, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+
+		err = product.Deallocate(batchRef, orderLine)
+		assert.Nil(t, err)
+
+		batch, _ := product.Batch(batchRef)
+		assert.Equal(t, 100, batch.AvailableQuantity())
+		assert.Contains(t, product.Events, Deallocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: batchRef})
+	})
+
+	t.Run("finds the allocation by order and sku whatever the priority of the line", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+		batchRef, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, Priority: Express})
+		assert.Nil(t, err)
+
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+		batch, _ := product.Batch(batchRef)
+		assert.True(t, batch.IsAllocated(orderLine))
+
+		assert.Nil(t, product.Deallocate(batchRef, orderLine))
+		assert.Equal(t, 100, batch.AvailableQuantity())
+	})
+
+	t.Run("fills the backorders with the stock freed", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 20, time.Time{})})
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15}
+		batchRef, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+		backorder := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 10}
+		assert.Nil(t, product.Backorder(backorder))
+
+		assert.Nil(t, product.Deallocate(batchRef, orderLine))
+
+		batch, _ := product.Batch(batchRef)
+		assert.True(t, batch.IsAllocated(backorder))
+		assert.Empty(t, product.Backorders)
+		assert.Contains(t, product.Events, BackorderFilled{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: batchRef})
+	})
+
+	t.Run("returns error if the order line is not allocated", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		err := product.Deallocate("batch-001", OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Error(t, err)
+	})
+
+	t.Run("returns error if the batch does not belong to the product", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		err := product.Deallocate("batch-404", OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Error(t, err)
+	})
+}
+
+func TestProduct_ChangeBatchQuantity(t *testing.T) {
+	t.Run("changes the quantity and records an event", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		err := product.ChangeBatchQuantity("batch-001", 50)
+		assert.Nil(t, err)
+
+		batch, _ := product.Batch("batch-001")
+		assert.Equal(t, 50, batch.Quantity)
+		assert.Equal(t, []Event{BatchQuantityChanged{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 50}}, product.Events)
+	})
+
+	t.Run("returns error for an unknown batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		err := product.ChangeBatchQuantity("batch-404", 50)
+		assert.Error(t, err)
+	})
+
+	t.Run("returns error for a negative quantity", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		err := product.ChangeBatchQuantity("batch-001", -1)
+		assert.Error(t, err)
+	})
+
+	t.Run("moves just enough order lines to the other batches", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+		})
+		smallLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+		largeLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 20}
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		err = product.ChangeBatchQuantity("in-stock-batch", 15)
+		assert.Nil(t, err)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		shipmentBatch, _ := product.Batch("shipment-batch")
+		assert.True(t, inStockBatch.IsAllocated(smallLine))
+		assert.True(t, shipmentBatch.IsAllocated(largeLine))
+		assert.Equal(t, 5, inStockBatch.AvailableQuantity())
+
+		assert.Equal(t, []Event{
+			BatchQuantityChanged{Reference: "in-stock-batch", Sku: "RETRO-CLOCK", Quantity: 15},
+			Deallocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 20, BatchRef: "in-stock-batch"},
+			Allocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 20, BatchRef: "shipment-batch"},
+		}, product.Events)
+	})
+
+	t.Run("backorders order lines that cannot be moved", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 5, time.Time{}.AddDate(0, 1, 0)),
+		})
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 20}
+		_, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		err = product.ChangeBatchQuantity("in-stock-batch", 10)
+		assert.Nil(t, err)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		shipmentBatch, _ := product.Batch("shipment-batch")
+		assert.Equal(t, 0, inStockBatch.AllocatedQuantity())
+		assert.Equal(t, 0, shipmentBatch.AllocatedQuantity())
+		assert.Equal(t, []Event{
+			BatchQuantityChanged{Reference: "in-stock-batch", Sku: "RETRO-CLOCK", Quantity: 10},
+			Deallocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 20, BatchRef: "in-stock-batch"},
+			OutOfStock{Sku: "RETRO-CLOCK"},
+			Backordered{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 20},
+		}, product.Events)
+		assert.Equal(t, []OrderLine{orderLine}, product.Backorders)
+	})
+
+	t.Run("fills backorders when the quantity is increased", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 5, time.Time{})})
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+		assert.Nil(t, product.Backorder(orderLine))
+		product.PopEvents()
+
+		err := product.ChangeBatchQuantity("batch-001", 12)
+		assert.Nil(t, err)
+
+		batch, _ := product.Batch("batch-001")
+		assert.True(t, batch.IsAllocated(orderLine))
+		assert.Empty(t, product.Backorders)
+		assert.Equal(t, []Event{
+			BatchQuantityChanged{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 12},
+			Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "batch-001"},
+			BackorderFilled{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "batch-001"},
+		}, product.Events)
+	})
+}
+
+func TestProduct_TransferStock(t *testing.T) {
+	eta := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("moves stock to a new batch in transit and records events", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 100, time.Time{}, "leeds")})
+
+		err := product.TransferStock("leeds-batch", "transfer-001", 30, "london", eta)
+		assert.Nil(t, err)
+
+		source, _ := product.Batch("leeds-batch")
+		transfer, _ := product.Batch("transfer-001")
+		assert.Equal(t, 70, source.Quantity)
+		assert.Equal(t, 30, transfer.Quantity)
+		assert.Equal(t, Warehouse("london"), transfer.Warehouse)
+		assert.Equal(t, eta, transfer.ETA)
+		assert.False(t, transfer.InWarehouse())
+		assert.Equal(t, []Event{
+			BatchCreated{Reference: "transfer-001", Sku: "RETRO-CLOCK", Quantity: 30, ETA: eta},
+			StockTransferred{FromBatchRef: "leeds-batch", BatchRef: "transfer-001", Sku: "RETRO-CLOCK", Quantity: 30, Destination: "london", ETA: eta},
+			BatchQuantityChanged{Reference: "leeds-batch", Sku: "RETRO-CLOCK", Quantity: 70},
+		}, product.Events)
+	})
+
+	t.Run("keeps the best before date of perishable stock", func(t *testing.T) {
+		bestBefore := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		product := NewProduct("FRESH-MILK", []Batch{NewPerishableBatch("batch-001", "FRESH-MILK", 100, time.Time{}, bestBefore)})
+
+		assert.Nil(t, product.TransferStock("batch-001", "transfer-001", 30, "london", eta))
+
+		transfer, _ := product.Batch("transfer-001")
+		assert.Equal(t, bestBefore, transfer.BestBefore)
+	})
+
+	t.Run("moves the order lines that no longer fit on the source", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 50, time.Time{}, "leeds")})
+		smallLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+		largeLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 30}
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		err = product.TransferStock("leeds-batch", "transfer-001", 35, "london", eta)
+		assert.Nil(t, err)
+
+		source, _ := product.Batch("leeds-batch")
+		transfer, _ := product.Batch("transfer-001")
+		assert.True(t, source.IsAllocated(smallLine))
+		assert.True(t, transfer.IsAllocated(largeLine))
+		assert.Contains(t, product.Events, Allocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 30, BatchRef: "transfer-001"})
+	})
+
+	t.Run("refuses to transfer stock out of a quarantined batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+		_, err := product.ChangeBatchStatus("batch-001", Quarantined)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		err = product.TransferStock("batch-001", "transfer-001", 30, "london", eta)
+		assert.ErrorAs(t, err, &BatchUnavailableError{})
+
+		_, ok := product.Batch("transfer-001")
+		assert.False(t, ok)
+		assert.Len(t, product.Batches, 1)
+		assert.Empty(t, product.Events)
+	})
+
+	t.Run("bumps the version number", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+		assert.Nil(t, product.TransferStock("batch-001", "transfer-001", 30, "london", eta))
+		assert.Equal(t, 1, product.VersionNumber)
+	})
+
+	testCases := []struct {
+		name        string
+		reference   Reference
+		transferRef Reference
+		quantity    int
+		destination Warehouse
+		eta         time.Time
+	}{
+		{name: "returns error for an unknown batch", reference: "batch-404", transferRef: "transfer-001", quantity: 10, destination: "london", eta: eta},
+		{name: "returns error for an existing transfer reference", reference: "batch-001", transferRef: "batch-001", quantity: 10, destination: "london", eta: eta},
+		{name: "returns error for more stock than the batch has", reference: "batch-001", transferRef: "transfer-001", quantity: 101, destination: "london", eta: eta},
+		{name: "returns error for no stock", reference: "batch-001", transferRef: "transfer-001", quantity: 0, destination: "london", eta: eta},
+		{name: "returns error without a destination", reference: "batch-001", transferRef: "transfer-001", quantity: 10, eta: eta},
+		{name: "returns error without an eta", reference: "batch-001", transferRef: "transfer-001", quantity: 10, destination: "london"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 100, time.Time{})})
+
+			err := product.TransferStock(testCase.reference, testCase.transferRef, testCase.quantity, testCase.destination, testCase.eta)
+			assert.Error(t, err)
+			assert.Len(t, product.Batches, 1)
+			assert.Empty(t, product.Events)
+		})
+	}
+}
+
+func TestProduct_ChangeBatchStatus(t *testing.T) {
+	smallLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+	largeLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 30}
+
+	t.Run("moves the order lines of a quarantined batch and reports the ones it could not", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		change, err := product.ChangeBatchStatus("in-stock-batch", Quarantined)
+		assert.Nil(t, err)
+
+		assert.Equal(t, StatusChange{
+			BatchRef: "in-stock-batch",
+			Status:   Quarantined,
+			Displacements: []Displacement{
+				{OrderLine: smallLine, FromBatchRef: "in-stock-batch", ToBatchRef: "shipment-batch"},
+				{OrderLine: largeLine, FromBatchRef: "in-stock-batch"},
+			},
+		}, change)
+		assert.Equal(t, []OrderLine{largeLine}, change.Unmoved())
+		assert.Equal(t, []OrderLine{largeLine}, product.Backorders)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, Quarantined, inStockBatch.Status)
+		assert.Equal(t, 0, inStockBatch.AllocatedQuantity())
+		assert.Contains(t, product.Events, BatchStatusChanged{Reference: "in-stock-batch", Sku: "RETRO-CLOCK", Status: Quarantined})
+	})
+
+	t.Run("releases the holds on the batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Hold(smallLine, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		assert.Nil(t, err)
+
+		_, err = product.ChangeBatchStatus("in-stock-batch", Damaged)
+		assert.Nil(t, err)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 0, inStockBatch.HeldQuantity())
+		assert.Contains(t, product.Events, HoldReleased{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch"})
+	})
+
+	t.Run("does not allocate to a batch that is not available", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.ChangeBatchStatus("in-stock-batch", Quarantined)
+		assert.Nil(t, err)
+
+		batchRef, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("shipment-batch"), batchRef)
+
+		_, err = product.Allocate(largeLine)
+		assert.ErrorAs(t, err, &OutOfStockError{})
+	})
+
+	t.Run("fills the backorders when the batch is available again", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.ChangeBatchStatus("in-stock-batch", Quarantined)
+		assert.Nil(t, err)
+		assert.Nil(t, product.Backorder(largeLine))
+
+		_, err = product.ChangeBatchStatus("in-stock-batch", Available)
+		assert.Nil(t, err)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		assert.True(t, inStockBatch.IsAllocated(largeLine))
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("does nothing when the status is unchanged", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		change, err := product.ChangeBatchStatus("in-stock-batch", Available)
+		assert.Nil(t, err)
+		assert.Empty(t, change.Displacements)
+		assert.Equal(t, 0, product.VersionNumber)
+		assert.Empty(t, product.Events)
+	})
+
+	t.Run("returns error for an unknown batch or status", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		_, err := product.ChangeBatchStatus("batch-404", Quarantined)
+		assert.Error(t, err)
+
+		_, err = product.ChangeBatchStatus("in-stock-batch", "lost")
+		assert.Error(t, err)
+	})
+}
+
+func TestProduct_Backorder(t *testing.T) {
+	t.Run("queues the order line", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+
+		err := product.Backorder(orderLine)
+		assert.Nil(t, err)
+		assert.Equal(t, []OrderLine{orderLine}, product.Backorders)
+		assert.Equal(t, 1, product.VersionNumber)
+		assert.Equal(t, []Event{Backordered{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}}, product.Events)
+	})
+
+	t.Run("rejects an order line of another sku", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+
+		err := product.Backorder(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 10})
+		assert.Error(t, err)
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("rejects an order that is already backordered", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		assert.Nil(t, product.Backorder(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}))
+
+		err := product.Backorder(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Error(t, err)
+		assert.Len(t, product.Backorders, 1)
+	})
+
+	t.Run("a new batch fills backorders in the order they arrived", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		first := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 6}
+		second := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 6}
+		third := OrderLine{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 4}
+		for _, orderLine := range []OrderLine{first, second, third} {
+			assert.Nil(t, product.Backorder(orderLine))
+		}
+		product.PopEvents()
+
+		err := product.AddBatch(NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{}))
+		assert.Nil(t, err)
+
+		assert.Equal(t, []OrderLine{second}, product.Backorders)
+		assert.Equal(t, []Event{
+			BatchCreated{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 10},
+			Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 6, BatchRef: "batch-001"},
+			BackorderFilled{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 6, BatchRef: "batch-001"},
+			Allocated{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 4, BatchRef: "batch-001"},
+			BackorderFilled{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 4, BatchRef: "batch-001"},
+		}, product.Events)
+	})
+}
+
+func TestProduct_Hold(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(15 * time.Minute)
+	orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+
+	t.Run("holds stock on the preferred batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, now.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+		})
+
+		batchRef, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("in-stock-batch"), batchRef)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 10, batch.AvailableQuantity())
+		assert.Equal(t, []Event{Held{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch", ExpiresAt: expiresAt}}, product.Events)
+	})
+
+	t.Run("returns out of stock when no batch can take the hold", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, now.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+		})
+
+		_, err := product.Hold(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 60}, expiresAt)
+		assert.ErrorIs(t, err, OutOfStockError{"RETRO-CLOCK"})
+	})
+
+	t.Run("confirming a hold allocates the order line to the held batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, now.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+		})
+		_, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		batchRef, err := product.ConfirmHold("order-001", now.Add(time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("in-stock-batch"), batchRef)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.True(t, batch.IsAllocated(orderLine))
+		assert.Equal(t, 0, batch.HeldQuantity())
+		assert.Equal(t, 10, batch.AvailableQuantity())
+		assert.Equal(t, []Event{Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch"}}, product.Events)
+	})
+
+	t.Run("cannot confirm an expired hold", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, now.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+		})
+		_, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+
+		_, err = product.ConfirmHold("order-001", expiresAt)
+		assert.ErrorAs(t, err, &HoldExpiredError{})
+	})
+
+	t.Run("cannot confirm an order without a hold", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, now.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+		})
+
+		_, err := product.ConfirmHold("order-001", now)
+		assert.Error(t, err)
+	})
+
+	t.Run("releases only the expired holds", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, now.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+		})
+		product.Clock = func() time.Time { return now }
+		_, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		_, err = product.Hold(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5}, expiresAt.Add(time.Hour))
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		released := product.ReleaseExpiredHolds(expiresAt)
+		assert.Equal(t, []Hold{{OrderLine: orderLine, ExpiresAt: expiresAt}}, released)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 5, batch.HeldQuantity())
+		assert.Equal(t, []Event{HoldReleased{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch"}}, product.Events)
+	})
+
+	t.Run("expired holds no longer reserve stock for allocations", func(t *testing.T) {
+		clock := now
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{})})
+		product.Clock = func() time.Time { return clock }
+		_, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		clock = expiresAt.Add(time.Minute)
+		batchRef, err := product.Allocate(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 15})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("in-stock-batch"), batchRef)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 10, batch.HeldQuantity(), "the expired hold is left for ReleaseExpiredHolds to release")
+		assert.Equal(t, []Event{Allocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 15, BatchRef: "in-stock-batch"}}, product.Events)
+	})
+
+	t.Run("counts the stock of expired holds as available without releasing them", func(t *testing.T) {
+		clock := now
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{})})
+		product.Clock = func() time.Time { return clock }
+		_, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		product.PopEvents()
+		version := product.VersionNumber
+
+		clock = expiresAt.Add(time.Minute)
+		available, err := product.AvailableQuantity("", RoundExact)
+		assert.Nil(t, err)
+		assert.Equal(t, 20, available)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 10, batch.HeldQuantity())
+		assert.Equal(t, version, product.VersionNumber)
+		assert.Empty(t, product.Events)
+	})
+
+	t.Run("released stock fills backorders", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 10, time.Time{})})
+		_, err := product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		backorder := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 8}
+		assert.Nil(t, product.Backorder(backorder))
+
+		product.ReleaseExpiredHolds(expiresAt)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.True(t, batch.IsAllocated(backorder))
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("shrinking a batch releases holds before deallocating order lines", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{})})
+		allocatedLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 8}
+		_, err := product.Allocate(allocatedLine)
+		assert.Nil(t, err)
+		_, err = product.Hold(orderLine, expiresAt)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		assert.Nil(t, product.ChangeBatchQuantity("in-stock-batch", 10))
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.True(t, batch.IsAllocated(allocatedLine))
+		assert.Equal(t, 0, batch.HeldQuantity())
+		assert.Equal(t, []Event{
+			BatchQuantityChanged{Reference: "in-stock-batch", Sku: "RETRO-CLOCK", Quantity: 10},
+			HoldReleased{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch"},
+		}, product.Events)
+	})
+}
+
+func TestProduct_AllocatePreempting(t *testing.T) {
+	bulkLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 8, Priority: Bulk}
+	standardLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 10}
+	expressLine := OrderLine{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 6, Priority: Express}
+
+	t.Run("uses warehouse stock with room without displacing anything", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		allocation, err := product.AllocatePreempting(expressLine)
+		assert.Nil(t, err)
+		assert.Equal(t, PreemptiveAllocation{BatchRef: "in-stock-batch"}, allocation)
+	})
+
+	t.Run("displaces the lowest priority lines to a shipment", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+		})
+		for _, orderLine := range []OrderLine{bulkLine, standardLine} {
+			_, err := product.Allocate(orderLine)
+			assert.Nil(t, err)
+		}
+		product.PopEvents()
+
+		allocation, err := product.AllocatePreempting(expressLine)
+		assert.Nil(t, err)
+		assert.Equal(t, PreemptiveAllocation{
+			BatchRef:      "in-stock-batch",
+			Displacements: []Displacement{{OrderLine: bulkLine, FromBatchRef: "in-stock-batch", ToBatchRef: "shipment-batch"}},
+		}, allocation)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		assert.True(t, inStockBatch.IsAllocated(expressLine))
+		assert.True(t, inStockBatch.IsAllocated(standardLine))
+		assert.Equal(t, []Event{
+			Preempted{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 8, BatchRef: "in-stock-batch", PreemptedBy: "order-003"},
+			Allocated{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 6, BatchRef: "in-stock-batch"},
+			Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 8, BatchRef: "shipment-batch"},
+		}, product.Events)
+	})
+
+	t.Run("does not displace lines of the same priority", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 18, Priority: Express})
+		assert.Nil(t, err)
+
+		allocation, err := product.AllocatePreempting(expressLine)
+		assert.Nil(t, err)
+		assert.Equal(t, PreemptiveAllocation{BatchRef: "shipment-batch"}, allocation)
+	})
+
+	t.Run("backorders displaced lines no shipment can take", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 10, time.Time{})})
+		_, err := product.Allocate(bulkLine)
+		assert.Nil(t, err)
+
+		allocation, err := product.AllocatePreempting(expressLine)
+		assert.Nil(t, err)
+		assert.Equal(t, []Displacement{{OrderLine: bulkLine, FromBatchRef: "in-stock-batch"}}, allocation.Displacements)
+		assert.Equal(t, []OrderLine{bulkLine}, product.Backorders)
+	})
+}
+
+func TestProduct_FillBackordersByPriority(t *testing.T) {
+	product := NewProduct("RETRO-CLOCK", nil)
+	bulkLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5, Priority: Bulk}
+	standardLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5}
+	expressLine := OrderLine{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 5, Priority: Express}
+	for _, orderLine := range []OrderLine{bulkLine, standardLine, expressLine} {
+		assert.Nil(t, product.Backorder(orderLine))
+	}
+
+	assert.Nil(t, product.AddBatch(NewBatch("batch-001", "RETRO-CLOCK", 10, time.Time{})))
+
+	batch, _ := product.Batch("batch-001")
+	assert.True(t, batch.IsAllocated(expressLine))
+	assert.True(t, batch.IsAllocated(standardLine))
+	assert.Equal(t, []OrderLine{bulkLine}, product.Backorders)
+}
+
+func TestProduct_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orderLine := OrderLine{OrderID: "order-001", Sku: "FRESH-MILK", Quantity: 5}
+
+	t.Run("does not allocate to expired stock", func(t *testing.T) {
+		product := NewProduct("FRESH-MILK", []Batch{NewPerishableBatch("batch-001", "FRESH-MILK", 20, time.Time{}, now)})
+		product.Clock = func() time.Time { return now }
+
+		_, err := product.Allocate(orderLine)
+		assert.ErrorIs(t, err, OutOfStockError{"FRESH-MILK"})
+	})
+
+	t.Run("flags batches that expire within the warning period once", func(t *testing.T) {
+		product := NewProduct("FRESH-MILK", []Batch{
+			NewPerishableBatch("soon-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2)),
+			NewPerishableBatch("late-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 10)),
+		})
+		product.Clock = func() time.Time { return now }
+
+		assert.True(t, product.ReviewExpiry(3*24*time.Hour))
+		assert.False(t, product.ReviewExpiry(3*24*time.Hour))
+
+		soonBatch, _ := product.Batch("soon-batch")
+		lateBatch, _ := product.Batch("late-batch")
+		assert.True(t, soonBatch.FlaggedExpiring)
+		assert.False(t, lateBatch.FlaggedExpiring)
+		assert.Equal(t, []Event{BatchExpiring{Reference: "soon-batch", Sku: "FRESH-MILK", BestBefore: now.AddDate(0, 0, 2)}}, product.Events)
+	})
+
+	t.Run("moves order lines off batches that expired before dispatch", func(t *testing.T) {
+		clock := now
+		product := NewProduct("FRESH-MILK", []Batch{
+			NewPerishableBatch("soon-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2)),
+			NewPerishableBatch("late-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 10)),
+		})
+		product.Clock = func() time.Time { return clock }
+		_, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+		product.ReviewExpiry(0)
+		product.PopEvents()
+
+		clock = now.AddDate(0, 0, 2)
+		assert.True(t, product.ReviewExpiry(0))
+
+		lateBatch, _ := product.Batch("late-batch")
+		assert.True(t, lateBatch.IsAllocated(orderLine))
+		assert.Equal(t, []Event{
+			BatchExpired{Reference: "soon-batch", Sku: "FRESH-MILK", BestBefore: now.AddDate(0, 0, 2)},
+			Deallocated{OrderID: "order-001", Sku: "FRESH-MILK", Quantity: 5, BatchRef: "soon-batch"},
+			Allocated{OrderID: "order-001", Sku: "FRESH-MILK", Quantity: 5, BatchRef: "late-batch"},
+		}, product.Events)
+	})
+
+	t.Run("backorders order lines no other batch can take", func(t *testing.T) {
+		product := NewProduct("FRESH-MILK", []Batch{NewPerishableBatch("batch-001", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2))})
+		clock := now
+		product.Clock = func() time.Time { return clock }
+		_, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+
+		clock = now.AddDate(0, 0, 3)
+		product.ReviewExpiry(0)
+
+		batch, _ := product.Batch("batch-001")
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+		assert.Equal(t, []OrderLine{orderLine}, product.Backorders)
+	})
+}
+
+func TestProduct_PopEvents(t *testing.T) {
+	product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 4, time.Time{})})
+
+	_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+	assert.Error(t, err)
+
+	assert.Equal(t, []Event{OutOfStock{Sku: "RETRO-CLOCK"}}, product.PopEvents())
+	assert.Empty(t, product.Events)
+}
+
+func TestProduct_AllocateSplit(t *testing.T) {
+	t.Run("uses a single batch when one can take the whole line", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		allocations, err := product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, []BatchAllocation{{BatchRef: "in-stock-batch", Quantity: 10}}, allocations)
+	})
+
+	t.Run("splits the line across batches in the order of the strategy", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("late-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 2, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("soon-batch", "RETRO-CLOCK", 15, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		allocations, err := product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.Nil(t, err)
+		assert.Equal(t, []BatchAllocation{
+			{BatchRef: "in-stock-batch", Quantity: 20},
+			{BatchRef: "soon-batch", Quantity: 15},
+			{BatchRef: "late-batch", Quantity: 5},
+		}, allocations)
+
+		lateBatch, _ := product.Batch("late-batch")
+		assert.Equal(t, 25, lateBatch.AvailableQuantity())
+		assert.Equal(t, []Event{
+			Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 20, BatchRef: "in-stock-batch"},
+			Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15, BatchRef: "soon-batch"},
+			Allocated{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5, BatchRef: "late-batch"},
+		}, product.Events)
+	})
+
+	t.Run("allocates nothing when the batches together do not have enough", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 15, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		_, err := product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.ErrorIs(t, err, OutOfStockError{"RETRO-CLOCK"})
+
+		for _, batch := range product.Batches {
+			assert.Equal(t, 0, batch.AllocatedQuantity())
+		}
+		assert.Equal(t, []Event{OutOfStock{Sku: "RETRO-CLOCK"}}, product.Events)
+	})
+}
+
+func TestProduct_BackorderSplit(t *testing.T) {
+	t.Run("allocates what fits and backorders the rest as split", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 15, time.Time{}.AddDate(0, 1, 0)),
+		})
+
+		allocations, err := product.BackorderSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.Nil(t, err)
+		assert.Equal(t, []BatchAllocation{{BatchRef: "in-stock-batch", Quantity: 20}, {BatchRef: "shipment-batch", Quantity: 15}}, allocations)
+		assert.Equal(t, []OrderLine{{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5, Split: true}}, product.Backorders)
+		assert.Equal(t, 1, product.VersionNumber)
+	})
+
+	t.Run("fills the rest across the batches as they arrive", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{})})
+		_, err := product.BackorderSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 30})
+		assert.Nil(t, err)
+		product.Events = nil
+
+		assert.Nil(t, product.AddBatch(NewBatch("small-batch", "RETRO-CLOCK", 4, time.Time{}.AddDate(0, 1, 0))))
+		assert.Equal(t, []OrderLine{{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 6, Split: true}}, product.Backorders)
+
+		assert.Nil(t, product.ChangeBatchQuantity("in-stock-batch", 23))
+		assert.Equal(t, []OrderLine{{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3, Split: true}}, product.Backorders)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 0, inStockBatch.AvailableQuantity())
+		assert.Equal(t, []OrderLine{{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 23}}, inStockBatch.Allocations.ToSlice())
+		assert.Contains(t, product.Events, BackorderFilled{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 4, BatchRef: "small-batch"})
+		assert.Contains(t, product.Events, BackorderFilled{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 3, BatchRef: "in-stock-batch"})
+	})
+}
+
+func TestProduct_DeallocateOrderLine(t *testing.T) {
+	t.Run("removes every part of a split line", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		otherLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5}
+		_, err := product.Allocate(otherLine)
+		assert.Nil(t, err)
+
+		_, err = product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.Nil(t, err)
+
+		deallocated, err := product.DeallocateOrderLine("order-001")
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []BatchAllocation{{BatchRef: "in-stock-batch", Quantity: 15}, {BatchRef: "shipment-batch", Quantity: 25}}, deallocated)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		shipmentBatch, _ := product.Batch("shipment-batch")
+		assert.True(t, inStockBatch.IsAllocated(otherLine))
+		assert.Equal(t, 5, inStockBatch.AllocatedQuantity())
+		assert.Equal(t, 0, shipmentBatch.AllocatedQuantity())
+	})
+
+	t.Run("fills the backorders with the stock freed", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.Nil(t, err)
+		backorder := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 15}
+		assert.Nil(t, product.Backorder(backorder))
+
+		_, err = product.DeallocateOrderLine("order-001")
+		assert.Nil(t, err)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		assert.True(t, inStockBatch.IsAllocated(backorder))
+		assert.Empty(t, product.Backorders)
+		assert.Contains(t, product.Events, BackorderFilled{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 15, BatchRef: "in-stock-batch"})
+	})
+
+	t.Run("returns error if the order has nothing allocated", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{})})
+
+		_, err := product.DeallocateOrderLine("order-001")
+		assert.Error(t, err)
+	})
+}
+
+func TestProduct_CancelOrder(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("deallocates, releases and drops every line of the order", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		product.Clock = func() time.Time { return now }
+		_, err := product.Hold(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5}, now.Add(time.Hour))
+		assert.Nil(t, err)
+		_, err = product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.Nil(t, err)
+		assert.Nil(t, product.Backorder(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 50}))
+		product.PopEvents()
+
+		deallocated, ok := product.CancelOrder("order-001")
+		assert.True(t, ok)
+		assert.ElementsMatch(t, []BatchAllocation{{BatchRef: "in-stock-batch", Quantity: 15}, {BatchRef: "shipment-batch", Quantity: 25}}, deallocated)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		shipmentBatch, _ := product.Batch("shipment-batch")
+		assert.Equal(t, 20, inStockBatch.AvailableQuantity())
+		assert.Equal(t, 30, shipmentBatch.AvailableQuantity())
+		assert.Empty(t, product.Backorders)
+		assert.Contains(t, product.Events, OrderCancelled{OrderID: "order-001", Sku: "RETRO-CLOCK"})
+	})
+
+	t.Run("fills the backorders of other orders with the stock freed", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		product.Clock = func() time.Time { return now }
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 30})
+		assert.Nil(t, err)
+		backorder := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 25}
+		assert.Nil(t, product.Backorder(backorder))
+
+		_, ok := product.CancelOrder("order-001")
+		assert.True(t, ok)
+		assert.Empty(t, product.Backorders)
+
+		shipmentBatch, _ := product.Batch("shipment-batch")
+		assert.True(t, shipmentBatch.IsAllocated(backorder))
+	})
+
+	t.Run("leaves the product unchanged for an order it has nothing of", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		product.Clock = func() time.Time { return now }
+
+		deallocated, ok := product.CancelOrder("order-404")
+		assert.False(t, ok)
+		assert.Nil(t, deallocated)
+		assert.Equal(t, 0, product.VersionNumber)
+		assert.Empty(t, product.Events)
+	})
+}
+
+func TestProduct_AmendOrderLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		quantity int
+	}{
+		{name: "increases the line in its batch when the batch has room", quantity: 15},
+		{name: "decreases the line in its batch", quantity: 4},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			product := NewProduct("RETRO-CLOCK", []Batch{
+				NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+				NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+			})
+			_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, Priority: Express})
+			assert.Nil(t, err)
+			_, err = product.Allocate(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5})
+			assert.Nil(t, err)
+			product.PopEvents()
+
+			batchRef, err := product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: testCase.quantity})
+			assert.Nil(t, err)
+			assert.Equal(t, Reference("in-stock-batch"), batchRef)
+
+			batch, _ := product.Batch("in-stock-batch")
+			assert.True(t, batch.IsAllocated(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: testCase.quantity, Priority: Express}))
+			assert.Equal(t, []Event{OrderLineAmended{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: testCase.quantity, BatchRef: "in-stock-batch"}}, product.Events)
+		})
+	}
+
+	t.Run("reallocates the line when its batch has no room", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, Priority: Express})
+		assert.Nil(t, err)
+		_, err = product.Allocate(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		batchRef, err := product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 25})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("shipment-batch"), batchRef)
+
+		inStockBatch, _ := product.Batch("in-stock-batch")
+		shipmentBatch, _ := product.Batch("shipment-batch")
+		assert.Equal(t, 5, inStockBatch.AllocatedQuantity())
+		assert.True(t, shipmentBatch.IsAllocated(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 25, Priority: Express}))
+		assert.Equal(t, []Event{OrderLineAmended{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 25, BatchRef: "shipment-batch", Reallocated: true}}, product.Events)
+	})
+
+	t.Run("fills a backorder with the stock freed by reducing the line", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{})})
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15})
+		assert.Nil(t, err)
+		backorder := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 8}
+		assert.Nil(t, product.Backorder(backorder))
+		product.PopEvents()
+
+		_, err = product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10})
+		assert.Nil(t, err)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.True(t, batch.IsAllocated(backorder))
+		assert.Empty(t, product.Backorders)
+		assert.Equal(t, []Event{
+			OrderLineAmended{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch"},
+			Allocated{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 8, BatchRef: "in-stock-batch"},
+			BackorderFilled{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 8, BatchRef: "in-stock-batch"},
+		}, product.Events)
+	})
+
+	t.Run("leaves the line as it was when no batch can take the new quantity", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, Priority: Express})
+		assert.Nil(t, err)
+		_, err = product.Allocate(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Nil(t, err)
+		product.PopEvents()
+		original := product.Clone()
+
+		_, err = product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.ErrorAs(t, err, &OutOfStockError{})
+		assert.EqualError(t, err, "could not amend order order-001 to 40 of RETRO-CLOCK: RETRO-CLOCK is out of stock")
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.True(t, batch.IsAllocated(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, Priority: Express}))
+		assert.Equal(t, original.VersionNumber, product.VersionNumber)
+		assert.Empty(t, product.Events)
+	})
+
+	splitCases := []struct {
+		name      string
+		quantity  int
+		allocated map[Reference]int
+	}{
+		{name: "shrinks the part of a split line in the latest batch", quantity: 14, allocated: map[Reference]int{"batch-a": 10, "batch-b": 4}},
+		{name: "drops the parts of a split line the decrease no longer needs", quantity: 8, allocated: map[Reference]int{"batch-a": 8}},
+		{name: "tops up a split line across the batches with room", quantity: 22, allocated: map[Reference]int{"batch-a": 10, "batch-b": 10, "batch-c": 2}},
+	}
+
+	for _, splitCase := range splitCases {
+		t.Run(splitCase.name, func(t *testing.T) {
+			product := NewProduct("RETRO-CLOCK", []Batch{
+				NewBatch("batch-a", "RETRO-CLOCK", 10, time.Time{}),
+				NewBatch("batch-b", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+				NewBatch("batch-c", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 2, 0)),
+			})
+			_, err := product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15})
+			assert.Nil(t, err)
+			product.PopEvents()
+
+			batchRef, err := product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: splitCase.quantity})
+			assert.Nil(t, err)
+			assert.Equal(t, Reference("batch-a"), batchRef)
+
+			allocated := make(map[Reference]int)
+			for _, batch := range product.Batches {
+				if part, ok := batch.AllocationOf("order-001", "RETRO-CLOCK"); ok {
+					allocated[batch.Reference] = part.Quantity
+				}
+			}
+			assert.Equal(t, splitCase.allocated, allocated)
+			assert.Equal(t, []Event{OrderLineAmended{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: splitCase.quantity, BatchRef: "batch-a"}}, product.Events)
+		})
+	}
+
+	t.Run("leaves a split line as it was when the batches cannot take the increase", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("batch-a", "RETRO-CLOCK", 10, time.Time{}),
+			NewBatch("batch-b", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.AllocateSplit(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15})
+		assert.Nil(t, err)
+		product.PopEvents()
+		original := product.Clone()
+
+		_, err = product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 21})
+		assert.ErrorAs(t, err, &OutOfStockError{})
+
+		assert.Equal(t, original.Batches, product.Batches)
+		assert.Equal(t, original.VersionNumber, product.VersionNumber)
+		assert.Empty(t, product.Events)
+	})
+
+	t.Run("returns error for an order without allocations or a quantity that is not positive", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, Priority: Express})
+		assert.Nil(t, err)
+		_, err = product.Allocate(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		_, err = product.AmendOrderLine(OrderLine{OrderID: "order-404", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Error(t, err)
+
+		_, err = product.AmendOrderLine(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 0})
+		assert.Error(t, err)
+	})
+}
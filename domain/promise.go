@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// LeadTime is how long it takes to dispatch an order line of the sku once its stock is available.
+// Stock in the warehouse is dispatched InStock after the order, stock on its way Inbound after it arrives.
+type LeadTime struct {
+	Sku     Sku
+	InStock time.Duration
+	Inbound time.Duration
+}
+
+// Promise is when an order line allocated to the batch can be dispatched. A batch without an ETA, or whose ETA has
+// passed, is in stock.
+type Promise struct {
+	BatchRef     Reference
+	ETA          time.Time
+	InStock      bool
+	DispatchDate time.Time
+}
+
+// Promise returns when an order line allocated to the batch of the product with the given reference can be dispatched
+func (p *Product) Promise(reference Reference) (Promise, error) {
+	batch, ok := p.Batch(reference)
+	if !ok {
+		return Promise{}, fmt.Errorf("batch %s does not belong to product %s", reference, p.Sku)
+	}
+	return p.promise(*batch), nil
+}
+
+// PromiseQuantity returns the earliest dispatch date of the order line without allocating it,
+// from the batch that would be first to dispatch it of the batches that have room for the whole line now
+func (p *Product) PromiseQuantity(orderLine OrderLine) (Promise, error) {
+	orderLine, err := p.inBaseUnit(orderLine)
+	if err != nil {
+		return Promise{}, err
+	}
+
+	var promises []Promise
+	for _, batch := range p.allocatableBatches() {
+		if canAllocate, _ := batch.CanAllocate(orderLine); canAllocate {
+			promises = append(promises, p.promise(batch))
+		}
+	}
+	if len(promises) == 0 {
+		return Promise{}, OutOfStockError{sku: orderLine.Sku}
+	}
+	return slices.MinFunc(promises, func(aPromise, bPromise Promise) int {
+		return aPromise.DispatchDate.Compare(bPromise.DispatchDate)
+	}), nil
+}
+
+// promise dispatches from the batch after the LeadTime of the product, as soon as its stock is available without one
+func (p *Product) promise(batch Batch) Promise {
+	now := p.now()
+	if !batch.ETA.After(now) {
+		return Promise{BatchRef: batch.Reference, InStock: true, DispatchDate: now.Add(p.LeadTime.InStock)}
+	}
+	return Promise{BatchRef: batch.Reference, ETA: batch.ETA, DispatchDate: batch.ETA.Add(p.LeadTime.Inbound)}
+}
@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProduct_Promise(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Run("promises stock in the warehouse after the in stock lead time", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			NewBatch("arrived-batch", "RETRO-CLOCK", 5, now.AddDate(0, 0, -1)),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		})
+		product.Clock = func() time.Time { return now }
+		product.LeadTime = LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}
+
+		promise, err := product.Promise("in-stock-batch")
+		assert.Nil(t, err)
+		assert.Equal(t, Promise{BatchRef: "in-stock-batch", InStock: true, DispatchDate: now.AddDate(0, 0, 1)}, promise)
+	})
+
+	t.Run("promises stock that has arrived as in stock", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			NewBatch("arrived-batch", "RETRO-CLOCK", 5, now.AddDate(0, 0, -1)),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		})
+		product.Clock = func() time.Time { return now }
+		product.LeadTime = LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}
+
+		promise, err := product.Promise("arrived-batch")
+		assert.Nil(t, err)
+		assert.Equal(t, Promise{BatchRef: "arrived-batch", InStock: true, DispatchDate: now.AddDate(0, 0, 1)}, promise)
+	})
+
+	t.Run("promises stock on its way the inbound lead time after its eta", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			NewBatch("arrived-batch", "RETRO-CLOCK", 5, now.AddDate(0, 0, -1)),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		})
+		product.Clock = func() time.Time { return now }
+		product.LeadTime = LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}
+
+		promise, err := product.Promise("shipment-batch")
+		assert.Nil(t, err)
+		assert.Equal(t, Promise{BatchRef: "shipment-batch", ETA: now.AddDate(0, 0, 7), DispatchDate: now.AddDate(0, 0, 9)}, promise)
+	})
+
+	t.Run("returns error for a batch of another product", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			NewBatch("arrived-batch", "RETRO-CLOCK", 5, now.AddDate(0, 0, -1)),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		})
+		product.Clock = func() time.Time { return now }
+		product.LeadTime = LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}
+
+		_, err := product.Promise("lamp-batch")
+		assert.Error(t, err)
+	})
+}
+
+func TestProduct_PromiseQuantity(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	product := NewProduct("RETRO-CLOCK", nil)
+	product.Clock = func() time.Time { return now }
+	product.Units = UnitsOfMeasure{Base: "each", Factors: map[Unit]int{"case": 4}}
+	assert.Nil(t, product.AddBatch(NewBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7))))
+	assert.Nil(t, product.AddBatch(NewBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{})))
+	product.Events = nil
+
+	testCases := []struct {
+		name     string
+		line     OrderLine
+		expected Promise
+	}{
+		{
+			name:     "promises the stock in the warehouse when it has room",
+			line:     OrderLine{Sku: "RETRO-CLOCK", Quantity: 5},
+			expected: Promise{BatchRef: "in-stock-batch", InStock: true, DispatchDate: now},
+		},
+		{
+			name:     "promises the stock on its way when the warehouse has too little",
+			line:     OrderLine{Sku: "RETRO-CLOCK", Quantity: 6},
+			expected: Promise{BatchRef: "shipment-batch", ETA: now.AddDate(0, 0, 7), DispatchDate: now.AddDate(0, 0, 7)},
+		},
+		{
+			name:     "promises a quantity in a unit of the sku",
+			line:     OrderLine{Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"},
+			expected: Promise{BatchRef: "shipment-batch", ETA: now.AddDate(0, 0, 7), DispatchDate: now.AddDate(0, 0, 7)},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			promise, err := product.PromiseQuantity(testCase.line)
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.expected, promise)
+		})
+	}
+
+	t.Run("returns error when no batch has room", func(t *testing.T) {
+		_, err := product.PromiseQuantity(OrderLine{Sku: "RETRO-CLOCK", Quantity: 21})
+		assert.ErrorAs(t, err, &OutOfStockError{})
+	})
+
+	t.Run("does not allocate", func(t *testing.T) {
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+		assert.Empty(t, product.Events)
+	})
+}
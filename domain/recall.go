@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RecallPolicy decides what happens to the order lines allocated to a recalled batch
+type RecallPolicy string
+
+const (
+	// DeallocateRecalled moves the order lines to other batches of the product, or backorders them
+	DeallocateRecalled RecallPolicy = "deallocate"
+	// FlagRecalled leaves the order lines allocated to the recalled batch so that they can be dealt with by hand
+	FlagRecalled RecallPolicy = "flag"
+)
+
+// IsValid returns true for the known recall policies
+func (r RecallPolicy) IsValid() bool {
+	return r == DeallocateRecalled || r == FlagRecalled
+}
+
+// RecalledLine is an order line that was allocated to a recalled batch.
+// ToBatchRef is the batch the line was moved to, it is empty when the line was flagged or backordered.
+type RecalledLine struct {
+	OrderLine
+	BatchRef   Reference
+	ToBatchRef Reference
+}
+
+// RecalledBatch is a batch taken out of allocation by a recall
+type RecalledBatch struct {
+	Reference Reference
+	Sku       Sku
+}
+
+// RecallReport records the batches of a recall and every order line affected by it
+type RecallReport struct {
+	RecallID   Reference
+	Policy     RecallPolicy
+	RecalledAt time.Time
+	Batches    []RecalledBatch
+	Lines      []RecalledLine
+}
+
+// OrderIDs returns the orders affected by the recall, sorted and without duplicates
+func (r RecallReport) OrderIDs() []Reference {
+	var orderIDs []Reference
+	for _, line := range r.Lines {
+		orderIDs = append(orderIDs, line.OrderID)
+	}
+	slices.Sort(orderIDs)
+	return slices.Compact(orderIDs)
+}
+
+// Recall marks the batch as recalled so that it can no longer be allocated to, releases its holds and deals with its
+// order lines according to the policy. Every affected line is recorded with an OrderLineRecalled event.
+func (p *Product) Recall(reference Reference, policy RecallPolicy) ([]RecalledLine, error) {
+	batch, ok := p.Batch(reference)
+	if !ok {
+		return nil, fmt.Errorf("batch %s does not belong to product %s", reference, p.Sku)
+	}
+	if !policy.IsValid() {
+		return nil, fmt.Errorf("unknown recall policy %q", policy)
+	}
+	if batch.Status == Recalled {
+		return nil, fmt.Errorf("batch %s has already been recalled", reference)
+	}
+
+	var lines []RecalledLine
+	if policy == DeallocateRecalled {
+		change, err := p.ChangeBatchStatus(reference, Recalled)
+		if err != nil {
+			return nil, err
+		}
+		for _, displacement := range change.Displacements {
+			lines = append(lines, RecalledLine{OrderLine: displacement.OrderLine, BatchRef: reference, ToBatchRef: displacement.ToBatchRef})
+		}
+	} else {
+		batch.Status = Recalled
+		p.VersionNumber++
+		p.Events = append(p.Events, BatchStatusChanged{Reference: reference, Sku: p.Sku, Status: Recalled})
+		for _, hold := range batch.Holds.ToSlice() {
+			p.release(batch, hold)
+		}
+		for _, orderLine := range batch.Allocations.ToSlice() {
+			lines = append(lines, RecalledLine{OrderLine: orderLine, BatchRef: reference})
+		}
+		slices.SortFunc(lines, func(aLine, bLine RecalledLine) int {
+			return strings.Compare(string(aLine.OrderID), string(bLine.OrderID))
+		})
+	}
+
+	for _, line := range lines {
+		p.Events = append(p.Events, OrderLineRecalled{OrderID: line.OrderID, Sku: line.Sku, Quantity: line.Quantity, BatchRef: reference, Policy: policy})
+	}
+	return lines, nil
+}
@@ -0,0 +1,114 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProduct_Recall(t *testing.T) {
+	smallLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+	largeLine := OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 30}
+
+	t.Run("deallocate policy moves the order lines off the recalled batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		lines, err := product.Recall("in-stock-batch", DeallocateRecalled)
+		assert.Nil(t, err)
+		assert.Equal(t, []RecalledLine{
+			{OrderLine: smallLine, BatchRef: "in-stock-batch", ToBatchRef: "shipment-batch"},
+			{OrderLine: largeLine, BatchRef: "in-stock-batch"},
+		}, lines)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, Recalled, batch.Status)
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+		assert.Equal(t, []OrderLine{largeLine}, product.Backorders)
+		assert.Contains(t, product.Events, OrderLineRecalled{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 30, BatchRef: "in-stock-batch", Policy: DeallocateRecalled})
+	})
+
+	t.Run("flag policy leaves the order lines on the recalled batch", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		lines, err := product.Recall("in-stock-batch", FlagRecalled)
+		assert.Nil(t, err)
+		assert.Equal(t, []RecalledLine{
+			{OrderLine: smallLine, BatchRef: "in-stock-batch"},
+			{OrderLine: largeLine, BatchRef: "in-stock-batch"},
+		}, lines)
+
+		batch, _ := product.Batch("in-stock-batch")
+		assert.Equal(t, Recalled, batch.Status)
+		assert.True(t, batch.IsAllocated(smallLine))
+		assert.True(t, batch.IsAllocated(largeLine))
+		assert.Empty(t, product.Backorders)
+		assert.Equal(t, []Event{
+			BatchStatusChanged{Reference: "in-stock-batch", Sku: "RETRO-CLOCK", Status: Recalled},
+			OrderLineRecalled{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "in-stock-batch", Policy: FlagRecalled},
+			OrderLineRecalled{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 30, BatchRef: "in-stock-batch", Policy: FlagRecalled},
+		}, product.Events)
+	})
+
+	t.Run("returns error for a batch that has already been recalled", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+		_, err = product.Recall("in-stock-batch", FlagRecalled)
+		assert.Nil(t, err)
+
+		_, err = product.Recall("in-stock-batch", DeallocateRecalled)
+		assert.Error(t, err)
+	})
+
+	t.Run("returns error for an unknown batch or policy", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("shipment-batch", "RETRO-CLOCK", 25, time.Time{}.AddDate(0, 1, 0)),
+		})
+		_, err := product.Allocate(smallLine)
+		assert.Nil(t, err)
+		_, err = product.Allocate(largeLine)
+		assert.Nil(t, err)
+		product.PopEvents()
+
+		_, err = product.Recall("batch-404", FlagRecalled)
+		assert.Error(t, err)
+
+		_, err = product.Recall("in-stock-batch", "destroy")
+		assert.Error(t, err)
+		assert.Empty(t, product.Events)
+	})
+}
+
+func TestRecallReport_OrderIDs(t *testing.T) {
+	report := RecallReport{Lines: []RecalledLine{
+		{OrderLine: OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5}, BatchRef: "batch-001"},
+		{OrderLine: OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5}, BatchRef: "batch-001"},
+		{OrderLine: OrderLine{OrderID: "order-002", Sku: "BLUE-LAMP", Quantity: 5}, BatchRef: "batch-002"},
+	}}
+
+	assert.Equal(t, []Reference{"order-001", "order-002"}, report.OrderIDs())
+}
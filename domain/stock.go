@@ -3,6 +3,7 @@ package domain
 import (
 	"fmt"
 	"slices"
+	"strings"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
@@ -11,12 +12,21 @@ import (
 type Sku string
 type Reference string
 
+// Batch is a quantity of a sku, either in the warehouse or on its way
 type Batch struct {
-	Reference   Reference
-	Sku         Sku
-	Quantity    int
-	ETA         time.Time
-	Allocations mapset.Set[OrderLine]
+	Reference       Reference
+	Sku             Sku
+	Quantity        int
+	Unit            Unit
+	ETA             time.Time
+	Warehouse       Warehouse
+	Status          BatchStatus
+	BestBefore      time.Time
+	FlaggedExpiring bool
+	Allocations     mapset.Set[OrderLine]
+	Holds           mapset.Set[Hold]
+	// heldAt leaves the holds that have expired by then out of the held quantity, a zero time counts every hold
+	heldAt time.Time
 }
 
 func NewBatch(reference Reference, sku Sku, Quantity int, eta time.Time) Batch {
@@ -26,13 +36,120 @@ func NewBatch(reference Reference, sku Sku, Quantity int, eta time.Time) Batch {
 		Quantity:    Quantity,
 		ETA:         eta,
 		Allocations: mapset.NewSet[OrderLine](),
+		Holds:       mapset.NewSet[Hold](),
 	}
 }
 
+// NewPerishableBatch returns a batch of stock that cannot be allocated from its best before date
+func NewPerishableBatch(reference Reference, sku Sku, quantity int, eta time.Time, bestBefore time.Time) Batch {
+	batch := NewBatch(reference, sku, quantity, eta)
+	batch.BestBefore = bestBefore
+	return batch
+}
+
+// NewWarehouseBatch returns a batch of stock kept at the warehouse
+func NewWarehouseBatch(reference Reference, sku Sku, quantity int, eta time.Time, warehouse Warehouse) Batch {
+	batch := NewBatch(reference, sku, quantity, eta)
+	batch.Warehouse = warehouse
+	return batch
+}
+
+// Clone returns a copy of the batch that does not share its allocations or holds
+func (b Batch) Clone() Batch {
+	if b.Allocations != nil {
+		b.Allocations = b.Allocations.Clone()
+	}
+	if b.Holds != nil {
+		b.Holds = b.Holds.Clone()
+	}
+	return b
+}
+
+// OrderLine is a quantity of a single sku ordered, lines without a Priority are Standard
+// and lines without a Unit are counted in the base unit of the sku.
+// A backordered line that is Split may be allocated across batches as stock arrives.
 type OrderLine struct {
 	OrderID  Reference
 	Sku      Sku
 	Quantity int
+	Unit     Unit
+	Priority Priority
+	Split    bool
+}
+
+// Priority decides which order lines keep their stock when there is not enough for every line
+type Priority string
+
+const (
+	Express  Priority = "express"
+	Standard Priority = "standard"
+	Bulk     Priority = "bulk"
+)
+
+// IsValid returns true for the known priorities, an empty priority is Standard
+func (p Priority) IsValid() bool {
+	switch p {
+	case Express, Standard, Bulk, "":
+		return true
+	}
+	return false
+}
+
+// Outranks returns true if the priority is higher than the other one
+func (p Priority) Outranks(other Priority) bool {
+	return p.level() > other.level()
+}
+
+func (p Priority) level() int {
+	switch p {
+	case Express:
+		return 2
+	case Bulk:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// BatchStatus decides whether a batch can be allocated to, batches without a Status are Available
+type BatchStatus string
+
+const (
+	Available   BatchStatus = "available"
+	Quarantined BatchStatus = "quarantined"
+	Damaged     BatchStatus = "damaged"
+	Recalled    BatchStatus = "recalled"
+)
+
+// IsValid returns true for the known statuses, an empty status is Available
+func (s BatchStatus) IsValid() bool {
+	switch s {
+	case Available, Quarantined, Damaged, Recalled, "":
+		return true
+	}
+	return false
+}
+
+// IsAvailable returns true if batches with the status can be allocated to
+func (s BatchStatus) IsAvailable() bool {
+	return s == Available || s == ""
+}
+
+// Hold reserves stock of a batch for an order line until it expires or is confirmed
+type Hold struct {
+	OrderLine
+	ExpiresAt time.Time
+}
+
+// HasExpired returns true once the hold can no longer be confirmed
+func (h Hold) HasExpired(now time.Time) bool {
+	return !now.Before(h.ExpiresAt)
+}
+
+// BatchAllocation is the quantity of an order line allocated to a single batch
+type BatchAllocation struct {
+	BatchRef Reference
+	Quantity int
 }
 
 // Allocate allocates an order line to a batch
@@ -45,17 +162,39 @@ func (b *Batch) Allocate(orderLine OrderLine) error {
 	return nil
 }
 
-// Deallocate removes an order line from a batch
+// addPart allocates a part of a split order line to the batch, adding it to the part of the line the batch already holds
+func (b *Batch) addPart(part OrderLine) error {
+	allocated, ok := b.AllocationOf(part.OrderID, part.Sku)
+	if !ok {
+		return b.Allocate(part)
+	}
+	if b.AvailableQuantity() < part.Quantity {
+		return fmt.Errorf("unable to allocate order to batch, not enough %s left", b.Sku)
+	}
+	b.Allocations.Remove(allocated)
+	part.Quantity += allocated.Quantity
+	b.Allocations.Add(part)
+	return nil
+}
+
+// Deallocate removes the order's line of the sku from a batch, whatever its quantity and priority
 func (b *Batch) Deallocate(orderLine OrderLine) {
-	b.Allocations.Remove(orderLine)
+	if allocated, ok := b.AllocationOf(orderLine.OrderID, orderLine.Sku); ok {
+		b.Allocations.Remove(allocated)
+	}
 }
 
-// CanAllocate returns true if an order can be allocated to the batch and the reason why not if false
+// CanAllocate returns true if an order can be allocated to the batch and the reason why not if false,
+// only a batch with an available Status can be allocated to
 func (b *Batch) CanAllocate(orderLine OrderLine) (bool, error) {
 	if b.Sku != orderLine.Sku {
 		return false, fmt.Errorf("order of %s cannot be allocated to a batch of %s", orderLine.Sku, b.Sku)
 	}
 
+	if !b.Status.IsAvailable() {
+		return false, BatchUnavailableError{Reference: b.Reference, Status: b.Status}
+	}
+
 	if b.AvailableQuantity() < orderLine.Quantity {
 		return false, fmt.Errorf("unable to allocate order to batch, not enough %s left", b.Sku)
 	}
@@ -68,33 +207,160 @@ func (b *Batch) CanAllocate(orderLine OrderLine) (bool, error) {
 
 }
 
-// AvailableQuantity returns the number of product left after accounting for orders
+// AvailableQuantity returns the number of product left after accounting for orders and holds
 func (b *Batch) AvailableQuantity() int {
+	return b.Quantity - b.AllocatedQuantity() - b.HeldQuantity()
+}
+
+// AllocatedQuantity returns the quantity that has been allocated to orders
+func (b *Batch) AllocatedQuantity() int {
 	var orders int
 	for order := range b.Allocations.Iter() {
 		orders += order.Quantity
 	}
-	return b.Quantity - orders
+	return orders
 }
 
-// AllocatedQuantity returns the quantity that has been allocated to orders
-func (b *Batch) AllocatedQuantity() int {
-	return b.Quantity - b.AvailableQuantity()
+// HeldQuantity returns the quantity reserved by holds that have not been released
+func (b *Batch) HeldQuantity() int {
+	if b.Holds == nil {
+		return 0
+	}
+	var held int
+	for hold := range b.Holds.Iter() {
+		if !b.heldAt.IsZero() && hold.HasExpired(b.heldAt) {
+			continue
+		}
+		held += hold.Quantity
+	}
+	return held
+}
+
+// Hold reserves stock of the batch for an order line until the hold expires
+func (b *Batch) Hold(orderLine OrderLine, expiresAt time.Time) error {
+	canAllocate, reason := b.CanAllocate(orderLine)
+	if !canAllocate {
+		return reason
+	}
+	if _, ok := b.HoldOf(orderLine.OrderID); ok {
+		return fmt.Errorf("order already held")
+	}
+	if b.Holds == nil {
+		b.Holds = mapset.NewSet[Hold]()
+	}
+	b.Holds.Add(Hold{OrderLine: orderLine, ExpiresAt: expiresAt})
+	return nil
+}
+
+// HoldOf returns the hold placed on the batch by the order
+func (b *Batch) HoldOf(orderID Reference) (Hold, bool) {
+	var found Hold
+	var ok bool
+	if b.Holds != nil {
+		b.Holds.Each(func(hold Hold) bool {
+			if hold.OrderID == orderID {
+				found, ok = hold, true
+			}
+			return ok
+		})
+	}
+	return found, ok
+}
+
+// Release removes a hold from the batch
+func (b *Batch) Release(hold Hold) {
+	if b.Holds != nil {
+		b.Holds.Remove(hold)
+	}
+}
+
+// HasExpired returns true once the best before date of perishable stock has been reached
+func (b *Batch) HasExpired(now time.Time) bool {
+	return !b.BestBefore.IsZero() && !now.Before(b.BestBefore)
+}
+
+// InWarehouse returns true if the batch is warehouse stock rather than a shipment
+func (b *Batch) InWarehouse() bool {
+	return b.ETA.IsZero()
+}
+
+// preemptableFor returns the allocations of a lower priority than the order line that need to be displaced for
+// the line to fit, the lowest priority and then the largest lines are displaced first.
+// It returns false when displacing every lower priority line would still not make room.
+func (b *Batch) preemptableFor(orderLine OrderLine) ([]OrderLine, bool) {
+	if b.Sku != orderLine.Sku || b.IsAllocated(orderLine) {
+		return nil, false
+	}
+
+	candidates := slices.DeleteFunc(b.Allocations.ToSlice(), func(allocated OrderLine) bool {
+		return !orderLine.Priority.Outranks(allocated.Priority)
+	})
+	slices.SortFunc(candidates, func(aLine, bLine OrderLine) int {
+		if aLine.Priority != bLine.Priority {
+			return aLine.Priority.level() - bLine.Priority.level()
+		}
+		if aLine.Quantity != bLine.Quantity {
+			return bLine.Quantity - aLine.Quantity
+		}
+		return strings.Compare(string(aLine.OrderID), string(bLine.OrderID))
+	})
+
+	available := b.AvailableQuantity()
+	for i, candidate := range candidates {
+		if available >= orderLine.Quantity {
+			return candidates[:i], true
+		}
+		available += candidate.Quantity
+	}
+	return candidates, available >= orderLine.Quantity
 }
 
-// IsAllocated checks if an order line has been allocated to the batch
+// IsAllocated checks if the order's line of the sku has been allocated to the batch
 func (b *Batch) IsAllocated(orderLine OrderLine) bool {
-	return b.Allocations.Contains(orderLine)
+	_, ok := b.AllocationOf(orderLine.OrderID, orderLine.Sku)
+	return ok
 }
 
-func Allocate(orderLine OrderLine, batches []Batch) (Reference, error) {
-	slices.SortFunc[[]Batch](batches, func(aBatch, bBatch Batch) int {
-		return aBatch.ETA.Compare(bBatch.ETA)
+// AllocationOf returns the line of the sku allocated to the batch for the order
+func (b *Batch) AllocationOf(orderID Reference, sku Sku) (OrderLine, bool) {
+	for _, allocated := range b.Allocations.ToSlice() {
+		if allocated.OrderID == orderID && allocated.Sku == sku {
+			return allocated, true
+		}
+	}
+	return OrderLine{}, false
+}
+
+// largestAllocation returns the allocated order line with the biggest quantity, ties are broken by order id
+func (b *Batch) largestAllocation() OrderLine {
+	return slices.MaxFunc(b.Allocations.ToSlice(), func(aLine, bLine OrderLine) int {
+		if aLine.Quantity != bLine.Quantity {
+			return aLine.Quantity - bLine.Quantity
+		}
+		return strings.Compare(string(bLine.OrderID), string(aLine.OrderID))
 	})
-	var batchCheckError error
+}
+
+// largestHold returns the hold with the biggest quantity, ties are broken by order id
+func (b *Batch) largestHold() Hold {
+	return slices.MaxFunc(b.Holds.ToSlice(), func(aHold, bHold Hold) int {
+		if aHold.Quantity != bHold.Quantity {
+			return aHold.Quantity - bHold.Quantity
+		}
+		return strings.Compare(string(bHold.OrderID), string(aHold.OrderID))
+	})
+}
+
+// Allocate allocates an order line to the batch that is arriving soonest
+func Allocate(orderLine OrderLine, batches []Batch) (Reference, error) {
+	return AllocateWithStrategy(orderLine, batches, ETAStrategy{})
+}
+
+// AllocateWithStrategy allocates an order line to the first batch that can take it in the order preferred by the strategy
+func AllocateWithStrategy(orderLine OrderLine, batches []Batch, strategy AllocationStrategy) (Reference, error) {
+	strategy.Rank(orderLine, batches)
 	for _, batch := range batches {
-		batchCheckError = batch.Allocate(orderLine)
-		if batchCheckError == nil {
+		if err := batch.Allocate(orderLine); err == nil {
 			return batch.Reference, nil
 		}
 	}
@@ -108,3 +374,13 @@ type OutOfStockError struct {
 func (o OutOfStockError) Error() string {
 	return fmt.Sprintf("%s is out of stock", o.sku)
 }
+
+// BatchUnavailableError is returned when an order line is allocated to a batch that has been taken out of allocation
+type BatchUnavailableError struct {
+	Reference Reference
+	Status    BatchStatus
+}
+
+func (b BatchUnavailableError) Error() string {
+	return fmt.Sprintf("batch %s cannot be allocated to, it is %s", b.Reference, b.Status)
+}
@@ -115,6 +115,28 @@ func TestBatch_CanAllocate(t *testing.T) {
 		assert.False(t, canAllocate)
 	})
 
+	t.Run("should not allocate to a batch that is not available", func(t *testing.T) {
+		orderLine := OrderLine{OrderID: "order-ref", Sku: "SMALL-TABLE", Quantity: 3}
+
+		for _, status := range []BatchStatus{Quarantined, Damaged, Recalled} {
+			batch := NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{})
+			batch.Status = status
+
+			canAllocate, err := batch.CanAllocate(orderLine)
+			assert.False(t, canAllocate)
+			assert.Equal(t, BatchUnavailableError{Reference: "batch-001", Status: status}, err)
+		}
+	})
+
+	t.Run("should allocate to an available batch", func(t *testing.T) {
+		orderLine := OrderLine{OrderID: "order-ref", Sku: "SMALL-TABLE", Quantity: 3}
+		batch := NewBatch("batch-001", "SMALL-TABLE", 10, time.Time{})
+		batch.Status = Available
+
+		canAllocate, err := batch.CanAllocate(orderLine)
+		assert.True(t, canAllocate)
+		assert.Nil(t, err)
+	})
 }
 
 func TestBatch_Deallocate(t *testing.T) {
@@ -167,6 +189,59 @@ func TestBatch_OrderAllocated(t *testing.T) {
 
 }
 
+func TestBatch_Hold(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 1, 12, 15, 0, 0, time.UTC)
+
+	t.Run("held stock is not available", func(t *testing.T) {
+		batch := NewBatch("batch-001", "SMALL-TABLE", 5, time.Time{})
+
+		err := batch.Hold(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 3}, expiresAt)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, batch.HeldQuantity())
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+		assert.Equal(t, 2, batch.AvailableQuantity())
+	})
+
+	t.Run("cannot hold more than is available", func(t *testing.T) {
+		batch := NewBatch("batch-001", "SMALL-TABLE", 5, time.Time{})
+
+		err := batch.Hold(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 6}, expiresAt)
+		assert.Error(t, err)
+	})
+
+	t.Run("cannot hold twice for the same order", func(t *testing.T) {
+		batch := NewBatch("batch-001", "SMALL-TABLE", 5, time.Time{})
+
+		assert.Nil(t, batch.Hold(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 1}, expiresAt))
+		assert.Error(t, batch.Hold(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 2}, expiresAt))
+	})
+
+	t.Run("releasing a hold makes the stock available again", func(t *testing.T) {
+		batch := NewBatch("batch-001", "SMALL-TABLE", 5, time.Time{})
+		assert.Nil(t, batch.Hold(OrderLine{OrderID: "order-001", Sku: "SMALL-TABLE", Quantity: 3}, expiresAt))
+
+		hold, ok := batch.HoldOf("order-001")
+		assert.True(t, ok)
+		batch.Release(hold)
+		assert.Equal(t, 5, batch.AvailableQuantity())
+	})
+}
+
+func TestHold_HasExpired(t *testing.T) {
+	hold := Hold{ExpiresAt: time.Date(2024, 1, 1, 12, 15, 0, 0, time.UTC)}
+
+	assert.False(t, hold.HasExpired(hold.ExpiresAt.Add(-time.Second)))
+	assert.True(t, hold.HasExpired(hold.ExpiresAt))
+}
+
+func TestPriority_Outranks(t *testing.T) {
+	assert.True(t, Express.Outranks(Standard))
+	assert.True(t, Standard.Outranks(Bulk))
+	assert.False(t, Priority("").Outranks(Standard))
+	assert.False(t, Bulk.Outranks(Priority("")))
+	assert.False(t, Priority("overnight").IsValid())
+}
+
 func TestAllocate(t *testing.T) {
 	t.Run("allocate prefers current stock batches to shipments", func(t *testing.T) {
 		inStockBatch := Batch{Reference: "in-stock-batch-001", Sku: "RETRO-CLOCK", Quantity: 100, Allocations: mapset.NewSet[OrderLine]()}
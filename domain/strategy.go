@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// AllocationStrategy decides which batches an order line should be allocated to first
+type AllocationStrategy interface {
+	// Rank sorts the batches from the most to the least preferred for the order line
+	Rank(orderLine OrderLine, batches []Batch)
+}
+
+// BatchFilter is implemented by strategies that only allow some of the batches to be allocated to
+type BatchFilter interface {
+	// Accepts returns true if order lines can be allocated to the batch at the given time
+	Accepts(batch Batch, now time.Time) bool
+}
+
+// ETAStrategy prefers warehouse stock, then the shipments arriving soonest
+type ETAStrategy struct{}
+
+func (ETAStrategy) Rank(orderLine OrderLine, batches []Batch) {
+	slices.SortStableFunc[[]Batch](batches, compareETA)
+}
+
+// LargestRemainingStrategy prefers the batches with the most stock left, so that small remainders are not left scattered
+type LargestRemainingStrategy struct{}
+
+func (LargestRemainingStrategy) Rank(orderLine OrderLine, batches []Batch) {
+	slices.SortStableFunc[[]Batch](batches, func(aBatch, bBatch Batch) int {
+		if aBatch.AvailableQuantity() != bBatch.AvailableQuantity() {
+			return bBatch.AvailableQuantity() - aBatch.AvailableQuantity()
+		}
+		return compareETA(aBatch, bBatch)
+	})
+}
+
+// BestFitStrategy prefers the batches with the least stock left, so that the order line leaves the smallest remainder
+type BestFitStrategy struct{}
+
+func (BestFitStrategy) Rank(orderLine OrderLine, batches []Batch) {
+	slices.SortStableFunc[[]Batch](batches, func(aBatch, bBatch Batch) int {
+		if aBatch.AvailableQuantity() != bBatch.AvailableQuantity() {
+			return aBatch.AvailableQuantity() - bBatch.AvailableQuantity()
+		}
+		return compareETA(aBatch, bBatch)
+	})
+}
+
+// FewestBatchesStrategy prefers batches that have already been allocated to, so that untouched batches are kept whole
+type FewestBatchesStrategy struct{}
+
+func (FewestBatchesStrategy) Rank(orderLine OrderLine, batches []Batch) {
+	slices.SortStableFunc[[]Batch](batches, func(aBatch, bBatch Batch) int {
+		aTouched, bTouched := aBatch.AllocatedQuantity() > 0, bBatch.AllocatedQuantity() > 0
+		if aTouched != bTouched {
+			if aTouched {
+				return -1
+			}
+			return 1
+		}
+		return compareETA(aBatch, bBatch)
+	})
+}
+
+// FEFOStrategy prefers the stock that expires first, so that perishable stock is not left to go off.
+// Batches that would expire within MinShelfLife are not allocated to, stock that does not expire is used last.
+type FEFOStrategy struct {
+	MinShelfLife time.Duration
+}
+
+func (FEFOStrategy) Rank(orderLine OrderLine, batches []Batch) {
+	slices.SortStableFunc[[]Batch](batches, func(aBatch, bBatch Batch) int {
+		if aBatch.BestBefore.IsZero() != bBatch.BestBefore.IsZero() {
+			if aBatch.BestBefore.IsZero() {
+				return 1
+			}
+			return -1
+		}
+		if !aBatch.BestBefore.Equal(bBatch.BestBefore) {
+			return aBatch.BestBefore.Compare(bBatch.BestBefore)
+		}
+		return compareETA(aBatch, bBatch)
+	})
+}
+
+func (f FEFOStrategy) Accepts(batch Batch, now time.Time) bool {
+	return batch.BestBefore.IsZero() || !batch.BestBefore.Before(now.Add(f.MinShelfLife))
+}
+
+func compareETA(aBatch, bBatch Batch) int {
+	return aBatch.ETA.Compare(bBatch.ETA)
+}
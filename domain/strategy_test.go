@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateWithStrategy(t *testing.T) {
+	orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15}
+
+	t.Run("eta strategy prefers the soonest batch", func(t *testing.T) {
+		batches := []Batch{
+			NewBatch("shipment-batch", "RETRO-CLOCK", 200, time.Time{}.AddDate(0, 1, 0)),
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+		}
+
+		batchRef, err := AllocateWithStrategy(orderLine, batches, ETAStrategy{})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("in-stock-batch"), batchRef)
+	})
+
+	t.Run("largest remaining strategy prefers the fullest batch", func(t *testing.T) {
+		batches := []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("large-batch", "RETRO-CLOCK", 200, time.Time{}.AddDate(0, 1, 0)),
+		}
+
+		batchRef, err := AllocateWithStrategy(orderLine, batches, LargestRemainingStrategy{})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("large-batch"), batchRef)
+	})
+
+	t.Run("best fit strategy prefers the smallest batch that fits", func(t *testing.T) {
+		batches := []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			NewBatch("small-batch", "RETRO-CLOCK", 20, time.Time{}.AddDate(0, 3, 0)),
+		}
+
+		batchRef, err := AllocateWithStrategy(orderLine, batches, BestFitStrategy{})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("small-batch"), batchRef)
+	})
+
+	t.Run("fewest batches strategy prefers a batch already allocated to", func(t *testing.T) {
+		touched := NewBatch("touched-batch", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 2, 0))
+		touched.Allocate(OrderLine{OrderID: "order-000", Sku: "RETRO-CLOCK", Quantity: 30})
+		batches := []Batch{
+			NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			touched,
+		}
+
+		batchRef, err := AllocateWithStrategy(orderLine, batches, FewestBatchesStrategy{})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("touched-batch"), batchRef)
+	})
+
+	t.Run("best fit strategy skips batches that are too small", func(t *testing.T) {
+		batches := []Batch{
+			NewBatch("big-batch", "RETRO-CLOCK", 100, time.Time{}),
+			NewBatch("tiny-batch", "RETRO-CLOCK", 5, time.Time{}),
+			NewBatch("fitting-batch", "RETRO-CLOCK", 20, time.Time{}),
+		}
+
+		batchRef, err := AllocateWithStrategy(orderLine, batches, BestFitStrategy{})
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("fitting-batch"), batchRef)
+	})
+
+	t.Run("returns out of stock error if no batch can take the order line", func(t *testing.T) {
+		batches := []Batch{NewBatch("tiny-batch", "RETRO-CLOCK", 5, time.Time{})}
+
+		_, err := AllocateWithStrategy(orderLine, batches, LargestRemainingStrategy{})
+		assert.ErrorIs(t, err, OutOfStockError{"RETRO-CLOCK"})
+	})
+}
+
+func TestProduct_AllocateWithStrategy(t *testing.T) {
+	product := NewProduct("RETRO-CLOCK", []Batch{
+		NewBatch("large-batch", "RETRO-CLOCK", 200, time.Time{}.AddDate(0, 1, 0)),
+		NewBatch("small-batch", "RETRO-CLOCK", 20, time.Time{}.AddDate(0, 3, 0)),
+		NewBatch("in-stock-batch", "RETRO-CLOCK", 50, time.Time{}),
+	})
+	product.Strategy = BestFitStrategy{}
+
+	batchRef, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15})
+	assert.Nil(t, err)
+	assert.Equal(t, Reference("small-batch"), batchRef)
+}
+
+func TestFEFOStrategy(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	orderLine := OrderLine{OrderID: "order-001", Sku: "FRESH-MILK", Quantity: 5}
+
+	t.Run("prefers the stock that expires first", func(t *testing.T) {
+		product := NewProduct("FRESH-MILK", []Batch{
+			NewBatch("long-life-batch", "FRESH-MILK", 20, time.Time{}),
+			NewPerishableBatch("late-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 10)),
+			NewPerishableBatch("soon-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2)),
+			NewPerishableBatch("expired-batch", "FRESH-MILK", 20, time.Time{}, now),
+		})
+		product.Strategy = FEFOStrategy{}
+		product.Clock = clock
+
+		batchRef, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("soon-batch"), batchRef)
+	})
+
+	t.Run("skips stock that would not last the minimum shelf life", func(t *testing.T) {
+		product := NewProduct("FRESH-MILK", []Batch{
+			NewBatch("long-life-batch", "FRESH-MILK", 20, time.Time{}),
+			NewPerishableBatch("late-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 10)),
+			NewPerishableBatch("soon-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2)),
+			NewPerishableBatch("expired-batch", "FRESH-MILK", 20, time.Time{}, now),
+		})
+		product.Strategy = FEFOStrategy{MinShelfLife: 3 * 24 * time.Hour}
+		product.Clock = clock
+
+		batchRef, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("late-batch"), batchRef)
+	})
+
+	t.Run("uses stock that does not expire last", func(t *testing.T) {
+		product := NewProduct("FRESH-MILK", []Batch{
+			NewBatch("long-life-batch", "FRESH-MILK", 20, time.Time{}),
+			NewPerishableBatch("late-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 10)),
+			NewPerishableBatch("soon-batch", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2)),
+			NewPerishableBatch("expired-batch", "FRESH-MILK", 20, time.Time{}, now),
+		})
+		product.Strategy = FEFOStrategy{MinShelfLife: 30 * 24 * time.Hour}
+		product.Clock = clock
+
+		batchRef, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("long-life-batch"), batchRef)
+	})
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Substitutions holds the skus that may replace an out of stock sku and the customers that accept them
+type Substitutions struct {
+	// Substitutes are the skus that may replace each sku, in order of preference
+	Substitutes map[Sku][]Sku
+	// OptIns are the skus each customer accepts substitutes for, a customer without skus accepts them for every sku
+	OptIns map[Reference][]Sku
+}
+
+// For returns the substitutes of the sku in order of preference, or none if the customer has not opted in to them
+func (s Substitutions) For(customer Reference, sku Sku) []Sku {
+	skus, ok := s.OptIns[customer]
+	if customer == "" || !ok {
+		return nil
+	}
+	if len(skus) > 0 && !slices.Contains(skus, sku) {
+		return nil
+	}
+	return s.Substitutes[sku]
+}
+
+// AllocationResult is the batch an order line was allocated to and the sku allocated,
+// which is a substitute when it is not the OrderedSku, with the Promise of when the line can be dispatched
+type AllocationResult struct {
+	BatchRef   Reference
+	Sku        Sku
+	OrderedSku Sku
+	Promise    Promise
+}
+
+// Substituted returns true if a substitute was allocated in place of the sku ordered
+func (a AllocationResult) Substituted() bool {
+	return a.Sku != a.OrderedSku
+}
+
+// AllocateSubstitute allocates the customer's order line of another sku as this product instead, like Allocate.
+// The line keeps its quantity and unit, the substitution is recorded with an OrderLineSubstituted event.
+func (p *Product) AllocateSubstitute(orderLine OrderLine, customer Reference) (Reference, error) {
+	if orderLine.Sku == p.Sku {
+		return "", fmt.Errorf("order of %s cannot be substituted by itself", p.Sku)
+	}
+	substitute := orderLine
+	substitute.Sku = p.Sku
+	substitute, err := p.inBaseUnit(substitute)
+	if err != nil {
+		return "", err
+	}
+
+	batchRef, err := p.Allocate(substitute)
+	if err != nil {
+		return "", err
+	}
+	p.Events = append(p.Events, OrderLineSubstituted{
+		OrderID:       orderLine.OrderID,
+		CustomerID:    customer,
+		Sku:           orderLine.Sku,
+		SubstituteSku: p.Sku,
+		Quantity:      substitute.Quantity,
+		BatchRef:      batchRef,
+	})
+	return batchRef, nil
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubstitutions_For(t *testing.T) {
+	substitutions := Substitutions{
+		Substitutes: map[Sku][]Sku{"RETRO-CLOCK": {"MODERN-CLOCK", "CUCKOO-CLOCK"}, "BLUE-LAMP": {"RED-LAMP"}},
+		OptIns:      map[Reference][]Sku{"customer-001": nil, "customer-002": {"BLUE-LAMP"}},
+	}
+
+	testCases := []struct {
+		name     string
+		customer Reference
+		sku      Sku
+		expected []Sku
+	}{
+		{name: "returns every substitute in order for a customer opted in to all skus", customer: "customer-001", sku: "RETRO-CLOCK", expected: []Sku{"MODERN-CLOCK", "CUCKOO-CLOCK"}},
+		{name: "returns the substitutes of a sku the customer opted in to", customer: "customer-002", sku: "BLUE-LAMP", expected: []Sku{"RED-LAMP"}},
+		{name: "returns none for a sku the customer did not opt in to", customer: "customer-002", sku: "RETRO-CLOCK"},
+		{name: "returns none for a customer that did not opt in", customer: "customer-003", sku: "RETRO-CLOCK"},
+		{name: "returns none without a customer", sku: "RETRO-CLOCK"},
+		{name: "returns none for a sku without substitutes", customer: "customer-001", sku: "GREEN-SOFA"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, substitutions.For(testCase.customer, testCase.sku))
+		})
+	}
+}
+
+func TestProduct_AllocateSubstitute(t *testing.T) {
+	orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 10}
+
+	t.Run("allocates the line as the substitute and records the substitution", func(t *testing.T) {
+		product := NewProduct("MODERN-CLOCK", []Batch{NewBatch("batch-001", "MODERN-CLOCK", 20, time.Time{})})
+
+		batchRef, err := product.AllocateSubstitute(orderLine, "customer-001")
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("batch-001"), batchRef)
+
+		batch, _ := product.Batch("batch-001")
+		assert.True(t, batch.IsAllocated(OrderLine{OrderID: "order-001", Sku: "MODERN-CLOCK", Quantity: 10}))
+		assert.Contains(t, product.Events, OrderLineSubstituted{
+			OrderID:       "order-001",
+			CustomerID:    "customer-001",
+			Sku:           "RETRO-CLOCK",
+			SubstituteSku: "MODERN-CLOCK",
+			Quantity:      10,
+			BatchRef:      "batch-001",
+		})
+	})
+
+	t.Run("returns error when the substitute is out of stock too", func(t *testing.T) {
+		product := NewProduct("MODERN-CLOCK", []Batch{NewBatch("batch-001", "MODERN-CLOCK", 5, time.Time{})})
+
+		_, err := product.AllocateSubstitute(orderLine, "customer-001")
+		assert.ErrorAs(t, err, &OutOfStockError{})
+		assert.Equal(t, []Event{OutOfStock{Sku: "MODERN-CLOCK"}}, product.Events)
+	})
+
+	t.Run("returns error for a line of the product itself", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", []Batch{NewBatch("batch-001", "RETRO-CLOCK", 20, time.Time{})})
+
+		_, err := product.AllocateSubstitute(orderLine, "customer-001")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,85 @@
+package domain
+
+import "fmt"
+
+// Unit is what a quantity of a sku is counted in, an empty unit is the base unit of the sku
+type Unit string
+
+// UnitsOfMeasure defines the units a sku can be counted in.
+// Factors holds the number of base units in each other unit, e.g. a case of 12 eaches.
+type UnitsOfMeasure struct {
+	Sku     Sku
+	Base    Unit
+	Factors map[Unit]int
+}
+
+// Rounding decides what happens to the base units left over when converting to a larger unit
+type Rounding int
+
+const (
+	// RoundExact refuses conversions that leave base units over
+	RoundExact Rounding = iota
+	// RoundDown drops the base units left over, e.g. the whole cases that can be made up
+	RoundDown
+	// RoundUp counts the base units left over as one more unit, e.g. the cases needed to cover an order
+	RoundUp
+)
+
+// ToBase converts a quantity in the unit to the base unit
+func (u UnitsOfMeasure) ToBase(quantity int, unit Unit) (int, error) {
+	factor, ok := u.factor(unit)
+	if !ok {
+		return 0, UnitConversionError{Sku: u.Sku, Quantity: quantity, From: unit, To: u.Base}
+	}
+	return quantity * factor, nil
+}
+
+// FromBase converts a quantity in the base unit to the unit, rounding any base units left over
+func (u UnitsOfMeasure) FromBase(quantity int, unit Unit, rounding Rounding) (int, error) {
+	factor, ok := u.factor(unit)
+	if !ok {
+		return 0, UnitConversionError{Sku: u.Sku, Quantity: quantity, From: u.Base, To: unit}
+	}
+	converted, remainder := quantity/factor, quantity%factor
+	switch {
+	case remainder == 0:
+		return converted, nil
+	case rounding == RoundDown:
+		return converted, nil
+	case rounding == RoundUp:
+		return converted + 1, nil
+	}
+	return 0, UnitConversionError{Sku: u.Sku, Quantity: quantity, From: u.Base, To: unit, Remainder: remainder}
+}
+
+func (u UnitsOfMeasure) factor(unit Unit) (int, bool) {
+	if unit == "" || unit == u.Base {
+		return 1, true
+	}
+	factor, ok := u.Factors[unit]
+	return factor, ok && factor > 0
+}
+
+// UnitConversionError is returned when a quantity cannot be converted between two units of a sku, either because
+// the sku is not counted in one of them or because the conversion would leave a Remainder of base units
+type UnitConversionError struct {
+	Sku       Sku
+	Quantity  int
+	From      Unit
+	To        Unit
+	Remainder int
+}
+
+func (u UnitConversionError) Error() string {
+	if u.Remainder != 0 {
+		return fmt.Sprintf("cannot convert %d %s of %s to whole %s, %d would be left over", u.Quantity, unitName(u.From), u.Sku, unitName(u.To), u.Remainder)
+	}
+	return fmt.Sprintf("cannot convert %d %s of %s to %s", u.Quantity, unitName(u.From), u.Sku, unitName(u.To))
+}
+
+func unitName(unit Unit) string {
+	if unit == "" {
+		return "base units"
+	}
+	return string(unit)
+}
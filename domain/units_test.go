@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var clockUnits = UnitsOfMeasure{Sku: "RETRO-CLOCK", Base: "each", Factors: map[Unit]int{"case": 12, "pallet": 480}}
+
+func TestUnitsOfMeasure_ToBase(t *testing.T) {
+	testCases := []struct {
+		name     string
+		quantity int
+		unit     Unit
+		expected int
+	}{
+		{name: "converts cases to eaches", quantity: 2, unit: "case", expected: 24},
+		{name: "converts pallets to eaches", quantity: 1, unit: "pallet", expected: 480},
+		{name: "leaves the base unit as it is", quantity: 5, unit: "each", expected: 5},
+		{name: "counts no unit as the base unit", quantity: 5, unit: "", expected: 5},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			quantity, err := clockUnits.ToBase(testCase.quantity, testCase.unit)
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.expected, quantity)
+		})
+	}
+
+	t.Run("returns error for a unit the sku is not counted in", func(t *testing.T) {
+		_, err := clockUnits.ToBase(3, "kg")
+		assert.Equal(t, UnitConversionError{Sku: "RETRO-CLOCK", Quantity: 3, From: "kg", To: "each"}, err)
+		assert.EqualError(t, err, "cannot convert 3 kg of RETRO-CLOCK to each")
+	})
+}
+
+func TestUnitsOfMeasure_FromBase(t *testing.T) {
+	testCases := []struct {
+		name     string
+		quantity int
+		rounding Rounding
+		expected int
+	}{
+		{name: "converts whole cases exactly", quantity: 24, rounding: RoundExact, expected: 2},
+		{name: "rounds down to the whole cases", quantity: 30, rounding: RoundDown, expected: 2},
+		{name: "rounds up to the cases needed", quantity: 30, rounding: RoundUp, expected: 3},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			quantity, err := clockUnits.FromBase(testCase.quantity, "case", testCase.rounding)
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.expected, quantity)
+		})
+	}
+
+	t.Run("returns error when an exact conversion leaves eaches over", func(t *testing.T) {
+		_, err := clockUnits.FromBase(30, "case", RoundExact)
+		assert.Equal(t, UnitConversionError{Sku: "RETRO-CLOCK", Quantity: 30, From: "each", To: "case", Remainder: 6}, err)
+		assert.EqualError(t, err, "cannot convert 30 each of RETRO-CLOCK to whole case, 6 would be left over")
+	})
+
+	t.Run("returns error for a unit the sku is not counted in", func(t *testing.T) {
+		_, err := clockUnits.FromBase(30, "kg", RoundDown)
+		assert.ErrorAs(t, err, &UnitConversionError{})
+	})
+}
+
+func TestProduct_Units(t *testing.T) {
+	t.Run("keeps batches delivered in cases in eaches", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		product.Units = clockUnits
+		assert.Nil(t, product.AddBatch(Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+
+		batch, _ := product.Batch("batch-001")
+		assert.Equal(t, 24, batch.Quantity)
+		assert.Equal(t, Unit("each"), batch.Unit)
+		assert.Contains(t, product.Events, BatchCreated{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 24})
+	})
+
+	t.Run("allocates lines ordered in eaches and cases from the same stock", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		product.Units = clockUnits
+		assert.Nil(t, product.AddBatch(Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5, Unit: "each"})
+		assert.Nil(t, err)
+		_, err = product.Allocate(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case"})
+		assert.Nil(t, err)
+
+		batch, _ := product.Batch("batch-001")
+		assert.Equal(t, 7, batch.AvailableQuantity())
+		assert.True(t, batch.IsAllocated(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 12, Unit: "each"}))
+
+		_, err = product.Allocate(OrderLine{OrderID: "order-003", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case"})
+		assert.ErrorAs(t, err, &OutOfStockError{})
+	})
+
+	t.Run("deallocates a line given in the unit it was ordered in", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		product.Units = clockUnits
+		assert.Nil(t, product.AddBatch(Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+		orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case"}
+		_, err := product.Allocate(orderLine)
+		assert.Nil(t, err)
+
+		assert.Nil(t, product.Deallocate("batch-001", orderLine))
+
+		batch, _ := product.Batch("batch-001")
+		assert.Equal(t, 24, batch.AvailableQuantity())
+	})
+
+	t.Run("reports the stock available in any unit", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		product.Units = clockUnits
+		assert.Nil(t, product.AddBatch(Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5})
+		assert.Nil(t, err)
+
+		eaches, err := product.AvailableQuantity("each", RoundExact)
+		assert.Nil(t, err)
+		assert.Equal(t, 19, eaches)
+
+		cases, err := product.AvailableQuantity("case", RoundDown)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, cases)
+
+		_, err = product.AvailableQuantity("case", RoundExact)
+		assert.ErrorAs(t, err, &UnitConversionError{})
+	})
+
+	t.Run("returns error for lines and batches in a unit the sku is not counted in", func(t *testing.T) {
+		product := NewProduct("RETRO-CLOCK", nil)
+		product.Units = clockUnits
+		assert.Nil(t, product.AddBatch(Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "kg"})
+		assert.ErrorAs(t, err, &UnitConversionError{})
+
+		err = product.AddBatch(Batch{Reference: "batch-002", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "kg", ETA: time.Time{}})
+		assert.ErrorAs(t, err, &UnitConversionError{})
+		assert.Len(t, product.Batches, 1)
+	})
+
+	t.Run("only counts a product without units in its base unit", func(t *testing.T) {
+		product := NewProduct("BLUE-LAMP", []Batch{NewBatch("batch-001", "BLUE-LAMP", 10, time.Time{})})
+
+		_, err := product.Allocate(OrderLine{OrderID: "order-001", Sku: "BLUE-LAMP", Quantity: 5})
+		assert.Nil(t, err)
+
+		_, err = product.Allocate(OrderLine{OrderID: "order-002", Sku: "BLUE-LAMP", Quantity: 1, Unit: "case"})
+		assert.EqualError(t, err, "cannot convert 1 case of BLUE-LAMP to base units")
+	})
+}
@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Warehouse is the site a batch of stock is kept at, batches without a warehouse are kept at an unnamed site
+type Warehouse string
+
+// Region is the area an order is delivered to
+type Region string
+
+// WarehousePreferences lists the warehouses that deliver to each region, nearest first
+type WarehousePreferences map[Region][]Warehouse
+
+// For returns the warehouses that deliver to the region, nearest first
+func (w WarehousePreferences) For(region Region) []Warehouse {
+	return w[region]
+}
+
+// NearestWarehouseStrategy prefers the batches kept at the warehouses nearest the delivery region, in the order given.
+// Batches at the same warehouse are ranked by the Then strategy, or by ETA if it is nil, and batches at other
+// warehouses are used last.
+type NearestWarehouseStrategy struct {
+	Warehouses []Warehouse
+	Then       AllocationStrategy
+}
+
+func (n NearestWarehouseStrategy) Rank(orderLine OrderLine, batches []Batch) {
+	n.then().Rank(orderLine, batches)
+	slices.SortStableFunc[[]Batch](batches, func(aBatch, bBatch Batch) int {
+		return n.distance(aBatch.Warehouse) - n.distance(bBatch.Warehouse)
+	})
+}
+
+// Accepts leaves the batches to the Then strategy if it filters them
+func (n NearestWarehouseStrategy) Accepts(batch Batch, now time.Time) bool {
+	if filter, ok := n.then().(BatchFilter); ok {
+		return filter.Accepts(batch, now)
+	}
+	return true
+}
+
+func (n NearestWarehouseStrategy) then() AllocationStrategy {
+	if n.Then == nil {
+		return ETAStrategy{}
+	}
+	return n.Then
+}
+
+func (n NearestWarehouseStrategy) distance(warehouse Warehouse) int {
+	if i := slices.Index(n.Warehouses, warehouse); i >= 0 {
+		return i
+	}
+	return len(n.Warehouses)
+}
+
+// WarehouseStock is the stock of a sku kept at a single warehouse
+type WarehouseStock struct {
+	Warehouse Warehouse
+	Sku       Sku
+	Quantity  int
+	Allocated int
+	Held      int
+	Available int
+}
+
+// StockByWarehouse totals the stock of the batches for each warehouse and sku, sorted by warehouse and then sku
+func StockByWarehouse(batches []Batch) []WarehouseStock {
+	var report []WarehouseStock
+	for _, batch := range batches {
+		i := slices.IndexFunc(report, func(stock WarehouseStock) bool {
+			return stock.Warehouse == batch.Warehouse && stock.Sku == batch.Sku
+		})
+		if i < 0 {
+			report = append(report, WarehouseStock{Warehouse: batch.Warehouse, Sku: batch.Sku})
+			i = len(report) - 1
+		}
+		report[i].Quantity += batch.Quantity
+		report[i].Allocated += batch.AllocatedQuantity()
+		report[i].Held += batch.HeldQuantity()
+		report[i].Available += batch.AvailableQuantity()
+	}
+
+	slices.SortFunc(report, func(aStock, bStock WarehouseStock) int {
+		if aStock.Warehouse != bStock.Warehouse {
+			return strings.Compare(string(aStock.Warehouse), string(bStock.Warehouse))
+		}
+		return strings.Compare(string(aStock.Sku), string(bStock.Sku))
+	})
+	return report
+}
@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearestWarehouseStrategy(t *testing.T) {
+	orderLine := OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 15}
+	preferences := WarehousePreferences{
+		"north": {"leeds", "london"},
+		"south": {"london", "leeds"},
+	}
+
+	t.Run("prefers the warehouse nearest the region", func(t *testing.T) {
+		batches := []Batch{
+			NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 20, time.Time{}, "leeds"),
+			NewWarehouseBatch("london-batch", "RETRO-CLOCK", 20, time.Time{}, "london"),
+			NewWarehouseBatch("london-shipment", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 1, 0), "london"),
+			NewWarehouseBatch("dublin-batch", "RETRO-CLOCK", 100, time.Time{}, "dublin"),
+		}
+
+		strategy := NearestWarehouseStrategy{Warehouses: preferences.For("north")}
+		batchRef, err := AllocateWithStrategy(orderLine, batches, strategy)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("leeds-batch"), batchRef)
+
+		strategy = NearestWarehouseStrategy{Warehouses: preferences.For("south")}
+		batchRef, err = AllocateWithStrategy(orderLine, batches, strategy)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("london-batch"), batchRef)
+	})
+
+	t.Run("ranks batches in the same warehouse with the then strategy", func(t *testing.T) {
+		batches := []Batch{
+			NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 20, time.Time{}, "leeds"),
+			NewWarehouseBatch("london-batch", "RETRO-CLOCK", 20, time.Time{}, "london"),
+			NewWarehouseBatch("london-shipment", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 1, 0), "london"),
+			NewWarehouseBatch("dublin-batch", "RETRO-CLOCK", 100, time.Time{}, "dublin"),
+		}
+
+		strategy := NearestWarehouseStrategy{Warehouses: preferences.For("south"), Then: LargestRemainingStrategy{}}
+		batchRef, err := AllocateWithStrategy(orderLine, batches, strategy)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("london-shipment"), batchRef)
+	})
+
+	t.Run("falls back to the next warehouse and then to any other", func(t *testing.T) {
+		batches := []Batch{
+			NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 20, time.Time{}, "leeds"),
+			NewWarehouseBatch("london-batch", "RETRO-CLOCK", 20, time.Time{}, "london"),
+			NewWarehouseBatch("london-shipment", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 1, 0), "london"),
+			NewWarehouseBatch("dublin-batch", "RETRO-CLOCK", 100, time.Time{}, "dublin"),
+		}
+
+		strategy := NearestWarehouseStrategy{Warehouses: preferences.For("north")}
+		strategy.Rank(orderLine, batches)
+
+		var refs []Reference
+		for _, batch := range batches {
+			refs = append(refs, batch.Reference)
+		}
+		assert.Equal(t, []Reference{"leeds-batch", "london-batch", "london-shipment", "dublin-batch"}, refs)
+	})
+
+	t.Run("leaves an unknown region to the then strategy", func(t *testing.T) {
+		batches := []Batch{
+			NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 20, time.Time{}, "leeds"),
+			NewWarehouseBatch("london-batch", "RETRO-CLOCK", 20, time.Time{}, "london"),
+			NewWarehouseBatch("london-shipment", "RETRO-CLOCK", 100, time.Time{}.AddDate(0, 1, 0), "london"),
+			NewWarehouseBatch("dublin-batch", "RETRO-CLOCK", 100, time.Time{}, "dublin"),
+		}
+
+		strategy := NearestWarehouseStrategy{Warehouses: preferences.For("west"), Then: LargestRemainingStrategy{}}
+		batchRef, err := AllocateWithStrategy(orderLine, batches, strategy)
+		assert.Nil(t, err)
+		assert.Equal(t, Reference("dublin-batch"), batchRef)
+	})
+
+	t.Run("filters batches with the then strategy", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		strategy := NearestWarehouseStrategy{Warehouses: preferences.For("north"), Then: FEFOStrategy{MinShelfLife: 24 * time.Hour}}
+
+		assert.False(t, strategy.Accepts(NewPerishableBatch("batch-001", "FRESH-MILK", 10, time.Time{}, now.Add(time.Hour)), now))
+		assert.True(t, strategy.Accepts(NewBatch("batch-002", "FRESH-MILK", 10, time.Time{}), now))
+	})
+}
+
+func TestStockByWarehouse(t *testing.T) {
+	leedsBatch := NewWarehouseBatch("leeds-batch", "RETRO-CLOCK", 20, time.Time{}, "leeds")
+	leedsBatch.Allocate(OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5})
+	leedsBatch.Hold(OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 3}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	report := StockByWarehouse([]Batch{
+		NewWarehouseBatch("london-batch", "RETRO-CLOCK", 10, time.Time{}, "london"),
+		leedsBatch,
+		NewWarehouseBatch("leeds-shipment", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0), "leeds"),
+		NewWarehouseBatch("leeds-lamps", "BLUE-LAMP", 4, time.Time{}, "leeds"),
+	})
+
+	assert.Equal(t, []WarehouseStock{
+		{Warehouse: "leeds", Sku: "BLUE-LAMP", Quantity: 4, Available: 4},
+		{Warehouse: "leeds", Sku: "RETRO-CLOCK", Quantity: 50, Allocated: 5, Held: 3, Available: 42},
+		{Warehouse: "london", Sku: "RETRO-CLOCK", Quantity: 10, Available: 10},
+	}, report)
+}
@@ -0,0 +1,133 @@
+package messagebus
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+)
+
+type CommandHandler func(commands.Command) (any, error)
+type EventHandler func(domain.Event) error
+
+// EventCollector is implemented by units of work to hand over the events recorded while handling a message
+type EventCollector interface {
+	CollectNewEvents() []domain.Event
+}
+
+// MessageBus routes each command to its one handler and each event to all of its handlers.
+// Messages are handled one at a time.
+type MessageBus struct {
+	collector         EventCollector
+	commandHandlers   map[reflect.Type]CommandHandler
+	eventHandlers     map[reflect.Type][]EventHandler
+	eventErrorHandler func(EventHandlerError)
+	mu                sync.Mutex
+}
+
+func New(collector EventCollector, options ...func(*MessageBus)) *MessageBus {
+	bus := &MessageBus{
+		collector:       collector,
+		commandHandlers: make(map[reflect.Type]CommandHandler),
+		eventHandlers:   make(map[reflect.Type][]EventHandler),
+		eventErrorHandler: func(err EventHandlerError) {
+			log.Print(err)
+		},
+	}
+	for _, o := range options {
+		o(bus)
+	}
+	return bus
+}
+
+// WithEventErrorHandler replaces the default logging of event handler failures
+func WithEventErrorHandler(handler func(EventHandlerError)) func(*MessageBus) {
+	return func(m *MessageBus) {
+		m.eventErrorHandler = handler
+	}
+}
+
+// RegisterCommand sets the handler of commands of type C, it panics if C already has a handler
+func RegisterCommand[C commands.Command](bus *MessageBus, handler func(C) (any, error)) {
+	commandType := reflect.TypeOf((*C)(nil)).Elem()
+	if _, ok := bus.commandHandlers[commandType]; ok {
+		panic(fmt.Sprintf("messagebus: command %s already has a handler", commandType))
+	}
+	bus.commandHandlers[commandType] = func(command commands.Command) (any, error) {
+		return handler(command.(C))
+	}
+}
+
+// RegisterEvent adds a handler for events of type E
+func RegisterEvent[E domain.Event](bus *MessageBus, handler func(E) error) {
+	eventType := reflect.TypeOf((*E)(nil)).Elem()
+	bus.eventHandlers[eventType] = append(bus.eventHandlers[eventType], func(event domain.Event) error {
+		return handler(event.(E))
+	})
+}
+
+// Handle runs the handler of the command and then every handler of the events raised along the way,
+// including events raised by the event handlers themselves.
+// Only a failure of the command handler is returned, event handler failures go to the event error handler.
+func (m *MessageBus) Handle(command commands.Command) (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	handler, ok := m.commandHandlers[reflect.TypeOf(command)]
+	if !ok {
+		return nil, fmt.Errorf("no handler registered for command %T", command)
+	}
+
+	result, err := handler(command)
+	m.handleEvents(m.collector.CollectNewEvents())
+
+	if err != nil {
+		return nil, CommandHandlerError{Command: command, Err: err}
+	}
+	return result, nil
+}
+
+func (m *MessageBus) handleEvents(queue []domain.Event) {
+	for len(queue) > 0 {
+		event := queue[0]
+		queue = queue[1:]
+
+		for _, handler := range m.eventHandlers[reflect.TypeOf(event)] {
+			if err := handler(event); err != nil {
+				m.eventErrorHandler(EventHandlerError{Event: event, Err: err})
+			}
+			queue = append(queue, m.collector.CollectNewEvents()...)
+		}
+	}
+}
+
+// CommandHandlerError is returned when the handler of a command fails
+type CommandHandlerError struct {
+	Command commands.Command
+	Err     error
+}
+
+func (c CommandHandlerError) Error() string {
+	return fmt.Sprintf("could not handle command %T: %s", c.Command, c.Err)
+}
+
+func (c CommandHandlerError) Unwrap() error {
+	return c.Err
+}
+
+// EventHandlerError describes the failure of one of the handlers of an event
+type EventHandlerError struct {
+	Event domain.Event
+	Err   error
+}
+
+func (e EventHandlerError) Error() string {
+	return fmt.Sprintf("could not handle event %T: %s", e.Event, e.Err)
+}
+
+func (e EventHandlerError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,137 @@
+package messagebus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCollector hands over the events that handlers record on it
+type fakeCollector struct {
+	events []domain.Event
+}
+
+func (f *fakeCollector) CollectNewEvents() []domain.Event {
+	events := f.events
+	f.events = nil
+	return events
+}
+
+func TestMessageBus_Handle(t *testing.T) {
+	t.Run("returns the result of the command handler", func(t *testing.T) {
+		bus := New(&fakeCollector{})
+		RegisterCommand(bus, func(c commands.Allocate) (any, error) {
+			return domain.Reference("batch-001"), nil
+		})
+
+		result, err := bus.Handle(commands.Allocate{OrderID: "order-001"})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), result)
+	})
+
+	t.Run("returns error for a command without a handler", func(t *testing.T) {
+		bus := New(&fakeCollector{})
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-001"})
+		assert.Error(t, err)
+	})
+
+	t.Run("panics when a command is given a second handler", func(t *testing.T) {
+		bus := New(&fakeCollector{})
+		handler := func(c commands.Allocate) (any, error) { return nil, nil }
+		RegisterCommand(bus, handler)
+
+		assert.Panics(t, func() { RegisterCommand(bus, handler) })
+	})
+
+	t.Run("wraps the failure of the command handler", func(t *testing.T) {
+		bus := New(&fakeCollector{})
+		handlerErr := errors.New("boom")
+		RegisterCommand(bus, func(c commands.Allocate) (any, error) {
+			return nil, handlerErr
+		})
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-001"})
+		assert.ErrorIs(t, err, handlerErr)
+		assert.ErrorAs(t, err, &CommandHandlerError{})
+	})
+
+	t.Run("passes raised events to every handler in order", func(t *testing.T) {
+		collector := &fakeCollector{}
+		bus := New(collector)
+
+		var handled []string
+		RegisterCommand(bus, func(c commands.Allocate) (any, error) {
+			collector.events = append(collector.events, domain.Allocated{OrderID: c.OrderID})
+			return nil, nil
+		})
+		RegisterEvent(bus, func(e domain.Allocated) error {
+			handled = append(handled, "first")
+			collector.events = append(collector.events, domain.OutOfStock{Sku: "LARGE-TABLE"})
+			return nil
+		})
+		RegisterEvent(bus, func(e domain.Allocated) error {
+			handled = append(handled, "second")
+			return nil
+		})
+		RegisterEvent(bus, func(e domain.OutOfStock) error {
+			handled = append(handled, "raised by handler")
+			return nil
+		})
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-001"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"first", "second", "raised by handler"}, handled)
+	})
+
+	t.Run("handles events raised by a failing command", func(t *testing.T) {
+		collector := &fakeCollector{}
+		bus := New(collector)
+
+		RegisterCommand(bus, func(c commands.Allocate) (any, error) {
+			collector.events = append(collector.events, domain.OutOfStock{Sku: c.Sku})
+			return nil, domain.OutOfStockError{}
+		})
+		var handled []domain.Event
+		RegisterEvent(bus, func(e domain.OutOfStock) error {
+			handled = append(handled, e)
+			return nil
+		})
+
+		_, err := bus.Handle(commands.Allocate{Sku: "LARGE-TABLE"})
+		assert.Error(t, err)
+		assert.Equal(t, []domain.Event{domain.OutOfStock{Sku: "LARGE-TABLE"}}, handled)
+	})
+
+	t.Run("reports event handler failures without failing the command", func(t *testing.T) {
+		collector := &fakeCollector{}
+		var eventErrors []EventHandlerError
+		bus := New(collector, WithEventErrorHandler(func(err EventHandlerError) {
+			eventErrors = append(eventErrors, err)
+		}))
+
+		RegisterCommand(bus, func(c commands.Allocate) (any, error) {
+			collector.events = append(collector.events, domain.Allocated{OrderID: c.OrderID})
+			return nil, nil
+		})
+		handlerErr := errors.New("boom")
+		RegisterEvent(bus, func(e domain.Allocated) error {
+			return handlerErr
+		})
+		secondHandlerCalled := false
+		RegisterEvent(bus, func(e domain.Allocated) error {
+			secondHandlerCalled = true
+			return nil
+		})
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-001"})
+		assert.Nil(t, err)
+		assert.True(t, secondHandlerCalled)
+		assert.Len(t, eventErrors, 1)
+		assert.ErrorIs(t, eventErrors[0], handlerErr)
+		assert.Equal(t, domain.Allocated{OrderID: "order-001"}, eventErrors[0].Event)
+	})
+}
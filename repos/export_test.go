@@ -0,0 +1,9 @@
+package repos
+
+// The sql schema of the tests is shared with the tests of the repos_test package
+var (
+	CreateTables   = createTables
+	TruncateTables = truncateTables
+)
+
+const TestDBFile = testDBFile
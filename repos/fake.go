@@ -2,75 +2,178 @@ package repos
 
 import (
 	"fmt"
+	"maps"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/abbasegbeyemi/cosmic-python-go/domain"
 )
 
 type FakeRepository struct {
-	Batches          []domain.Batch
-	OrderLines       []domain.OrderLine
-	BatchAllocations map[domain.Reference][]domain.OrderLine
+	Products        map[domain.Sku]*domain.Product
+	Recalls         map[domain.Reference]domain.RecallReport
+	CancelledOrders map[domain.Reference]bool
+	// seen holds the products handed out or stored by the repository whose events have not been collected yet
+	seen map[*domain.Product]struct{}
 }
 
-func (f *FakeRepository) AddBatch(batch domain.Batch) error {
-	f.Batches = append(f.Batches, batch)
+func (f *FakeRepository) AddProduct(product *domain.Product) error {
+	if _, ok := f.Products[product.Sku]; ok {
+		return fmt.Errorf("product %s already exists", product.Sku)
+	}
+	f.Products[product.Sku] = product
+	f.seen[product] = struct{}{}
 	return nil
 }
 
-func (f *FakeRepository) ListBatches() ([]domain.Batch, error) {
-	return f.Batches, nil
+func (f *FakeRepository) GetProduct(sku domain.Sku) (*domain.Product, error) {
+	product, ok := f.Products[sku]
+	if !ok {
+		return nil, nil
+	}
+	f.seen[product] = struct{}{}
+	return product, nil
 }
 
-func (f *FakeRepository) GetBatch(reference domain.Reference) (domain.Batch, error) {
-	for _, batch := range f.Batches {
-		if batch.Reference == reference {
-			return batch, nil
+func (f *FakeRepository) GetProductByBatchRef(reference domain.Reference) (*domain.Product, error) {
+	for _, product := range f.Products {
+		if _, ok := product.Batch(reference); ok {
+			f.seen[product] = struct{}{}
+			return product, nil
 		}
 	}
-	return domain.Batch{}, fmt.Errorf("could not find requested batch")
+	return nil, nil
 }
 
-func (f *FakeRepository) AddOrderLine(orderLine domain.OrderLine) error {
-	f.OrderLines = append(f.OrderLines, orderLine)
+func (f *FakeRepository) SaveProduct(product *domain.Product) error {
+	if _, ok := f.Products[product.Sku]; !ok {
+		return fmt.Errorf("product %s does not exist", product.Sku)
+	}
+	f.Products[product.Sku] = product
+	f.seen[product] = struct{}{}
 	return nil
 }
 
-func (f *FakeRepository) AllocateToBatch(batch domain.Batch, orderLine domain.OrderLine) error {
-	_, ok := f.BatchAllocations[batch.Reference]
-	batch.Allocate(orderLine)
-	if !ok {
-		f.BatchAllocations[batch.Reference] = []domain.OrderLine{orderLine}
-		return nil
+// CollectNewEvents pops the events recorded by the products seen since the last collection,
+// like the sql repository the products of the repository that were never handed out are left out
+func (f *FakeRepository) CollectNewEvents() []domain.Event {
+	var events []domain.Event
+	for product := range f.seen {
+		events = append(events, product.PopEvents()...)
+		delete(f.seen, product)
 	}
-	f.BatchAllocations[batch.Reference] = append(f.BatchAllocations[batch.Reference], orderLine)
-	return nil
+	return events
 }
 
-func (f *FakeRepository) DeallocateFromBatch(batch domain.Batch, orderLine domain.OrderLine) error {
+// ListSkusWithExpiredHolds returns the skus of the products holding stock for holds that have expired by now
+func (f *FakeRepository) ListSkusWithExpiredHolds(now time.Time) ([]domain.Sku, error) {
+	var skus []domain.Sku
+	for sku, product := range f.Products {
+		if slices.ContainsFunc(product.Batches, func(batch domain.Batch) bool {
+			return batch.Holds != nil && slices.ContainsFunc(batch.Holds.ToSlice(), func(hold domain.Hold) bool {
+				return hold.HasExpired(now)
+			})
+		}) {
+			skus = append(skus, sku)
+		}
+	}
+	slices.Sort(skus)
+	return skus, nil
+}
 
-	allocatedOrderLines := f.BatchAllocations[batch.Reference]
+// ListSkusWithBatchesExpiringBy returns the skus of the products with perishable batches that expire by the given time
+func (f *FakeRepository) ListSkusWithBatchesExpiringBy(by time.Time) ([]domain.Sku, error) {
+	var skus []domain.Sku
+	for sku, product := range f.Products {
+		if slices.ContainsFunc(product.Batches, func(batch domain.Batch) bool {
+			return !batch.BestBefore.IsZero() && !batch.BestBefore.After(by)
+		}) {
+			skus = append(skus, sku)
+		}
+	}
+	slices.Sort(skus)
+	return skus, nil
+}
 
-	batch.Deallocate(orderLine)
-	orderLineIndex := slices.IndexFunc[[]domain.OrderLine](allocatedOrderLines, func(ol domain.OrderLine) bool {
-		return ol.OrderID == orderLine.OrderID
+// ListWarehouseBatches returns the batches kept at the warehouse, sorted by sku and reference
+func (f *FakeRepository) ListWarehouseBatches(warehouse domain.Warehouse) ([]domain.Batch, error) {
+	var batches []domain.Batch
+	for _, product := range f.Products {
+		for _, batch := range product.Batches {
+			if batch.Warehouse == warehouse {
+				batches = append(batches, batch.Clone())
+			}
+		}
+	}
+	slices.SortFunc(batches, func(aBatch, bBatch domain.Batch) int {
+		if aBatch.Sku != bBatch.Sku {
+			return strings.Compare(string(aBatch.Sku), string(bBatch.Sku))
+		}
+		return strings.Compare(string(aBatch.Reference), string(bBatch.Reference))
 	})
-	if orderLineIndex == -1 {
-		return fmt.Errorf("this order line has not been allocated to this batch")
+	return batches, nil
+}
+
+// ListOrderSkus returns the skus the order has lines of that are allocated, held or backordered, sorted by sku
+func (f *FakeRepository) ListOrderSkus(orderID domain.Reference) ([]domain.Sku, error) {
+	var skus []domain.Sku
+	ofOrder := func(orderLine domain.OrderLine) bool {
+		return orderLine.OrderID == orderID
 	}
-	// Override the order line with the one at the end
-	allocatedOrderLines[orderLineIndex] = allocatedOrderLines[len(allocatedOrderLines)-1]
+	for sku, product := range f.Products {
+		if slices.ContainsFunc(product.Backorders, ofOrder) || slices.ContainsFunc(product.Batches, func(batch domain.Batch) bool {
+			return batch.Allocations != nil && slices.ContainsFunc(batch.Allocations.ToSlice(), ofOrder) ||
+				batch.Holds != nil && slices.ContainsFunc(batch.Holds.ToSlice(), func(hold domain.Hold) bool {
+					return ofOrder(hold.OrderLine)
+				})
+		}) {
+			skus = append(skus, sku)
+		}
+	}
+	slices.Sort(skus)
+	return skus, nil
+}
 
-	// Use the list of order lines barring the last one
-	f.BatchAllocations[batch.Reference] = allocatedOrderLines[:len(allocatedOrderLines)-1]
+// CancelOrderLines records the order as cancelled
+func (f *FakeRepository) CancelOrderLines(orderID domain.Reference) error {
+	f.CancelledOrders[orderID] = true
+	return nil
+}
 
+// AddRecallReport stores the report of a recall, a recall can only be reported once
+func (f *FakeRepository) AddRecallReport(report domain.RecallReport) error {
+	if _, ok := f.Recalls[report.RecallID]; ok {
+		return fmt.Errorf("recall %s already exists", report.RecallID)
+	}
+	f.Recalls[report.RecallID] = report
 	return nil
 }
 
+// GetRecallReport returns the report of the recall, or nil if the recall is unknown
+func (f *FakeRepository) GetRecallReport(recallID domain.Reference) (*domain.RecallReport, error) {
+	report, ok := f.Recalls[recallID]
+	if !ok {
+		return nil, nil
+	}
+	return &report, nil
+}
+
+func (f *FakeRepository) GetBatch(reference domain.Reference) (domain.Batch, error) {
+	for _, product := range f.Products {
+		if batch, ok := product.Batch(reference); ok {
+			return *batch, nil
+		}
+	}
+	return domain.Batch{}, fmt.Errorf("could not find requested batch")
+}
+
 func NewFakeRepository(options ...func(*FakeRepository)) *FakeRepository {
 	repo := &FakeRepository{
-		BatchAllocations: make(map[domain.Reference][]domain.OrderLine),
+		Products:        make(map[domain.Sku]*domain.Product),
+		Recalls:         make(map[domain.Reference]domain.RecallReport),
+		CancelledOrders: make(map[domain.Reference]bool),
+		seen:            make(map[*domain.Product]struct{}),
 	}
 	for _, o := range options {
 		o(repo)
@@ -78,14 +181,62 @@ func NewFakeRepository(options ...func(*FakeRepository)) *FakeRepository {
 	return repo
 }
 
-func WithBatch(ref domain.Reference, sku domain.Sku, quantity int, eta time.Time) func(*FakeRepository) {
-	return func(f *FakeRepository) {
-		f.Batches = append(f.Batches, domain.NewBatch(ref, sku, quantity, eta))
+// FakeUnitOfWork records whether the work was committed.
+// Rolling back restores the products, recalls and cancelled orders of the fake repository to how they were when the work began
+// and discards the events recorded.
+type FakeUnitOfWork struct {
+	*FakeRepository
+	Committed         bool
+	snapshot          map[domain.Sku]*domain.Product
+	recallsSnapshot   map[domain.Reference]domain.RecallReport
+	cancelledSnapshot map[domain.Reference]bool
+}
+
+func NewFakeUnitOfWork(options ...func(*FakeRepository)) *FakeUnitOfWork {
+	return &FakeUnitOfWork{
+		FakeRepository: NewFakeRepository(options...),
 	}
 }
 
-func WithOrderLine(orderId domain.Reference, sku domain.Sku, quantity int) func(*FakeRepository) {
+func (f *FakeUnitOfWork) Begin() error {
+	f.Committed = false
+	f.seen = make(map[*domain.Product]struct{})
+	f.snapshot = make(map[domain.Sku]*domain.Product, len(f.Products))
+	for sku, product := range f.Products {
+		clone := product.Clone()
+		f.snapshot[sku] = &clone
+	}
+	f.recallsSnapshot = maps.Clone(f.Recalls)
+	f.cancelledSnapshot = maps.Clone(f.CancelledOrders)
+	return nil
+}
+
+func (f *FakeUnitOfWork) Commit() error {
+	f.Committed = true
+	f.snapshot = nil
+	return nil
+}
+
+func (f *FakeUnitOfWork) Rollback() error {
+	if f.Committed || f.snapshot == nil {
+		return nil
+	}
+	f.Products = f.snapshot
+	f.Recalls = f.recallsSnapshot
+	f.CancelledOrders = f.cancelledSnapshot
+	f.seen = make(map[*domain.Product]struct{})
+	f.snapshot = nil
+	return nil
+}
+
+func WithBatch(ref domain.Reference, sku domain.Sku, quantity int, eta time.Time) func(*FakeRepository) {
 	return func(f *FakeRepository) {
-		f.OrderLines = append(f.OrderLines, domain.OrderLine{OrderID: orderId, Sku: sku, Quantity: quantity})
+		product, ok := f.Products[sku]
+		if !ok {
+			newProduct := domain.NewProduct(sku, nil)
+			product = &newProduct
+			f.Products[sku] = product
+		}
+		product.Batches = append(product.Batches, domain.NewBatch(ref, sku, quantity, eta))
 	}
 }
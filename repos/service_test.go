@@ -0,0 +1,86 @@
+package repos_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/abbasegbeyemi/cosmic-python-go/messagebus"
+	"github.com/abbasegbeyemi/cosmic-python-go/repos"
+	"github.com/abbasegbeyemi/cosmic-python-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLUnitOfWork_StockService(t *testing.T) {
+	db, err := sql.Open("sqlite3", repos.TestDBFile)
+	assert.Nil(t, err)
+
+	repos.CreateTables(t, db)
+	defer repos.TruncateTables(t, db)
+
+	t.Run("deallocates a line ordered in another unit from a product read back from the db", func(t *testing.T) {
+		uow, err := repos.NewSqliteUnitOfWork(repos.TestDBFile)
+		assert.Nil(t, err)
+		clockUnits := domain.UnitsOfMeasure{Sku: "RETRO-CLOCK", Base: "each", Factors: map[domain.Unit]int{"case": 12}}
+		service := services.NewStockService(uow, services.WithUnitsOfMeasure(clockUnits))
+
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+		orderLine := domain.OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case"}
+		batchRef, err := service.AllocateLine(orderLine, "")
+		assert.Nil(t, err)
+
+		assert.Nil(t, service.Deallocate(domain.Batch{Reference: batchRef}, orderLine))
+
+		var count int
+		assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM batches_order_lines WHERE order_id=?`, "order-001").Scan(&count))
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("handles the out of stock event of a hold that fails", func(t *testing.T) {
+		uow, err := repos.NewSqliteUnitOfWork(repos.TestDBFile)
+		assert.Nil(t, err)
+		bus := services.NewMessageBus(uow)
+
+		var events []domain.Event
+		messagebus.RegisterEvent(bus, func(event domain.OutOfStock) error {
+			events = append(events, event)
+			return nil
+		})
+
+		_, err = bus.Handle(commands.CreateBatch{Reference: "lamp-batch", Sku: "BLUE-LAMP", Quantity: 5})
+		assert.Nil(t, err)
+
+		_, err = bus.Handle(commands.Hold{OrderID: "order-002", Sku: "BLUE-LAMP", Quantity: 10})
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+		assert.Equal(t, []domain.Event{domain.OutOfStock{Sku: "BLUE-LAMP"}}, events)
+	})
+
+	t.Run("handles the out of stock event of a line allocated to a substitute", func(t *testing.T) {
+		uow, err := repos.NewSqliteUnitOfWork(repos.TestDBFile)
+		assert.Nil(t, err)
+		bus := services.NewMessageBus(uow, services.WithSubstitutes("OAK-TABLE", "PINE-TABLE"), services.WithSubstitutionOptIn("customer-1"))
+
+		var events []domain.Event
+		messagebus.RegisterEvent(bus, func(event domain.OutOfStock) error {
+			events = append(events, event)
+			return nil
+		})
+		messagebus.RegisterEvent(bus, func(event domain.OrderLineSubstituted) error {
+			events = append(events, event)
+			return nil
+		})
+
+		_, err = bus.Handle(commands.CreateBatch{Reference: "oak-batch", Sku: "OAK-TABLE", Quantity: 5})
+		assert.Nil(t, err)
+		_, err = bus.Handle(commands.CreateBatch{Reference: "pine-batch", Sku: "PINE-TABLE", Quantity: 20})
+		assert.Nil(t, err)
+
+		_, err = bus.Handle(commands.Allocate{OrderID: "order-003", CustomerID: "customer-1", Sku: "OAK-TABLE", Quantity: 10})
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []domain.Event{
+			domain.OutOfStock{Sku: "OAK-TABLE"},
+			domain.OrderLineSubstituted{OrderID: "order-003", CustomerID: "customer-1", Sku: "OAK-TABLE", SubstituteSku: "PINE-TABLE", Quantity: 10, BatchRef: "pine-batch"},
+		}, events)
+	})
+}
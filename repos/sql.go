@@ -2,7 +2,10 @@ package repos
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/abbasegbeyemi/cosmic-python-go/domain"
 	mapset "github.com/deckarep/golang-set/v2"
@@ -31,6 +34,31 @@ func (db *DBWrapper) QueryRow(query string, args ...any) DBRow {
 	return db.DB.QueryRow(query, args...)
 }
 
+func (db *DBWrapper) Begin() (*sql.Tx, error) {
+	return db.DB.Begin()
+}
+
+type TxWrapper struct {
+	Tx *sql.Tx
+}
+
+func (tx *TxWrapper) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(query, args...)
+}
+
+func (tx *TxWrapper) Query(query string, args ...any) (DBRows, error) {
+	return tx.Tx.Query(query, args...)
+}
+
+func (tx *TxWrapper) QueryRow(query string, args ...any) DBRow {
+	return tx.Tx.QueryRow(query, args...)
+}
+
+// transactor is implemented by DB handles that are able to open a transaction
+type transactor interface {
+	Begin() (*sql.Tx, error)
+}
+
 type DBRow interface {
 	Scan(...any) error
 }
@@ -44,15 +72,64 @@ type DBRows interface {
 
 type SQLRepository struct {
 	db DB
+	// versions holds the version number of each product seen by the repository as it was last read from or written to the db
+	versions sync.Map
+	// seen holds the products read from or written to the db whose events have not been collected yet
+	seen sync.Map
 }
 
-const insertBatchRow string = `INSERT INTO batches VALUES(?,?,?,?)`
-const insertOrderLineRow string = `INSERT INTO order_lines VALUES (?,?,?)`
-const insertBatchOrderLineRow string = `INSERT INTO batches_order_lines VALUES (?,?)`
-const selectBatchRow string = `SELECT reference, sku, quantity, eta FROM "batches" WHERE reference=?`
-const selectAllBatches string = `SELECT * FROM batches`
-const selectBatchAllocations string = `SELECT * FROM batches_order_lines WHERE batch_id=?`
-const selectOrderLineRow string = `SELECT * FROM order_lines WHERE order_id=?`
+const insertBatchRow string = `INSERT INTO batches (reference, sku, quantity, eta) VALUES(?,?,?,?)`
+const insertOrderLineRow string = `INSERT INTO order_lines (order_id, sku, quantity, priority) VALUES (?,?,?,?)`
+const insertBatchOrderLineRow string = `INSERT INTO batches_order_lines (batch_id, order_id) VALUES (?,?)`
+const insertBatchOrderLinePartRow string = `INSERT INTO batches_order_lines (batch_id, order_id, quantity) VALUES (?,?,?)`
+const selectBatchOrderLines string = `
+	SELECT order_lines.order_id, order_lines.sku, COALESCE(batches_order_lines.quantity, order_lines.quantity), order_lines.unit, order_lines.priority
+	FROM batches_order_lines
+	JOIN order_lines ON order_lines.order_id = batches_order_lines.order_id AND order_lines.sku = ?
+	WHERE batches_order_lines.batch_id = ?`
+const insertProductRow string = `INSERT INTO products VALUES (?,?)`
+const selectProductRow string = `SELECT sku, version_number FROM products WHERE sku=?`
+const updateProductVersion string = `UPDATE products SET version_number=? WHERE sku=? AND version_number=?`
+const selectBatchSku string = `SELECT sku FROM batches WHERE reference=?`
+const selectProductBatches string = `
+	SELECT reference, sku, quantity, unit, eta, best_before, flagged_expiring, warehouse, status FROM batches WHERE sku=?`
+const selectWarehouseBatches string = `
+	SELECT reference, sku, quantity, unit, eta, best_before, flagged_expiring, warehouse, status FROM batches WHERE warehouse=?
+	ORDER BY sku, reference`
+const upsertBatchRow string = `
+	INSERT INTO batches (reference, sku, quantity, unit, eta, best_before, flagged_expiring, warehouse, status) VALUES (?,?,?,?,?,?,?,?,?)
+	ON CONFLICT(reference) DO UPDATE SET
+	quantity=excluded.quantity, unit=excluded.unit, eta=excluded.eta, best_before=excluded.best_before,
+	flagged_expiring=excluded.flagged_expiring, warehouse=excluded.warehouse, status=excluded.status`
+const selectExpiringBatchSkus string = `SELECT DISTINCT sku FROM batches WHERE best_before IS NOT NULL AND best_before <= ?`
+const upsertOrderLineRow string = `
+	INSERT INTO order_lines (order_id, sku, quantity, unit, priority) VALUES (?,?,?,?,?)
+	ON CONFLICT(order_id, sku) DO UPDATE SET quantity=excluded.quantity, unit=excluded.unit, priority=excluded.priority, cancelled=0`
+const selectOrderSkus string = `
+	SELECT batches.sku FROM batches_order_lines
+	JOIN batches ON batches.reference = batches_order_lines.batch_id
+	WHERE batches_order_lines.order_id = ?
+	UNION SELECT sku FROM holds WHERE order_id = ?
+	UNION SELECT sku FROM backorders WHERE order_id = ?
+	ORDER BY 1`
+const cancelOrderLineRows string = `UPDATE order_lines SET cancelled=1 WHERE order_id=?`
+const deleteBatchAllocations string = `DELETE FROM batches_order_lines WHERE batch_id=?`
+const selectBatchHolds string = `SELECT order_id, sku, quantity, unit, priority, expires_at FROM holds WHERE batch_id=?`
+const deleteBatchHolds string = `DELETE FROM holds WHERE batch_id=?`
+const insertHoldRow string = `
+	INSERT INTO holds (batch_id, order_id, sku, quantity, unit, priority, expires_at) VALUES (?,?,?,?,?,?,?)`
+const selectExpiredHoldSkus string = `SELECT DISTINCT sku FROM holds WHERE expires_at <= ?`
+const selectProductBackorders string = `SELECT order_id, sku, quantity, unit, priority, split FROM backorders WHERE sku=? ORDER BY id`
+const deleteProductBackorders string = `DELETE FROM backorders WHERE sku=?`
+const insertBackorderRow string = `INSERT INTO backorders (order_id, sku, quantity, unit, priority, split) VALUES (?,?,?,?,?,?)`
+const insertRecallRow string = `INSERT INTO recalls (recall_id, policy, recalled_at) VALUES (?,?,?)`
+const insertRecallBatchRow string = `INSERT INTO recall_batches (recall_id, batch_id, sku) VALUES (?,?,?)`
+const insertRecallLineRow string = `
+	INSERT INTO recall_lines (recall_id, batch_id, order_id, sku, quantity, unit, priority, to_batch_id) VALUES (?,?,?,?,?,?,?,?)`
+const selectRecallRow string = `SELECT recall_id, policy, recalled_at FROM recalls WHERE recall_id=?`
+const selectRecallBatches string = `SELECT batch_id, sku FROM recall_batches WHERE recall_id=? ORDER BY rowid`
+const selectRecallLines string = `
+	SELECT batch_id, order_id, sku, quantity, unit, priority, to_batch_id FROM recall_lines WHERE recall_id=? ORDER BY rowid`
 
 func NewSqliteRepository(filepath string) (*SQLRepository, error) {
 	db, err := sql.Open("sqlite3", filepath)
@@ -64,124 +141,492 @@ func NewSqliteRepository(filepath string) (*SQLRepository, error) {
 	}, nil
 }
 
-func (s *SQLRepository) AddBatch(batch domain.Batch) error {
-	if _, err := s.db.Exec(insertBatchRow, batch.Reference, batch.Sku, batch.Quantity, batch.ETA); err != nil {
-		return fmt.Errorf("could not add persist batch to db: %w", err)
+func (s *SQLRepository) enrichAllocations(batch domain.Batch) (domain.Batch, error) {
+	orderLineRows, err := s.db.Query(selectBatchOrderLines, batch.Sku, batch.Reference)
+
+	if err != nil {
+		return batch, fmt.Errorf("could not get allocations for batch: %w", err)
 	}
+	defer orderLineRows.Close()
 
-	return nil
+	for orderLineRows.Next() {
+		orderLine := domain.OrderLine{}
+		if err := orderLineRows.Scan(&orderLine.OrderID, &orderLine.Sku, &orderLine.Quantity, &orderLine.Unit, &orderLine.Priority); err != nil {
+			return batch, fmt.Errorf("could not scan the allocated order line: %w", err)
+		}
+		// The lines are loaded as stored, the batch may no longer accept new ones after a recall
+		batch.Allocations.Add(orderLine)
+	}
+
+	if err := orderLineRows.Err(); err != nil {
+		return batch, fmt.Errorf("an error occurred while iterating over allocations: %w", err)
+	}
+
+	return batch, nil
 }
 
-func (s *SQLRepository) AddOrderLine(orderLine domain.OrderLine) error {
-	if _, err := s.db.Exec(insertOrderLineRow, orderLine.OrderID, orderLine.Sku, orderLine.Quantity); err != nil {
-		return fmt.Errorf("could not add persist batch to db: %w", err)
+func (s *SQLRepository) AddProduct(product *domain.Product) error {
+	err := s.inTransaction(func(repo *SQLRepository) error {
+		if _, err := repo.db.Exec(insertProductRow, product.Sku, product.VersionNumber); err != nil {
+			return fmt.Errorf("could not persist product to db: %w", err)
+		}
+		if err := repo.saveBatches(product); err != nil {
+			return err
+		}
+		return repo.saveBackorders(product)
+	})
+	if err != nil {
+		return err
 	}
 
+	s.versions.Store(product, product.VersionNumber)
+	s.seen.Store(product, struct{}{})
 	return nil
 }
 
-func (s *SQLRepository) GetBatch(reference domain.Reference) (domain.Batch, error) {
-	batch := domain.Batch{
-		Allocations: mapset.NewSet[domain.OrderLine](),
+func (s *SQLRepository) GetProduct(sku domain.Sku) (*domain.Product, error) {
+	var productSku domain.Sku
+	var versionNumber int
+	if err := s.db.QueryRow(selectProductRow, sku).Scan(&productSku, &versionNumber); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not find the requested product: %w", err)
 	}
 
-	row := s.db.QueryRow(selectBatchRow, reference)
+	batches, err := s.listProductBatches(sku)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := row.Scan(&batch.Reference, &batch.Sku, &batch.Quantity, &batch.ETA); err != nil {
-		return batch, fmt.Errorf("could not find the requested batch %w", err)
+	backorders, err := s.listProductBackorders(sku)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.enrichAllocations(batch)
+	product := domain.NewProduct(productSku, batches)
+	product.VersionNumber = versionNumber
+	product.Backorders = backorders
+
+	s.versions.Store(&product, versionNumber)
+	s.seen.Store(&product, struct{}{})
+	return &product, nil
 }
 
-func (s *SQLRepository) enrichAllocations(batch domain.Batch) (domain.Batch, error) {
-	allocationsRows, err := s.db.Query(selectBatchAllocations, batch.Reference)
+func (s *SQLRepository) GetProductByBatchRef(reference domain.Reference) (*domain.Product, error) {
+	var sku domain.Sku
+	if err := s.db.QueryRow(selectBatchSku, reference).Scan(&sku); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not find the requested batch: %w", err)
+	}
+	return s.GetProduct(sku)
+}
 
-	if err != nil {
-		return batch, fmt.Errorf("could not get allocations for batch: %w", err)
+// SaveProduct persists the product if nobody else has saved it since it was loaded, or returns a domain.ConcurrencyError
+func (s *SQLRepository) SaveProduct(product *domain.Product) error {
+	loadedVersion, ok := s.versions.Load(product)
+	if !ok {
+		return fmt.Errorf("product %s was not loaded from this repository", product.Sku)
 	}
-	defer allocationsRows.Close()
 
-	for allocationsRows.Next() {
-		var orderID string
-		var batchID string
-		if err := allocationsRows.Scan(&batchID, &orderID); err != nil {
-			return batch, fmt.Errorf("could not scan the order id: %w", err)
+	err := s.inTransaction(func(repo *SQLRepository) error {
+		result, err := repo.db.Exec(updateProductVersion, product.VersionNumber, product.Sku, loadedVersion)
+		if err != nil {
+			return fmt.Errorf("could not update version of product %s: %w", product.Sku, err)
 		}
 
-		orderLine := domain.OrderLine{}
-		if err := s.db.QueryRow(selectOrderLineRow, orderID).Scan(&orderLine.OrderID, &orderLine.Sku, &orderLine.Quantity); err != nil {
-			return batch, fmt.Errorf("could not scan the order line with id %q: %w", orderID, err)
+		updatedRows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("could not check version of product %s: %w", product.Sku, err)
+		}
+		if updatedRows == 0 {
+			return domain.ConcurrencyError{Sku: product.Sku, VersionNumber: loadedVersion.(int)}
 		}
-		batch.Allocate(orderLine)
-	}
 
-	if err := allocationsRows.Err(); err != nil {
-		return batch, fmt.Errorf("an error occurred while iterating over allocations: %w", err)
+		if err := repo.saveBatches(product); err != nil {
+			return err
+		}
+		return repo.saveBackorders(product)
+	})
+	if err != nil {
+		return err
 	}
 
-	return batch, nil
+	s.versions.Store(product, product.VersionNumber)
+	s.seen.Store(product, struct{}{})
+	return nil
 }
 
-func (s *SQLRepository) ListBatches() ([]domain.Batch, error) {
-	var batchList []domain.Batch
+// CollectNewEvents pops the events recorded by the products seen since the last collection,
+// a product may record events without changing, such as when it is out of stock
+func (s *SQLRepository) CollectNewEvents() []domain.Event {
+	var events []domain.Event
+	s.seen.Range(func(key, _ any) bool {
+		events = append(events, key.(*domain.Product).PopEvents()...)
+		s.seen.Delete(key)
+		return true
+	})
+	return events
+}
+
+// forget stops tracking the versions of the products seen, they cannot be saved again without being read again
+func (s *SQLRepository) forget() {
+	s.versions.Range(func(key, _ any) bool {
+		s.versions.Delete(key)
+		return true
+	})
+}
 
-	batchRows, err := s.db.Query(selectAllBatches)
+// inTransaction runs fn against a repository bound to a new transaction, or against this repository if the db cannot open one
+func (s *SQLRepository) inTransaction(fn func(*SQLRepository) error) error {
+	db, ok := s.db.(transactor)
+	if !ok {
+		return fn(s)
+	}
 
+	tx, err := db.Begin()
 	if err != nil {
-		return batchList, fmt.Errorf("could not get batches: %w", err)
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	if err = fn(&SQLRepository{db: &TxWrapper{Tx: tx}}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
 	}
+	return nil
+}
+
+func (s *SQLRepository) listProductBatches(sku domain.Sku) ([]domain.Batch, error) {
+	batchList, err := s.listBatches(selectProductBatches, sku)
+	if err != nil {
+		return batchList, fmt.Errorf("could not get batches of product: %w", err)
+	}
+	return batchList, nil
+}
+
+// ListWarehouseBatches returns the batches kept at the warehouse with their allocations and holds, sorted by sku and reference
+func (s *SQLRepository) ListWarehouseBatches(warehouse domain.Warehouse) ([]domain.Batch, error) {
+	batchList, err := s.listBatches(selectWarehouseBatches, warehouse)
+	if err != nil {
+		return batchList, fmt.Errorf("could not get batches of warehouse: %w", err)
+	}
+	return batchList, nil
+}
+
+// listBatches returns the batches selected by the query along with their allocations and holds
+func (s *SQLRepository) listBatches(query string, args ...any) ([]domain.Batch, error) {
+	var batchList []domain.Batch
 
+	batchRows, err := s.db.Query(query, args...)
+	if err != nil {
+		return batchList, err
+	}
 	defer batchRows.Close()
 
 	for batchRows.Next() {
 		batch := domain.Batch{
 			Allocations: mapset.NewSet[domain.OrderLine](),
+			Holds:       mapset.NewSet[domain.Hold](),
+		}
+		var bestBefore sql.NullTime
+		if err := batchRows.Scan(&batch.Reference, &batch.Sku, &batch.Quantity, &batch.Unit, &batch.ETA, &bestBefore, &batch.FlaggedExpiring, &batch.Warehouse, &batch.Status); err != nil {
+			return batchList, fmt.Errorf("could not scan batch: %w", err)
 		}
+		batch.BestBefore = bestBefore.Time
+		batchList = append(batchList, batch)
+	}
+
+	if err := batchRows.Err(); err != nil {
+		return batchList, fmt.Errorf("an error occurred while iterating over batches: %w", err)
+	}
 
-		if err := batchRows.Scan(&batch.Reference, &batch.Sku, &batch.Quantity, &batch.ETA); err != nil {
-			return batchList, fmt.Errorf("could not scan when generating batch list: %s", err)
+	for i := range batchList {
+		if batchList[i], err = s.enrichAllocations(batchList[i]); err != nil {
+			return batchList, fmt.Errorf("could not enrich allocations for batchReference %s: %w", batchList[i].Reference, err)
 		}
-		batch, err = s.enrichAllocations(batch)
-		if err != nil {
-			return batchList, fmt.Errorf("could not enrich allocations for batchReference %s: %s", batch.Reference, err)
+		if err = s.enrichHolds(&batchList[i]); err != nil {
+			return batchList, fmt.Errorf("could not enrich holds for batchReference %s: %w", batchList[i].Reference, err)
 		}
-
-		batchList = append(batchList, batch)
 	}
 
 	return batchList, nil
 }
 
-func (s *SQLRepository) AllocateToBatch(batch domain.Batch, orderLine domain.OrderLine) error {
-	batch, err := s.GetBatch(batch.Reference)
+// saveBatches writes every batch of the product along with its current allocations.
+// The quantity of each allocation is kept on the allocation row as an order line may be split across batches,
+// while the order line row holds the quantity allocated across all of them.
+func (s *SQLRepository) saveBatches(product *domain.Product) error {
+	orderLines := make(map[domain.Reference]domain.OrderLine)
+
+	for _, batch := range product.Batches {
+		bestBefore := sql.NullTime{Time: batch.BestBefore.UTC(), Valid: !batch.BestBefore.IsZero()}
+		if _, err := s.db.Exec(upsertBatchRow, batch.Reference, batch.Sku, batch.Quantity, batch.Unit, batch.ETA, bestBefore, batch.FlaggedExpiring, batch.Warehouse, batch.Status); err != nil {
+			return fmt.Errorf("could not persist batch %s to db: %w", batch.Reference, err)
+		}
+
+		if _, err := s.db.Exec(deleteBatchAllocations, batch.Reference); err != nil {
+			return fmt.Errorf("could not clear allocations of batch %s: %w", batch.Reference, err)
+		}
+
+		if err := s.saveHolds(batch); err != nil {
+			return err
+		}
+
+		for _, orderLine := range batch.Allocations.ToSlice() {
+			if _, err := s.db.Exec(insertBatchOrderLinePartRow, batch.Reference, orderLine.OrderID, orderLine.Quantity); err != nil {
+				return fmt.Errorf("could not persist allocation to batch %s: %w", batch.Reference, err)
+			}
+			wholeLine, ok := orderLines[orderLine.OrderID]
+			if ok {
+				orderLine.Quantity += wholeLine.Quantity
+			}
+			orderLines[orderLine.OrderID] = orderLine
+		}
+	}
+
+	for _, orderLine := range orderLines {
+		if _, err := s.db.Exec(upsertOrderLineRow, orderLine.OrderID, orderLine.Sku, orderLine.Quantity, orderLine.Unit, orderLine.Priority); err != nil {
+			return fmt.Errorf("could not persist order line %s to db: %w", orderLine.OrderID, err)
+		}
+	}
+
+	return nil
+}
+
+// ListSkusWithExpiredHolds returns the skus of the products holding stock for holds that have expired by now
+func (s *SQLRepository) ListSkusWithExpiredHolds(now time.Time) ([]domain.Sku, error) {
+	var skus []domain.Sku
+
+	skuRows, err := s.db.Query(selectExpiredHoldSkus, now.UTC())
+	if err != nil {
+		return skus, fmt.Errorf("could not get expired holds: %w", err)
+	}
+	defer skuRows.Close()
+
+	for skuRows.Next() {
+		var sku domain.Sku
+		if err := skuRows.Scan(&sku); err != nil {
+			return skus, fmt.Errorf("could not scan sku of expired hold: %w", err)
+		}
+		skus = append(skus, sku)
+	}
+
+	if err := skuRows.Err(); err != nil {
+		return skus, fmt.Errorf("an error occurred while iterating over expired holds: %w", err)
+	}
+
+	return skus, nil
+}
+
+// ListOrderSkus returns the skus the order has lines of that are allocated, held or backordered, sorted by sku
+func (s *SQLRepository) ListOrderSkus(orderID domain.Reference) ([]domain.Sku, error) {
+	var skus []domain.Sku
+
+	skuRows, err := s.db.Query(selectOrderSkus, orderID, orderID, orderID)
+	if err != nil {
+		return skus, fmt.Errorf("could not get lines of order %s: %w", orderID, err)
+	}
+	defer skuRows.Close()
+
+	for skuRows.Next() {
+		var sku domain.Sku
+		if err := skuRows.Scan(&sku); err != nil {
+			return skus, fmt.Errorf("could not scan sku of order line: %w", err)
+		}
+		skus = append(skus, sku)
+	}
+
+	if err := skuRows.Err(); err != nil {
+		return skus, fmt.Errorf("an error occurred while iterating over order lines: %w", err)
+	}
+
+	return skus, nil
+}
+
+// CancelOrderLines marks the order lines of the order as cancelled
+func (s *SQLRepository) CancelOrderLines(orderID domain.Reference) error {
+	if _, err := s.db.Exec(cancelOrderLineRows, orderID); err != nil {
+		return fmt.Errorf("could not cancel lines of order %s: %w", orderID, err)
+	}
+	return nil
+}
+
+// ListSkusWithBatchesExpiringBy returns the skus of the products with perishable batches that expire by the given time
+func (s *SQLRepository) ListSkusWithBatchesExpiringBy(by time.Time) ([]domain.Sku, error) {
+	var skus []domain.Sku
+
+	skuRows, err := s.db.Query(selectExpiringBatchSkus, by.UTC())
 	if err != nil {
-		return fmt.Errorf("could not find batch: %s", err)
+		return skus, fmt.Errorf("could not get expiring batches: %w", err)
+	}
+	defer skuRows.Close()
+
+	for skuRows.Next() {
+		var sku domain.Sku
+		if err := skuRows.Scan(&sku); err != nil {
+			return skus, fmt.Errorf("could not scan sku of expiring batch: %w", err)
+		}
+		skus = append(skus, sku)
+	}
+
+	if err := skuRows.Err(); err != nil {
+		return skus, fmt.Errorf("an error occurred while iterating over expiring batches: %w", err)
+	}
+
+	return skus, nil
+}
+
+func (s *SQLRepository) enrichHolds(batch *domain.Batch) error {
+	holdRows, err := s.db.Query(selectBatchHolds, batch.Reference)
+	if err != nil {
+		return fmt.Errorf("could not get holds for batch: %w", err)
+	}
+	defer holdRows.Close()
+
+	for holdRows.Next() {
+		hold := domain.Hold{}
+		if err := holdRows.Scan(&hold.OrderID, &hold.Sku, &hold.Quantity, &hold.Unit, &hold.Priority, &hold.ExpiresAt); err != nil {
+			return fmt.Errorf("could not scan the hold: %w", err)
+		}
+		batch.Holds.Add(hold)
 	}
 
-	if err = batch.Allocate(orderLine); err != nil {
-		return fmt.Errorf("cannot allocate this order to this batch: %s", err)
+	if err := holdRows.Err(); err != nil {
+		return fmt.Errorf("an error occurred while iterating over holds: %w", err)
 	}
 
-	if _, err := s.db.Exec(insertBatchOrderLineRow, batch.Reference, orderLine.OrderID); err != nil {
-		return fmt.Errorf("failed to store allocation to db: %s", err)
+	return nil
+}
+
+// saveHolds replaces the holds of the batch, expiry times are stored in UTC so that they compare in order
+func (s *SQLRepository) saveHolds(batch domain.Batch) error {
+	if _, err := s.db.Exec(deleteBatchHolds, batch.Reference); err != nil {
+		return fmt.Errorf("could not clear holds of batch %s: %w", batch.Reference, err)
+	}
+	if batch.Holds == nil {
+		return nil
+	}
+
+	for _, hold := range batch.Holds.ToSlice() {
+		if _, err := s.db.Exec(insertHoldRow, batch.Reference, hold.OrderID, hold.Sku, hold.Quantity, hold.Unit, hold.Priority, hold.ExpiresAt.UTC()); err != nil {
+			return fmt.Errorf("could not persist hold on batch %s: %w", batch.Reference, err)
+		}
 	}
 
 	return nil
 }
 
-func (s *SQLRepository) DeallocateFromBatch(batch domain.Batch, orderLine domain.OrderLine) error {
-	batch, err := s.GetBatch(batch.Reference)
+func (s *SQLRepository) listProductBackorders(sku domain.Sku) ([]domain.OrderLine, error) {
+	var backorders []domain.OrderLine
+
+	backorderRows, err := s.db.Query(selectProductBackorders, sku)
 	if err != nil {
-		return fmt.Errorf("could not find batch: %s", err)
+		return backorders, fmt.Errorf("could not get backorders of product: %w", err)
 	}
+	defer backorderRows.Close()
 
-	batch.Deallocate(orderLine)
+	for backorderRows.Next() {
+		orderLine := domain.OrderLine{}
+		if err := backorderRows.Scan(&orderLine.OrderID, &orderLine.Sku, &orderLine.Quantity, &orderLine.Unit, &orderLine.Priority, &orderLine.Split); err != nil {
+			return backorders, fmt.Errorf("could not scan backorder of product: %w", err)
+		}
+		backorders = append(backorders, orderLine)
+	}
 
-	deleteQuery := fmt.Sprintf("DELETE FROM batches_order_lines WHERE batch_id=%q AND order_id=%q", batch.Reference, orderLine.OrderID)
-	if _, err := s.db.Exec(deleteQuery); err != nil {
-		return fmt.Errorf("failed to store allocation to db: %s", err)
+	if err := backorderRows.Err(); err != nil {
+		return backorders, fmt.Errorf("an error occurred while iterating over backorders: %w", err)
+	}
+
+	return backorders, nil
+}
+
+// saveBackorders replaces the backorders of the product, they are inserted in queue order so that reading them
+// back in insertion order keeps the order they arrived in
+func (s *SQLRepository) saveBackorders(product *domain.Product) error {
+	if _, err := s.db.Exec(deleteProductBackorders, product.Sku); err != nil {
+		return fmt.Errorf("could not clear backorders of product %s: %w", product.Sku, err)
+	}
+
+	for _, orderLine := range product.Backorders {
+		if _, err := s.db.Exec(insertBackorderRow, orderLine.OrderID, orderLine.Sku, orderLine.Quantity, orderLine.Unit, orderLine.Priority, orderLine.Split); err != nil {
+			return fmt.Errorf("could not persist backorder of order %s: %w", orderLine.OrderID, err)
+		}
 	}
 
 	return nil
 }
+
+// AddRecallReport persists the report of a recall along with its batches and affected order lines
+func (s *SQLRepository) AddRecallReport(report domain.RecallReport) error {
+	return s.inTransaction(func(repo *SQLRepository) error {
+		if _, err := repo.db.Exec(insertRecallRow, report.RecallID, report.Policy, report.RecalledAt.UTC()); err != nil {
+			return fmt.Errorf("could not persist recall %s to db: %w", report.RecallID, err)
+		}
+		for _, batch := range report.Batches {
+			if _, err := repo.db.Exec(insertRecallBatchRow, report.RecallID, batch.Reference, batch.Sku); err != nil {
+				return fmt.Errorf("could not persist recalled batch %s: %w", batch.Reference, err)
+			}
+		}
+		for _, line := range report.Lines {
+			if _, err := repo.db.Exec(insertRecallLineRow, report.RecallID, line.BatchRef, line.OrderID, line.Sku, line.Quantity, line.Unit, line.Priority, line.ToBatchRef); err != nil {
+				return fmt.Errorf("could not persist recalled order line %s: %w", line.OrderID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetRecallReport returns the report of the recall, or nil if the recall is unknown
+func (s *SQLRepository) GetRecallReport(recallID domain.Reference) (*domain.RecallReport, error) {
+	report := domain.RecallReport{}
+	if err := s.db.QueryRow(selectRecallRow, recallID).Scan(&report.RecallID, &report.Policy, &report.RecalledAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not find the requested recall: %w", err)
+	}
+
+	batchRows, err := s.db.Query(selectRecallBatches, recallID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get batches of recall: %w", err)
+	}
+	defer batchRows.Close()
+
+	for batchRows.Next() {
+		batch := domain.RecalledBatch{}
+		if err := batchRows.Scan(&batch.Reference, &batch.Sku); err != nil {
+			return nil, fmt.Errorf("could not scan recalled batch: %w", err)
+		}
+		report.Batches = append(report.Batches, batch)
+	}
+
+	if err := batchRows.Err(); err != nil {
+		return nil, fmt.Errorf("an error occurred while iterating over recalled batches: %w", err)
+	}
+
+	lineRows, err := s.db.Query(selectRecallLines, recallID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get order lines of recall: %w", err)
+	}
+	defer lineRows.Close()
+
+	for lineRows.Next() {
+		line := domain.RecalledLine{}
+		if err := lineRows.Scan(&line.BatchRef, &line.OrderID, &line.Sku, &line.Quantity, &line.Unit, &line.Priority, &line.ToBatchRef); err != nil {
+			return nil, fmt.Errorf("could not scan recalled order line: %w", err)
+		}
+		report.Lines = append(report.Lines, line)
+	}
+
+	if err := lineRows.Err(); err != nil {
+		return nil, fmt.Errorf("an error occurred while iterating over recalled order lines: %w", err)
+	}
+
+	return &report, nil
+}
@@ -2,7 +2,9 @@ package repos
 
 import (
 	"database/sql"
-	"slices"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,12 +13,24 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+const createProductsTableSQL string = `
+	CREATE TABLE IF NOT EXISTS products (
+	sku STRING NOT NULL PRIMARY KEY,
+	version_number INTEGER NOT NULL DEFAULT 0
+	);
+`
+
 const createBatchesTableSQL string = `
 	CREATE TABLE IF NOT EXISTS batches (
 	reference STRING NOT NULL PRIMARY KEY,
 	sku STRING NOT NULL,
 	quantity INTEGER NOT NULL,
-	eta DATETIME
+	unit STRING NOT NULL DEFAULT '',
+	eta DATETIME,
+	best_before DATETIME,
+	flagged_expiring BOOLEAN NOT NULL DEFAULT 0,
+	warehouse STRING NOT NULL DEFAULT '',
+	status STRING NOT NULL DEFAULT ''
 	);
 `
 
@@ -24,7 +38,11 @@ const createOrderLinesTableSQL string = `
 	CREATE TABLE IF NOT EXISTS order_lines (
 	order_id STRING NOT NULL,
 	sku STRING NOT NULL,
-	quantity INTEGER NOT NULL
+	quantity INTEGER NOT NULL,
+	unit STRING NOT NULL DEFAULT '',
+	priority STRING NOT NULL DEFAULT '',
+	cancelled BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY(order_id, sku)
 	);
 `
 
@@ -32,22 +50,107 @@ const createBatchesOrderLinesTableSQL string = `
     CREATE TABLE IF NOT EXISTS batches_order_lines (
     batch_id STRING NOT NULL,
     order_id STRING NOT NULL,
+    quantity INTEGER,
 	FOREIGN KEY(batch_id) REFERENCES batches(reference)
     FOREIGN KEY(order_id) REFERENCES order_lines(order_id)
 	PRIMARY KEY(batch_id, order_id)
     );
 `
 
+const createBackordersTableSQL string = `
+	CREATE TABLE IF NOT EXISTS backorders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id STRING NOT NULL,
+	sku STRING NOT NULL,
+	quantity INTEGER NOT NULL,
+	unit STRING NOT NULL DEFAULT '',
+	priority STRING NOT NULL DEFAULT '',
+	split BOOLEAN NOT NULL DEFAULT 0,
+	UNIQUE(order_id, sku)
+	);
+`
+
+const createHoldsTableSQL string = `
+	CREATE TABLE IF NOT EXISTS holds (
+	batch_id STRING NOT NULL,
+	order_id STRING NOT NULL,
+	sku STRING NOT NULL,
+	quantity INTEGER NOT NULL,
+	unit STRING NOT NULL DEFAULT '',
+	priority STRING NOT NULL DEFAULT '',
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY(batch_id) REFERENCES batches(reference)
+	PRIMARY KEY(batch_id, order_id)
+	);
+`
+
+const createRecallsTableSQL string = `
+	CREATE TABLE IF NOT EXISTS recalls (
+	recall_id STRING NOT NULL PRIMARY KEY,
+	policy STRING NOT NULL,
+	recalled_at DATETIME NOT NULL
+	);
+`
+
+const createRecallBatchesTableSQL string = `
+	CREATE TABLE IF NOT EXISTS recall_batches (
+	recall_id STRING NOT NULL,
+	batch_id STRING NOT NULL,
+	sku STRING NOT NULL,
+	FOREIGN KEY(recall_id) REFERENCES recalls(recall_id)
+	PRIMARY KEY(recall_id, batch_id)
+	);
+`
+
+const createRecallLinesTableSQL string = `
+	CREATE TABLE IF NOT EXISTS recall_lines (
+	recall_id STRING NOT NULL,
+	batch_id STRING NOT NULL,
+	order_id STRING NOT NULL,
+	sku STRING NOT NULL,
+	quantity INTEGER NOT NULL,
+	unit STRING NOT NULL DEFAULT '',
+	priority STRING NOT NULL DEFAULT '',
+	to_batch_id STRING NOT NULL DEFAULT '',
+	FOREIGN KEY(recall_id) REFERENCES recalls(recall_id)
+	PRIMARY KEY(recall_id, batch_id, order_id)
+	);
+`
+
+const dropTablesSQL string = `
+	DROP TABLE IF EXISTS products;
+	DROP TABLE IF EXISTS batches;
+	DROP TABLE IF EXISTS order_lines;
+	DROP TABLE IF EXISTS batches_order_lines;
+	DROP TABLE IF EXISTS backorders;
+	DROP TABLE IF EXISTS holds;
+	DROP TABLE IF EXISTS recalls;
+	DROP TABLE IF EXISTS recall_batches;
+	DROP TABLE IF EXISTS recall_lines;
+`
+
 const truncateTablesSQL string = `
+	DELETE FROM products;
 	DELETE FROM batches;
 	DELETE FROM order_lines;
 	DELETE FROM batches_order_lines;
+	DELETE FROM backorders;
+	DELETE FROM holds;
+	DELETE FROM recalls;
+	DELETE FROM recall_batches;
+	DELETE FROM recall_lines;
 `
 
 const testDBFile string = "orders_test.sqlite"
 
 func createTables(t *testing.T, db *sql.DB) {
 	t.Helper()
+	if _, err := db.Exec(dropTablesSQL); err != nil {
+		t.Fatalf("could not drop existing tables %s", err)
+	}
+	if _, err := db.Exec(createProductsTableSQL); err != nil {
+		t.Fatalf("could not create products table %s", err)
+	}
 	if _, err := db.Exec(createBatchesTableSQL); err != nil {
 		t.Fatalf("could not create batches table %s", err)
 	}
@@ -57,6 +160,21 @@ func createTables(t *testing.T, db *sql.DB) {
 	if _, err := db.Exec(createBatchesOrderLinesTableSQL); err != nil {
 		t.Fatalf("could not create batches_order_lines table %s", err)
 	}
+	if _, err := db.Exec(createBackordersTableSQL); err != nil {
+		t.Fatalf("could not create backorders table %s", err)
+	}
+	if _, err := db.Exec(createHoldsTableSQL); err != nil {
+		t.Fatalf("could not create holds table %s", err)
+	}
+	if _, err := db.Exec(createRecallsTableSQL); err != nil {
+		t.Fatalf("could not create recalls table %s", err)
+	}
+	if _, err := db.Exec(createRecallBatchesTableSQL); err != nil {
+		t.Fatalf("could not create recall_batches table %s", err)
+	}
+	if _, err := db.Exec(createRecallLinesTableSQL); err != nil {
+		t.Fatalf("could not create recall_lines table %s", err)
+	}
 }
 
 func truncateTables(t *testing.T, db *sql.DB) {
@@ -66,15 +184,23 @@ func truncateTables(t *testing.T, db *sql.DB) {
 	}
 }
 
+func insertProduct(t *testing.T, db *sql.DB, sku domain.Sku) {
+	t.Helper()
+	if _, err := db.Exec(insertProductRow, sku, 0); err != nil {
+		t.Fatalf("could not seed the db with products: %s", err)
+	}
+}
+
 func insertBatch(t *testing.T, db *sql.DB, reference domain.Reference, sku domain.Sku, quantity int, eta time.Time) {
 	t.Helper()
 	if _, err := db.Exec(insertBatchRow, reference, sku, quantity, eta); err != nil {
 		t.Fatalf("could not seed the db with batches: %s", err)
 	}
 }
+
 func insertOrderLine(t *testing.T, db *sql.DB, orderId domain.Reference, sku domain.Sku, quantity int) {
 	t.Helper()
-	if _, err := db.Exec(insertOrderLineRow, orderId, sku, quantity); err != nil {
+	if _, err := db.Exec(insertOrderLineRow, orderId, sku, quantity, ""); err != nil {
 		t.Fatalf("could not seed the db with order lines: %s", err)
 	}
 }
@@ -86,196 +212,543 @@ func insertAllocation(t *testing.T, db *sql.DB, batchRef, orderId domain.Referen
 	}
 }
 
-func TestSQLRepository_AddBatch(t *testing.T) {
+func TestSQLRepository_GetProduct(t *testing.T) {
 	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
 	createTables(t, db)
 	defer truncateTables(t, db)
-	t.Run("can store batch", func(t *testing.T) {
-		batch := domain.NewBatch(
-			"batch-001",
-			"SMALL-TABLE",
-			10,
-			time.Time{},
-		)
 
-		repo := SQLRepository{
-			db: &DBWrapper{db},
-		}
-		err = repo.AddBatch(batch)
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	t.Run("returns nil for an unknown sku", func(t *testing.T) {
+		product, err := repo.GetProduct("UNKNOWN-LAMP")
 		assert.Nil(t, err)
+		assert.Nil(t, product)
+	})
 
-		createdBatch := domain.Batch{}
-		row := db.QueryRow(selectBatchRow, batch.Reference)
-		err = row.Scan(&createdBatch.Reference, &createdBatch.Sku, &createdBatch.Quantity, &createdBatch.ETA)
+	t.Run("returns product with only its own batches and allocations", func(t *testing.T) {
+		sku := domain.Sku("LARGE-MIRROR")
+		otherSku := domain.Sku("SMALL-MIRROR")
+		orderId := domain.Reference("order-012")
+
+		product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 50, time.Time{})})
+		otherProduct := domain.NewProduct(otherSku, []domain.Batch{domain.NewBatch("batch-002", otherSku, 50, time.Time{})})
+
+		assert.Nil(t, repo.AddProduct(&product))
+		assert.Nil(t, repo.AddProduct(&otherProduct))
+
+		insertOrderLine(t, db, orderId, sku, 3)
+		insertOrderLine(t, db, orderId, otherSku, 7)
+		insertAllocation(t, db, "batch-001", orderId)
+
+		receivedProduct, err := repo.GetProduct(sku)
 		assert.Nil(t, err)
 
-		assert.Equal(t, batch.Reference, createdBatch.Reference)
-		assert.Equal(t, batch.Sku, createdBatch.Sku)
-		assert.Equal(t, batch.Quantity, createdBatch.Quantity)
-		assert.Equal(t, batch.ETA, createdBatch.ETA)
+		assert.Equal(t, sku, receivedProduct.Sku)
+		assert.Len(t, receivedProduct.Batches, 1)
+		assert.Equal(t, domain.Reference("batch-001"), receivedProduct.Batches[0].Reference)
+		assert.Equal(t, 47, receivedProduct.Batches[0].AvailableQuantity())
 	})
 }
 
-func TestSQLRepository_GetBatch(t *testing.T) {
+func TestSQLRepository_SaveProduct(t *testing.T) {
 	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
 	createTables(t, db)
 	defer truncateTables(t, db)
-	t.Run("can retrieve batch", func(t *testing.T) {
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	sku := domain.Sku("LARGE-TABLE")
+	product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 40, time.Time{})})
+	assert.Nil(t, repo.AddProduct(&product))
+
+	orderLine := domain.OrderLine{OrderID: "order-321", Sku: sku, Quantity: 12}
+
+	storedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+
+	batchRef, err := storedProduct.Allocate(orderLine)
+	assert.Nil(t, err)
+	assert.Nil(t, storedProduct.AddBatch(domain.NewBatch("batch-002", sku, 10, time.Time{}.AddDate(0, 1, 0))))
+	assert.Nil(t, repo.SaveProduct(storedProduct))
+
+	savedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+	assert.Len(t, savedProduct.Batches, 2)
+
+	allocatedBatch, _ := savedProduct.Batch(batchRef)
+	assert.True(t, allocatedBatch.IsAllocated(orderLine))
+
+	assert.Nil(t, savedProduct.Deallocate(batchRef, orderLine))
+	assert.Nil(t, repo.SaveProduct(savedProduct))
+
+	deallocatedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+
+	deallocatedBatch, _ := deallocatedProduct.Batch(batchRef)
+	assert.Equal(t, 40, deallocatedBatch.AvailableQuantity())
+}
+
+func TestSQLRepository_SaveProductConcurrency(t *testing.T) {
+	t.Run("returns concurrency error when saving a stale product", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", testDBFile)
+		assert.Nil(t, err)
+
+		createTables(t, db)
+		defer truncateTables(t, db)
+
 		repo := SQLRepository{
 			db: &DBWrapper{db},
 		}
 
-		existingBatch := domain.Batch{
-			Reference: "batch-002",
-			Sku:       "LARGE-MIRROR",
-			Quantity:  23,
-			ETA:       time.Now().AddDate(0, 3, 0).UTC(),
-		}
-		db.Exec(insertBatchRow, existingBatch.Reference, existingBatch.Sku, existingBatch.Quantity, existingBatch.ETA)
+		sku := domain.Sku("LARGE-TABLE")
+		product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 40, time.Time{})})
+		assert.Nil(t, repo.AddProduct(&product))
 
-		receivedBatch, err := repo.GetBatch(existingBatch.Reference)
+		firstCopy, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		secondCopy, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
 
+		_, err = firstCopy.Allocate(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 10})
 		assert.Nil(t, err)
-		assert.Equal(t, existingBatch.Reference, receivedBatch.Reference)
-		assert.Equal(t, existingBatch.Sku, receivedBatch.Sku)
-		assert.Equal(t, existingBatch.Quantity, receivedBatch.Quantity)
-		assert.Equal(t, existingBatch.ETA, receivedBatch.ETA)
+		_, err = secondCopy.Allocate(domain.OrderLine{OrderID: "order-002", Sku: sku, Quantity: 10})
+		assert.Nil(t, err)
+
+		assert.Nil(t, repo.SaveProduct(firstCopy))
+
+		err = repo.SaveProduct(secondCopy)
+		assert.ErrorIs(t, err, domain.ConcurrencyError{Sku: sku, VersionNumber: product.VersionNumber})
+
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Equal(t, firstCopy.VersionNumber, savedProduct.VersionNumber)
+		assert.Equal(t, 30, savedProduct.Batches[0].AvailableQuantity())
 	})
 
-	t.Run("can retrieve batch with allocation", func(t *testing.T) {
+	t.Run("parallel allocations never over allocate a batch", func(t *testing.T) {
 		db, err := sql.Open("sqlite3", testDBFile)
 		assert.Nil(t, err)
 
 		createTables(t, db)
 		defer truncateTables(t, db)
 
-		batchRef := domain.Reference("batch-001")
-		orderId := domain.Reference("order-012")
-		sku := domain.Sku("LARGE-MIRROR")
-
-		insertBatch(t, db, batchRef, sku, 50, time.Time{})
-		insertOrderLine(t, db, orderId, sku, 3)
-		insertAllocation(t, db, batchRef, orderId)
-
 		repo := SQLRepository{
 			db: &DBWrapper{db},
 		}
 
-		receivedBatch, err := repo.GetBatch(batchRef)
+		sku := domain.Sku("LARGE-TABLE")
+		product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 50, time.Time{})})
+		assert.Nil(t, repo.AddProduct(&product))
+
+		const workers = 20
+		const quantity = 10
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				product, err := repo.GetProduct(sku)
+				if err != nil {
+					errs <- err
+					return
+				}
+				orderLine := domain.OrderLine{OrderID: domain.Reference(fmt.Sprintf("order-%03d", i)), Sku: sku, Quantity: quantity}
+				if _, err = product.Allocate(orderLine); err != nil {
+					errs <- err
+					return
+				}
+				errs <- repo.SaveProduct(product)
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		var succeeded int
+		for err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			var concurrencyError domain.ConcurrencyError
+			var outOfStockError domain.OutOfStockError
+			assert.True(t, errors.As(err, &concurrencyError) || errors.As(err, &outOfStockError), "unexpected error: %s", err)
+		}
 
+		savedProduct, err := repo.GetProduct(sku)
 		assert.Nil(t, err)
-		allocations := receivedBatch.Allocations.ToSlice()
-		assert.Greater(t, len(allocations), 0)
-		assert.Equal(t, allocations[0].OrderID, orderId)
-		assert.Equal(t, allocations[0].Sku, sku)
+
+		batch := savedProduct.Batches[0]
+		assert.GreaterOrEqual(t, batch.AvailableQuantity(), 0)
+		assert.Greater(t, succeeded, 0)
+		assert.Equal(t, succeeded*quantity, batch.AllocatedQuantity())
 	})
 }
 
-func TestSQLRepository_ListBatch(t *testing.T) {
+func TestSQLRepository_SaveSplitAllocation(t *testing.T) {
 	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
 	createTables(t, db)
 	defer truncateTables(t, db)
 
-	sku := domain.Sku("BIG-STICKER")
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	sku := domain.Sku("LARGE-TABLE")
+	product := domain.NewProduct(sku, []domain.Batch{
+		domain.NewBatch("batch-001", sku, 20, time.Time{}),
+		domain.NewBatch("batch-002", sku, 30, time.Time{}.AddDate(0, 1, 0)),
+	})
+	assert.Nil(t, repo.AddProduct(&product))
+
+	storedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+	_, err = storedProduct.AllocateSplit(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 35})
+	assert.Nil(t, err)
+	assert.Nil(t, repo.SaveProduct(storedProduct))
+
+	savedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+
+	batch1, _ := savedProduct.Batch("batch-001")
+	batch2, _ := savedProduct.Batch("batch-002")
+	assert.True(t, batch1.IsAllocated(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 20}))
+	assert.True(t, batch2.IsAllocated(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 15}))
+
+	var quantity int
+	assert.Nil(t, db.QueryRow(`SELECT quantity FROM order_lines WHERE order_id=?`, "order-001").Scan(&quantity))
+	assert.Equal(t, 35, quantity)
+}
 
-	insertBatch(t, db, "batch-007", sku, 50, time.Time{})
-	insertBatch(t, db, "batch-001", sku, 20, time.Time{})
-	insertBatch(t, db, "batch-002", sku, 10, time.Time{})
+func TestSQLRepository_SaveBackorders(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
 
 	repo := SQLRepository{
 		db: &DBWrapper{db},
 	}
 
-	receivedBatches, err := repo.ListBatches()
+	sku := domain.Sku("LARGE-TABLE")
+	product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 5, time.Time{})})
+	assert.Nil(t, repo.AddProduct(&product))
+
+	backorders := []domain.OrderLine{
+		{OrderID: "order-002", Sku: sku, Quantity: 10},
+		{OrderID: "order-001", Sku: sku, Quantity: 8},
+	}
+	storedProduct, err := repo.GetProduct(sku)
 	assert.Nil(t, err)
+	for _, orderLine := range backorders {
+		assert.Nil(t, storedProduct.Backorder(orderLine))
+	}
+	assert.Nil(t, repo.SaveProduct(storedProduct))
 
-	assert.Len(t, receivedBatches, 3)
+	t.Run("reads the backorders back in the order they arrived", func(t *testing.T) {
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Equal(t, backorders, savedProduct.Backorders)
+	})
 
-	assert.True(t, slices.ContainsFunc(receivedBatches, func(b domain.Batch) bool {
-		return b.Reference == domain.Reference("batch-001")
-	}))
+	t.Run("removes the backorders that have been filled", func(t *testing.T) {
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Nil(t, savedProduct.ChangeBatchQuantity("batch-001", 13))
+		assert.Nil(t, repo.SaveProduct(savedProduct))
 
-	assert.True(t, slices.ContainsFunc(receivedBatches, func(b domain.Batch) bool {
-		return b.Reference == domain.Reference("batch-002")
-	}))
+		filledProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-001", Sku: sku, Quantity: 8}}, filledProduct.Backorders)
+	})
 
-	assert.True(t, slices.ContainsFunc(receivedBatches, func(b domain.Batch) bool {
-		return b.Reference == domain.Reference("batch-007")
-	}))
+	t.Run("keeps the rest of a split line split", func(t *testing.T) {
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		_, err = savedProduct.BackorderSplit(domain.OrderLine{OrderID: "order-003", Sku: sku, Quantity: 4})
+		assert.Nil(t, err)
+		assert.Nil(t, repo.SaveProduct(savedProduct))
 
+		splitProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.OrderLine{
+			{OrderID: "order-001", Sku: sku, Quantity: 8},
+			{OrderID: "order-003", Sku: sku, Quantity: 1, Split: true},
+		}, splitProduct.Backorders)
+	})
 }
 
-func TestSQLRepository_AddOrderLine(t *testing.T) {
+func TestSQLRepository_SaveHolds(t *testing.T) {
 	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
 	createTables(t, db)
-
 	defer truncateTables(t, db)
 
 	repo := SQLRepository{
 		db: &DBWrapper{db},
 	}
 
-	orderLine := domain.OrderLine{
-		OrderID:  "order-001",
-		Sku:      "LARGE-TABLE",
-		Quantity: 12,
-	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sku := domain.Sku("LARGE-TABLE")
+	product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 20, time.Time{})})
+	assert.Nil(t, repo.AddProduct(&product))
 
-	err = repo.AddOrderLine(orderLine)
+	storedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+	orderLine := domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 5}
+	_, err = storedProduct.Hold(orderLine, now.Add(15*time.Minute))
 	assert.Nil(t, err)
+	assert.Nil(t, repo.SaveProduct(storedProduct))
+
+	t.Run("reads the holds back with the batch", func(t *testing.T) {
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+
+		batch, _ := savedProduct.Batch("batch-001")
+		hold, ok := batch.HoldOf("order-001")
+		assert.True(t, ok)
+		assert.Equal(t, orderLine, hold.OrderLine)
+		assert.True(t, now.Add(15*time.Minute).Equal(hold.ExpiresAt))
+		assert.Equal(t, 15, batch.AvailableQuantity())
+	})
+
+	t.Run("lists the skus of expired holds", func(t *testing.T) {
+		skus, err := repo.ListSkusWithExpiredHolds(now)
+		assert.Nil(t, err)
+		assert.Empty(t, skus)
 
+		skus, err = repo.ListSkusWithExpiredHolds(now.Add(15 * time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.Sku{sku}, skus)
+	})
 }
 
-func TestSQLRepository_AllocateToBatch(t *testing.T) {
+func TestSQLRepository_SavePriority(t *testing.T) {
 	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
 	createTables(t, db)
+	defer truncateTables(t, db)
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	sku := domain.Sku("LARGE-TABLE")
+	product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 10, time.Time{})})
+	assert.Nil(t, repo.AddProduct(&product))
+
+	expressLine := domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 10, Priority: domain.Express}
+	bulkLine := domain.OrderLine{OrderID: "order-002", Sku: sku, Quantity: 5, Priority: domain.Bulk}
+	storedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+	_, err = storedProduct.Allocate(expressLine)
+	assert.Nil(t, err)
+	assert.Nil(t, storedProduct.Backorder(bulkLine))
+	assert.Nil(t, repo.SaveProduct(storedProduct))
+
+	savedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+
+	batch, _ := savedProduct.Batch("batch-001")
+	assert.True(t, batch.IsAllocated(expressLine))
+	assert.Equal(t, []domain.OrderLine{bulkLine}, savedProduct.Backorders)
+}
+
+func TestSQLRepository_SaveExpiry(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
 
+	createTables(t, db)
 	defer truncateTables(t, db)
 
 	repo := SQLRepository{
 		db: &DBWrapper{db},
 	}
 
-	sku := "LARGE-TABLE"
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	product := domain.NewProduct("FRESH-MILK", []domain.Batch{
+		domain.NewPerishableBatch("batch-001", "FRESH-MILK", 20, time.Time{}, now.AddDate(0, 0, 2)),
+		domain.NewBatch("batch-002", "FRESH-MILK", 20, time.Time{}),
+	})
+	product.Clock = func() time.Time { return now }
+	product.ReviewExpiry(3 * 24 * time.Hour)
+	assert.Nil(t, repo.AddProduct(&product))
+	assert.Nil(t, repo.AddProduct(&domain.Product{Sku: "LONG-LIFE-MILK"}))
+
+	t.Run("reads the best before date and flag back", func(t *testing.T) {
+		savedProduct, err := repo.GetProduct("FRESH-MILK")
+		assert.Nil(t, err)
+
+		perishableBatch, _ := savedProduct.Batch("batch-001")
+		assert.True(t, now.AddDate(0, 0, 2).Equal(perishableBatch.BestBefore))
+		assert.True(t, perishableBatch.FlaggedExpiring)
+
+		longLifeBatch, _ := savedProduct.Batch("batch-002")
+		assert.True(t, longLifeBatch.BestBefore.IsZero())
+	})
+
+	t.Run("lists the skus of batches expiring by a time", func(t *testing.T) {
+		skus, err := repo.ListSkusWithBatchesExpiringBy(now.AddDate(0, 0, 1))
+		assert.Nil(t, err)
+		assert.Empty(t, skus)
+
+		skus, err = repo.ListSkusWithBatchesExpiringBy(now.AddDate(0, 0, 2))
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.Sku{"FRESH-MILK"}, skus)
+	})
+}
+
+func TestSQLRepository_SaveWarehouse(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
 
-	batch := domain.Batch{
-		Reference: "batch-012",
-		Sku:       domain.Sku(sku),
-		Quantity:  40,
+	repo := SQLRepository{
+		db: &DBWrapper{db},
 	}
 
-	orderLine := domain.OrderLine{
-		OrderID:  "order-321",
-		Quantity: 12,
-		Sku:      domain.Sku(sku),
+	orderLine := domain.OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5}
+	clockBatch := domain.NewWarehouseBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}, "leeds")
+	clockBatch.Allocate(orderLine)
+	clocks := domain.NewProduct("RETRO-CLOCK", []domain.Batch{
+		clockBatch,
+		domain.NewWarehouseBatch("london-clock-batch", "RETRO-CLOCK", 20, time.Time{}, "london"),
+	})
+	lamps := domain.NewProduct("BLUE-LAMP", []domain.Batch{domain.NewWarehouseBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}, "leeds")})
+	assert.Nil(t, repo.AddProduct(&clocks))
+	assert.Nil(t, repo.AddProduct(&lamps))
+
+	t.Run("reads the warehouse back", func(t *testing.T) {
+		savedProduct, err := repo.GetProduct("RETRO-CLOCK")
+		assert.Nil(t, err)
+
+		batch, _ := savedProduct.Batch("london-clock-batch")
+		assert.Equal(t, domain.Warehouse("london"), batch.Warehouse)
+	})
+
+	t.Run("lists the batches kept at a warehouse", func(t *testing.T) {
+		batches, err := repo.ListWarehouseBatches("leeds")
+		assert.Nil(t, err)
+
+		var refs []domain.Reference
+		for _, batch := range batches {
+			refs = append(refs, batch.Reference)
+		}
+		assert.Equal(t, []domain.Reference{"lamp-batch", "clock-batch"}, refs)
+		assert.True(t, batches[1].IsAllocated(orderLine))
+	})
+
+	t.Run("lists no batches for an unknown warehouse", func(t *testing.T) {
+		batches, err := repo.ListWarehouseBatches("dublin")
+		assert.Nil(t, err)
+		assert.Empty(t, batches)
+	})
+}
+
+func TestSQLRepository_SaveTransfer(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
 	}
 
-	err = repo.AddBatch(batch)
+	sku := domain.Sku("RETRO-CLOCK")
+	eta := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	orderLine := domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 30}
+	product := domain.NewProduct(sku, []domain.Batch{domain.NewWarehouseBatch("leeds-batch", sku, 50, time.Time{}, "leeds")})
+	_, err = product.Allocate(orderLine)
 	assert.Nil(t, err)
+	assert.Nil(t, repo.AddProduct(&product))
 
-	err = repo.AddOrderLine(orderLine)
+	t.Run("saves the transfer and the lines it moved", func(t *testing.T) {
+		storedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Nil(t, storedProduct.TransferStock("leeds-batch", "transfer-001", 40, "london", eta))
+		assert.Nil(t, repo.SaveProduct(storedProduct))
+
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+
+		source, _ := savedProduct.Batch("leeds-batch")
+		transfer, ok := savedProduct.Batch("transfer-001")
+		assert.True(t, ok)
+		assert.Equal(t, 10, source.Quantity)
+		assert.Equal(t, 40, transfer.Quantity)
+		assert.Equal(t, domain.Warehouse("london"), transfer.Warehouse)
+		assert.True(t, eta.Equal(transfer.ETA))
+		assert.True(t, transfer.IsAllocated(orderLine))
+	})
+
+	t.Run("saves nothing of a stale transfer", func(t *testing.T) {
+		staleProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		freshProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		assert.Nil(t, freshProduct.ChangeBatchQuantity("leeds-batch", 5))
+		assert.Nil(t, repo.SaveProduct(freshProduct))
+
+		assert.Nil(t, staleProduct.TransferStock("leeds-batch", "transfer-002", 10, "london", eta))
+		assert.ErrorAs(t, repo.SaveProduct(staleProduct), &domain.ConcurrencyError{})
+
+		savedProduct, err := repo.GetProduct(sku)
+		assert.Nil(t, err)
+		_, ok := savedProduct.Batch("transfer-002")
+		assert.False(t, ok)
+		source, _ := savedProduct.Batch("leeds-batch")
+		assert.Equal(t, 5, source.Quantity)
+	})
+}
+
+func TestSQLRepository_SaveStatus(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
-	err = repo.AllocateToBatch(batch, orderLine)
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	sku := domain.Sku("RETRO-CLOCK")
+	product := domain.NewProduct(sku, []domain.Batch{
+		domain.NewBatch("batch-001", sku, 20, time.Time{}),
+		domain.NewBatch("batch-002", sku, 20, time.Time{}),
+	})
+	_, err = product.ChangeBatchStatus("batch-001", domain.Quarantined)
 	assert.Nil(t, err)
+	assert.Nil(t, repo.AddProduct(&product))
 
-	allocatedBatch, err := repo.GetBatch(batch.Reference)
+	savedProduct, err := repo.GetProduct(sku)
 	assert.Nil(t, err)
 
-	assert.EqualExportedValues(t, allocatedBatch.Allocations.ToSlice()[0], orderLine)
+	quarantinedBatch, _ := savedProduct.Batch("batch-001")
+	availableBatch, _ := savedProduct.Batch("batch-002")
+	assert.Equal(t, domain.Quarantined, quarantinedBatch.Status)
+	assert.True(t, availableBatch.Status.IsAvailable())
 }
 
-func TestSQLRepository_DeallocateFromBatch(t *testing.T) {
+func TestSQLRepository_SaveUnits(t *testing.T) {
 	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
@@ -286,39 +759,144 @@ func TestSQLRepository_DeallocateFromBatch(t *testing.T) {
 		db: &DBWrapper{db},
 	}
 
-	sku := "LARGE-TABLE"
+	sku := domain.Sku("RETRO-CLOCK")
+	product := domain.NewProduct(sku, nil)
+	product.Units = domain.UnitsOfMeasure{Sku: sku, Base: "each", Factors: map[domain.Unit]int{"case": 12}}
+	assert.Nil(t, product.AddBatch(domain.Batch{Reference: "batch-001", Sku: sku, Quantity: 2, Unit: "case"}))
+	_, err = product.Allocate(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 1, Unit: "case"})
+	assert.Nil(t, err)
+	assert.Nil(t, repo.AddProduct(&product))
 
-	batch := domain.Batch{
-		Reference: "batch-012",
-		Sku:       domain.Sku(sku),
-		Quantity:  40,
-	}
+	savedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
 
-	orderLine := domain.OrderLine{
-		OrderID:  "order-321",
-		Quantity: 12,
-		Sku:      domain.Sku(sku),
+	batch, _ := savedProduct.Batch("batch-001")
+	assert.Equal(t, 24, batch.Quantity)
+	assert.Equal(t, domain.Unit("each"), batch.Unit)
+	assert.True(t, batch.IsAllocated(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 12, Unit: "each"}))
+}
+
+func TestSQLRepository_CancelOrder(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
 	}
 
-	err = repo.AddBatch(batch)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clocks := domain.NewProduct("RETRO-CLOCK", []domain.Batch{domain.NewBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{})})
+	clocks.Clock = func() time.Time { return now }
+	_, err = clocks.Allocate(domain.OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5})
+	assert.Nil(t, err)
+	_, err = clocks.Allocate(domain.OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 5})
 	assert.Nil(t, err)
+	assert.Nil(t, repo.AddProduct(&clocks))
 
-	err = repo.AddOrderLine(orderLine)
+	lamps := domain.NewProduct("BLUE-LAMP", []domain.Batch{domain.NewBatch("lamp-batch", "BLUE-LAMP", 20, time.Time{})})
+	_, err = lamps.Hold(domain.OrderLine{OrderID: "order-001", Sku: "BLUE-LAMP", Quantity: 5}, now.Add(time.Hour))
 	assert.Nil(t, err)
+	assert.Nil(t, lamps.Backorder(domain.OrderLine{OrderID: "order-003", Sku: "BLUE-LAMP", Quantity: 50}))
+	assert.Nil(t, repo.AddProduct(&lamps))
+
+	t.Run("lists the skus the order has allocated, held or backordered", func(t *testing.T) {
+		skus, err := repo.ListOrderSkus("order-001")
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.Sku{"BLUE-LAMP", "RETRO-CLOCK"}, skus)
 
-	err = repo.AllocateToBatch(batch, orderLine)
+		skus, err = repo.ListOrderSkus("order-003")
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.Sku{"BLUE-LAMP"}, skus)
+
+		skus, err = repo.ListOrderSkus("order-404")
+		assert.Nil(t, err)
+		assert.Empty(t, skus)
+	})
+
+	t.Run("marks the lines of the order as cancelled", func(t *testing.T) {
+		assert.Nil(t, repo.CancelOrderLines("order-001"))
+
+		var cancelled bool
+		assert.Nil(t, db.QueryRow(`SELECT cancelled FROM order_lines WHERE order_id=?`, "order-001").Scan(&cancelled))
+		assert.True(t, cancelled)
+		assert.Nil(t, db.QueryRow(`SELECT cancelled FROM order_lines WHERE order_id=?`, "order-002").Scan(&cancelled))
+		assert.False(t, cancelled)
+	})
+}
+
+func TestSQLRepository_SaveFlaggedRecall(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
-	allocatedBatch, err := repo.GetBatch(batch.Reference)
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	sku := domain.Sku("RETRO-CLOCK")
+	orderLine := domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 10}
+	product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 20, time.Time{})})
+	_, err = product.Allocate(orderLine)
 	assert.Nil(t, err)
+	_, err = product.Recall("batch-001", domain.FlagRecalled)
+	assert.Nil(t, err)
+	assert.Nil(t, repo.AddProduct(&product))
 
-	assert.EqualExportedValues(t, allocatedBatch.Allocations.ToSlice()[0], orderLine)
+	savedProduct, err := repo.GetProduct(sku)
+	assert.Nil(t, err)
+	assert.Nil(t, repo.SaveProduct(savedProduct))
 
-	err = repo.DeallocateFromBatch(allocatedBatch, orderLine)
+	reloadedProduct, err := repo.GetProduct(sku)
 	assert.Nil(t, err)
+	batch, _ := reloadedProduct.Batch("batch-001")
+	assert.Equal(t, domain.Recalled, batch.Status)
+	assert.Equal(t, []domain.OrderLine{orderLine}, batch.Allocations.ToSlice())
+}
 
-	deallocatedBatch, err := repo.GetBatch(batch.Reference)
+func TestSQLRepository_RecallReport(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
 	assert.Nil(t, err)
 
-	assert.Len(t, deallocatedBatch.Allocations.ToSlice(), 0)
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	repo := SQLRepository{
+		db: &DBWrapper{db},
+	}
+
+	report := domain.RecallReport{
+		RecallID:   "recall-001",
+		Policy:     domain.DeallocateRecalled,
+		RecalledAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Batches:    []domain.RecalledBatch{{Reference: "batch-002", Sku: "RETRO-CLOCK"}, {Reference: "batch-001", Sku: "BLUE-LAMP"}},
+		Lines: []domain.RecalledLine{
+			{OrderLine: domain.OrderLine{OrderID: "order-002", Sku: "RETRO-CLOCK", Quantity: 10, Priority: domain.Express}, BatchRef: "batch-002", ToBatchRef: "batch-003"},
+			{OrderLine: domain.OrderLine{OrderID: "order-001", Sku: "RETRO-CLOCK", Quantity: 5}, BatchRef: "batch-002"},
+		},
+	}
+
+	t.Run("reads the recall report back", func(t *testing.T) {
+		assert.Nil(t, repo.AddRecallReport(report))
+
+		savedReport, err := repo.GetRecallReport("recall-001")
+		assert.Nil(t, err)
+		assert.True(t, report.RecalledAt.Equal(savedReport.RecalledAt))
+		savedReport.RecalledAt = report.RecalledAt
+		assert.Equal(t, report, *savedReport)
+	})
+
+	t.Run("returns nil for an unknown recall", func(t *testing.T) {
+		savedReport, err := repo.GetRecallReport("recall-404")
+		assert.Nil(t, err)
+		assert.Nil(t, savedReport)
+	})
+
+	t.Run("returns error for a recall that already exists", func(t *testing.T) {
+		assert.Error(t, repo.AddRecallReport(report))
+	})
 }
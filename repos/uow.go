@@ -0,0 +1,85 @@
+package repos
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+)
+
+// SQLUnitOfWork runs each unit of work inside a single sql transaction.
+// The repository methods may only be used between Begin and Commit or Rollback,
+// the events of the products seen can be collected until the next Begin.
+type SQLUnitOfWork struct {
+	*SQLRepository
+	db *sql.DB
+	tx *sql.Tx
+	// mu is held for the lifetime of a transaction so that units of work do not interleave
+	mu sync.Mutex
+}
+
+func NewSqliteUnitOfWork(filepath string) (*SQLUnitOfWork, error) {
+	db, err := sql.Open("sqlite3", filepath)
+	if err != nil {
+		return &SQLUnitOfWork{}, fmt.Errorf("could not open sqlite filepath: %w", err)
+	}
+	return &SQLUnitOfWork{
+		db: db,
+	}, nil
+}
+
+// Begin opens a new transaction that the repository methods will run in
+func (u *SQLUnitOfWork) Begin() error {
+	u.mu.Lock()
+	tx, err := u.db.Begin()
+	if err != nil {
+		u.mu.Unlock()
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	u.tx = tx
+	u.SQLRepository = &SQLRepository{db: &TxWrapper{Tx: tx}}
+	return nil
+}
+
+// Commit persists everything done since Begin, the events of the products seen can be collected afterwards
+func (u *SQLUnitOfWork) Commit() error {
+	if u.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+	defer u.finish()
+	u.SQLRepository.forget()
+	if err := u.tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards everything done since Begin, including the events recorded.
+// It does nothing if the unit of work has already been committed.
+func (u *SQLUnitOfWork) Rollback() error {
+	if u.tx == nil {
+		return nil
+	}
+	defer u.finish()
+	u.SQLRepository.CollectNewEvents()
+	u.SQLRepository.forget()
+	if err := u.tx.Rollback(); err != nil {
+		return fmt.Errorf("could not rollback transaction: %w", err)
+	}
+	return nil
+}
+
+// CollectNewEvents pops the events recorded by the products seen in the last unit of work
+func (u *SQLUnitOfWork) CollectNewEvents() []domain.Event {
+	if u.SQLRepository == nil {
+		return nil
+	}
+	return u.SQLRepository.CollectNewEvents()
+}
+
+func (u *SQLUnitOfWork) finish() {
+	u.tx = nil
+	u.mu.Unlock()
+}
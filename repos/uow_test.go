@@ -0,0 +1,215 @@
+package repos
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLUnitOfWork(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+
+	sku := domain.Sku("LARGE-TABLE")
+
+	t.Run("can retrieve a product and allocate to it", func(t *testing.T) {
+		defer truncateTables(t, db)
+
+		insertProduct(t, db, sku)
+		insertBatch(t, db, "batch-001", sku, 100, time.Time{})
+
+		uow := SQLUnitOfWork{db: db}
+		assert.Nil(t, uow.Begin())
+
+		product, err := uow.GetProduct(sku)
+		assert.Nil(t, err)
+
+		_, err = product.Allocate(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 10})
+		assert.Nil(t, err)
+		assert.Nil(t, uow.SaveProduct(product))
+		assert.Nil(t, uow.Commit())
+
+		var batchRef domain.Reference
+		err = db.QueryRow(`SELECT batch_id FROM batches_order_lines WHERE order_id=?`, "order-001").Scan(&batchRef)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), batchRef)
+	})
+
+	t.Run("rolls back uncommitted work", func(t *testing.T) {
+		defer truncateTables(t, db)
+
+		uow := SQLUnitOfWork{db: db}
+		assert.Nil(t, uow.Begin())
+
+		product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 100, time.Time{})})
+		assert.Nil(t, uow.AddProduct(&product))
+		assert.Nil(t, uow.Rollback())
+
+		var count int
+		assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM batches`).Scan(&count))
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("rollback after commit does nothing", func(t *testing.T) {
+		defer truncateTables(t, db)
+
+		uow := SQLUnitOfWork{db: db}
+		assert.Nil(t, uow.Begin())
+
+		product := domain.NewProduct(sku, []domain.Batch{domain.NewBatch("batch-001", sku, 100, time.Time{})})
+		assert.Nil(t, uow.AddProduct(&product))
+		assert.Nil(t, uow.Commit())
+		assert.Nil(t, uow.Rollback())
+
+		var count int
+		assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM batches`).Scan(&count))
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("an allocation rolled back after saving leaves nothing behind", func(t *testing.T) {
+		defer truncateTables(t, db)
+
+		insertProduct(t, db, sku)
+		insertBatch(t, db, "batch-001", sku, 100, time.Time{})
+
+		uow := SQLUnitOfWork{db: db}
+		assert.Nil(t, uow.Begin())
+
+		product, err := uow.GetProduct(sku)
+		assert.Nil(t, err)
+
+		_, err = product.Allocate(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 10})
+		assert.Nil(t, err)
+		assert.Nil(t, uow.SaveProduct(product))
+		assert.Nil(t, uow.Rollback())
+
+		var count int
+		assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM order_lines`).Scan(&count))
+		assert.Equal(t, 0, count)
+		assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM batches_order_lines`).Scan(&count))
+		assert.Equal(t, 0, count)
+	})
+}
+
+func TestSQLUnitOfWork_ChangeBatchQuantity(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	sku := domain.Sku("LARGE-TABLE")
+	insertProduct(t, db, sku)
+	insertBatch(t, db, "batch-001", sku, 50, time.Time{})
+	insertBatch(t, db, "batch-002", sku, 50, time.Time{}.AddDate(0, 0, 1))
+	insertOrderLine(t, db, "order-001", sku, 30)
+	insertAllocation(t, db, "batch-001", "order-001")
+
+	uow := SQLUnitOfWork{db: db}
+	assert.Nil(t, uow.Begin())
+
+	product, err := uow.GetProductByBatchRef("batch-001")
+	assert.Nil(t, err)
+	assert.Nil(t, product.ChangeBatchQuantity("batch-001", 10))
+	assert.Nil(t, uow.SaveProduct(product))
+	assert.Nil(t, uow.Commit())
+
+	var batchRef domain.Reference
+	err = db.QueryRow(`SELECT batch_id FROM batches_order_lines WHERE order_id=?`, "order-001").Scan(&batchRef)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.Reference("batch-002"), batchRef)
+
+	var quantity int
+	err = db.QueryRow(`SELECT quantity FROM batches WHERE reference=?`, "batch-001").Scan(&quantity)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, quantity)
+}
+
+func TestSQLUnitOfWork_CollectNewEvents(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	sku := domain.Sku("LARGE-TABLE")
+	insertProduct(t, db, sku)
+	insertBatch(t, db, "batch-001", sku, 100, time.Time{})
+
+	uow := SQLUnitOfWork{db: db}
+	assert.Nil(t, uow.Begin())
+
+	product, err := uow.GetProduct(sku)
+	assert.Nil(t, err)
+
+	_, err = product.Allocate(domain.OrderLine{OrderID: "order-001", Sku: sku, Quantity: 10})
+	assert.Nil(t, err)
+	assert.Nil(t, uow.SaveProduct(product))
+	assert.Nil(t, uow.Commit())
+
+	assert.Equal(t, []domain.Event{domain.Allocated{OrderID: "order-001", Sku: sku, Quantity: 10, BatchRef: "batch-001"}}, uow.CollectNewEvents())
+	assert.Empty(t, uow.CollectNewEvents())
+
+	assert.Nil(t, uow.Begin())
+	product, err = uow.GetProduct(sku)
+	assert.Nil(t, err)
+
+	_, err = product.Allocate(domain.OrderLine{OrderID: "order-002", Sku: sku, Quantity: 10})
+	assert.Nil(t, err)
+	assert.Nil(t, uow.Rollback())
+
+	assert.Empty(t, uow.CollectNewEvents(), "events of rolled back work should be discarded")
+
+	t.Run("collects the events of products that were only read", func(t *testing.T) {
+		otherSku := domain.Sku("SMALL-TABLE")
+		insertProduct(t, db, otherSku)
+		insertBatch(t, db, "batch-002", otherSku, 5, time.Time{})
+
+		assert.Nil(t, uow.Begin())
+		unsaved, err := uow.GetProduct(otherSku)
+		assert.Nil(t, err)
+		_, err = unsaved.Allocate(domain.OrderLine{OrderID: "order-003", Sku: otherSku, Quantity: 10})
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+
+		product, err := uow.GetProduct(sku)
+		assert.Nil(t, err)
+		_, err = product.Allocate(domain.OrderLine{OrderID: "order-003", Sku: sku, Quantity: 10})
+		assert.Nil(t, err)
+		assert.Nil(t, uow.SaveProduct(product))
+		assert.Nil(t, uow.Commit())
+
+		assert.ElementsMatch(t, []domain.Event{
+			domain.OutOfStock{Sku: otherSku},
+			domain.Allocated{OrderID: "order-003", Sku: sku, Quantity: 10, BatchRef: "batch-001"},
+		}, uow.CollectNewEvents())
+	})
+}
+
+func TestSQLUnitOfWork_GetProductByBatchRef(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDBFile)
+	assert.Nil(t, err)
+
+	createTables(t, db)
+	defer truncateTables(t, db)
+
+	sku := domain.Sku("LARGE-TABLE")
+	insertProduct(t, db, sku)
+	insertBatch(t, db, "batch-001", sku, 100, time.Time{})
+
+	uow := SQLUnitOfWork{db: db}
+	assert.Nil(t, uow.Begin())
+	defer uow.Rollback()
+
+	product, err := uow.GetProductByBatchRef("batch-001")
+	assert.Nil(t, err)
+	assert.Equal(t, sku, product.Sku)
+
+	product, err = uow.GetProductByBatchRef("batch-404")
+	assert.Nil(t, err)
+	assert.Nil(t, product)
+}
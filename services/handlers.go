@@ -0,0 +1,87 @@
+package services
+
+import (
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/abbasegbeyemi/cosmic-python-go/messagebus"
+)
+
+// NewMessageBus returns a message bus that handles every stock command with a stock service running on the unit of work
+func NewMessageBus(uow UnitOfWork, options ...func(*StockService)) *messagebus.MessageBus {
+	bus := messagebus.New(uow)
+	service := NewStockService(uow, options...)
+	RegisterHandlers(bus, &service)
+	return bus
+}
+
+// RegisterHandlers routes the stock commands to the stock service
+func RegisterHandlers(bus *messagebus.MessageBus, service *StockService) {
+	messagebus.RegisterCommand(bus, func(c commands.CreateBatch) (any, error) {
+		batch := domain.NewPerishableBatch(c.Reference, c.Sku, c.Quantity, c.ETA, c.BestBefore)
+		batch.Unit = c.Unit
+		batch.Warehouse = c.Warehouse
+		return nil, service.AddStock(batch)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.Allocate) (any, error) {
+		orderLine := domain.OrderLine{OrderID: c.OrderID, Sku: c.Sku, Quantity: c.Quantity, Unit: c.Unit, Priority: c.Priority}
+		if service.IsBundle(c.Sku) {
+			return service.AllocateBundle(orderLine, c.Region)
+		}
+		return service.AllocateForCustomer(orderLine, c.CustomerID, c.Region)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.AllocatePreempting) (any, error) {
+		return service.AllocatePreemptingLine(domain.OrderLine{OrderID: c.OrderID, Sku: c.Sku, Quantity: c.Quantity, Unit: c.Unit, Priority: c.Priority})
+	})
+	messagebus.RegisterCommand(bus, func(c commands.AllocateSplit) (any, error) {
+		return service.AllocateSplitLine(domain.OrderLine{OrderID: c.OrderID, Sku: c.Sku, Quantity: c.Quantity, Unit: c.Unit, Priority: c.Priority})
+	})
+	messagebus.RegisterCommand(bus, func(c commands.AllocateOrder) (any, error) {
+		return service.AllocateOrder(domain.NewOrder(c.OrderID, c.Lines...), c.Mode)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.Hold) (any, error) {
+		return service.Hold(c.OrderID, c.Sku, c.Quantity, c.Duration)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.ConfirmHold) (any, error) {
+		return service.ConfirmHold(c.OrderID, c.Sku)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.ReleaseExpiredHolds) (any, error) {
+		return service.ReleaseExpiredHolds()
+	})
+	messagebus.RegisterCommand(bus, func(c commands.ReviewExpiry) (any, error) {
+		return nil, service.ReviewExpiry(c.Warning)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.QuarantineBatch) (any, error) {
+		return service.QuarantineBatch(c.Reference)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.ChangeBatchStatus) (any, error) {
+		return service.ChangeBatchStatus(c.Reference, c.Status)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.Recall) (any, error) {
+		return service.Recall(c.RecallID, c.BatchRefs, c.Policy)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.TransferStock) (any, error) {
+		return nil, service.TransferStock(c.Reference, c.TransferRef, c.Quantity, c.Destination, c.ETA)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.ChangeBatchQuantity) (any, error) {
+		return nil, service.ChangeBatchQuantity(c.Reference, c.Quantity)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.Deallocate) (any, error) {
+		orderLine := domain.OrderLine{OrderID: c.OrderID, Sku: c.Sku, Quantity: c.Quantity, Unit: c.Unit, Priority: c.Priority}
+		return nil, service.Deallocate(domain.Batch{Reference: c.BatchRef}, orderLine)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.AmendOrderLine) (any, error) {
+		return service.AmendOrderLine(domain.OrderLine{OrderID: c.OrderID, Sku: c.Sku, Quantity: c.Quantity, Unit: c.Unit})
+	})
+	messagebus.RegisterCommand(bus, func(c commands.Rebalance) (any, error) {
+		return service.Rebalance(c.Sku)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.CancelOrder) (any, error) {
+		return service.CancelOrder(c.OrderID)
+	})
+	messagebus.RegisterCommand(bus, func(c commands.DeallocateOrderLine) (any, error) {
+		if service.IsBundle(c.Sku) {
+			return service.DeallocateBundle(c.OrderID, c.Sku)
+		}
+		return service.DeallocateOrderLine(c.OrderID, c.Sku)
+	})
+}
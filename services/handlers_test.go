@@ -0,0 +1,455 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/abbasegbeyemi/cosmic-python-go/messagebus"
+	"github.com/abbasegbeyemi/cosmic-python-go/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordEvents registers a handler on the bus that records every event of type E
+func recordEvents[E domain.Event](bus *messagebus.MessageBus, events *[]domain.Event) {
+	messagebus.RegisterEvent(bus, func(event E) error {
+		*events = append(*events, event)
+		return nil
+	})
+}
+
+func TestHandlers_CreateBatch(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork()
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.BatchCreated](bus, &events)
+
+	_, err := bus.Handle(commands.CreateBatch{Reference: "batch-001", Sku: "LARGE-TABLE", Quantity: 30})
+	assert.Nil(t, err)
+
+	batch, err := uow.GetBatch("batch-001")
+	assert.Nil(t, err)
+	assert.Equal(t, 30, batch.Quantity)
+	assert.True(t, uow.Committed)
+	assert.Equal(t, []domain.Event{domain.BatchCreated{Reference: "batch-001", Sku: "LARGE-TABLE", Quantity: 30}}, events)
+}
+
+func TestHandlers_Allocate(t *testing.T) {
+	t.Run("returns the allocated batch and handles the allocated event", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 100, now.AddDate(0, 0, 2)))
+		bus := NewMessageBus(uow, WithClock(func() time.Time { return now }), WithLeadTime(domain.LeadTime{Sku: sku, Inbound: 24 * time.Hour}))
+
+		var events []domain.Event
+		recordEvents[domain.Allocated](bus, &events)
+
+		result, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: sku, Quantity: 12})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.AllocationResult{
+			BatchRef:   "batch-123",
+			Sku:        sku,
+			OrderedSku: sku,
+			Promise:    domain.Promise{BatchRef: "batch-123", ETA: now.AddDate(0, 0, 2), DispatchDate: now.AddDate(0, 0, 3)},
+		}, result)
+		assert.Equal(t, []domain.Event{domain.Allocated{OrderID: "order-1", Sku: sku, Quantity: 12, BatchRef: "batch-123"}}, events)
+	})
+
+	t.Run("returns a command error and handles the out of stock event", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 10, time.Time{}))
+		bus := NewMessageBus(uow)
+
+		var events []domain.Event
+		recordEvents[domain.OutOfStock](bus, &events)
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: sku, Quantity: 12})
+		assert.ErrorAs(t, err, &messagebus.CommandHandlerError{})
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+		assert.Equal(t, []domain.Event{domain.OutOfStock{Sku: sku}}, events)
+	})
+
+	t.Run("returns invalid sku error", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", "VALID-SKU", 10, time.Time{}))
+		bus := NewMessageBus(uow)
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "INVALID-SKU", Quantity: 12})
+		assert.ErrorIs(t, err, InvalidSkuError{sku: "INVALID-SKU"})
+	})
+}
+
+func TestHandlers_AllocateOrder(t *testing.T) {
+	lines := []domain.OrderLine{{Sku: "SMALL-TABLE", Quantity: 4}, {Sku: "BIG-LAMP", Quantity: 8}}
+
+	t.Run("handles no allocated events when the order is rolled back", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		bus := NewMessageBus(uow)
+
+		var events []domain.Event
+		recordEvents[domain.Allocated](bus, &events)
+
+		_, err := bus.Handle(commands.AllocateOrder{OrderID: "order-1", Lines: lines})
+		assert.ErrorAs(t, err, &OrderNotAllocatedError{})
+		assert.Empty(t, events)
+	})
+
+	t.Run("handles the allocated events of a best-effort order", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		bus := NewMessageBus(uow)
+
+		var events []domain.Event
+		recordEvents[domain.Allocated](bus, &events)
+
+		result, err := bus.Handle(commands.AllocateOrder{OrderID: "order-1", Lines: lines, Mode: domain.BestEffort})
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+		assert.Equal(t, []domain.Event{domain.Allocated{OrderID: "order-1", Sku: "SMALL-TABLE", Quantity: 4, BatchRef: "batch-001"}}, events)
+	})
+}
+
+func TestHandlers_Backorders(t *testing.T) {
+	sku := domain.Sku("MASSIVE-LAMP")
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 10, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.Backordered](bus, &events)
+	recordEvents[domain.BackorderFilled](bus, &events)
+
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: sku, Quantity: 12})
+	assert.ErrorAs(t, err, &BackorderedError{})
+
+	_, err = bus.Handle(commands.CreateBatch{Reference: "batch-002", Sku: sku, Quantity: 20})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []domain.Event{
+		domain.Backordered{OrderID: "order-1", Sku: sku, Quantity: 12},
+		domain.BackorderFilled{OrderID: "order-1", Sku: sku, Quantity: 12, BatchRef: "batch-002"},
+	}, events)
+}
+
+func TestHandlers_AllocatePreempting(t *testing.T) {
+	sku := domain.Sku("MASSIVE-LAMP")
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("in-stock-batch", sku, 10, time.Time{}),
+		repos.WithBatch("shipment-batch", sku, 10, time.Time{}.AddDate(0, 1, 0)),
+	)
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.Preempted](bus, &events)
+
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: sku, Quantity: 8, Priority: domain.Bulk})
+	assert.Nil(t, err)
+
+	result, err := bus.Handle(commands.AllocatePreempting{OrderID: "order-2", Sku: sku, Quantity: 5, Priority: domain.Express})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.Reference("in-stock-batch"), result.(domain.PreemptiveAllocation).BatchRef)
+	assert.Equal(t, []domain.Event{
+		domain.Preempted{OrderID: "order-1", Sku: sku, Quantity: 8, BatchRef: "in-stock-batch", PreemptedBy: "order-2"},
+	}, events)
+}
+
+func TestHandlers_QuarantineBatch(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 50, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.BatchStatusChanged](bus, &events)
+
+	_, err := bus.Handle(commands.QuarantineBatch{Reference: "batch-001"})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.Event{domain.BatchStatusChanged{Reference: "batch-001", Sku: "RETRO-CLOCK", Status: domain.Quarantined}}, events)
+}
+
+func TestHandlers_Recall(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 50, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.OrderLineRecalled](bus, &events)
+
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 10})
+	assert.Nil(t, err)
+	_, err = bus.Handle(commands.Recall{RecallID: "recall-001", BatchRefs: []domain.Reference{"batch-001"}, Policy: domain.FlagRecalled})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.Event{domain.OrderLineRecalled{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "batch-001", Policy: domain.FlagRecalled}}, events)
+}
+
+func TestHandlers_TransferStock(t *testing.T) {
+	eta := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 50, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.StockTransferred](bus, &events)
+
+	_, err := bus.Handle(commands.TransferStock{Reference: "batch-001", TransferRef: "transfer-001", Quantity: 20, Destination: "london", ETA: eta})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.Event{domain.StockTransferred{
+		FromBatchRef: "batch-001", BatchRef: "transfer-001", Sku: "RETRO-CLOCK", Quantity: 20, Destination: "london", ETA: eta,
+	}}, events)
+}
+
+func TestHandlers_Units(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork()
+	bus := NewMessageBus(uow, WithUnitsOfMeasure(domain.UnitsOfMeasure{Sku: "RETRO-CLOCK", Base: "each", Factors: map[domain.Unit]int{"case": 12}}))
+
+	_, err := bus.Handle(commands.CreateBatch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"})
+	assert.Nil(t, err)
+	_, err = bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case"})
+	assert.Nil(t, err)
+	_, err = bus.Handle(commands.Deallocate{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 12, Unit: "each", BatchRef: "batch-001"})
+	assert.Nil(t, err)
+
+	batch, _ := uow.GetBatch("batch-001")
+	assert.Equal(t, 24, batch.AvailableQuantity())
+}
+
+func TestHandlers_UnitsAndPriorities(t *testing.T) {
+	clockUnits := domain.UnitsOfMeasure{Sku: "RETRO-CLOCK", Base: "each", Factors: map[domain.Unit]int{"case": 12}}
+
+	t.Run("splits a line ordered in cases keeping its priority", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "RETRO-CLOCK", 24, time.Time{}),
+			repos.WithBatch("batch-002", "RETRO-CLOCK", 24, time.Time{}.AddDate(0, 1, 0)),
+		)
+		bus := NewMessageBus(uow, WithUnitsOfMeasure(clockUnits))
+
+		allocations, err := bus.Handle(commands.AllocateSplit{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 3, Unit: "case", Priority: domain.Express})
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.BatchAllocation{{BatchRef: "batch-001", Quantity: 24}, {BatchRef: "batch-002", Quantity: 12}}, allocations)
+
+		batch, _ := uow.GetBatch("batch-002")
+		allocated, _ := batch.AllocationOf("order-1", "RETRO-CLOCK")
+		assert.Equal(t, domain.Express, allocated.Priority)
+	})
+
+	t.Run("pre-empts with a line ordered in cases", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 24, time.Time{}))
+		bus := NewMessageBus(uow, WithUnitsOfMeasure(clockUnits))
+
+		_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 20, Priority: domain.Bulk})
+		assert.Nil(t, err)
+		_, err = bus.Handle(commands.AllocatePreempting{OrderID: "order-2", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case", Priority: domain.Express})
+		assert.Nil(t, err)
+
+		batch, _ := uow.GetBatch("batch-001")
+		allocated, _ := batch.AllocationOf("order-2", "RETRO-CLOCK")
+		assert.Equal(t, domain.OrderLine{OrderID: "order-2", Sku: "RETRO-CLOCK", Quantity: 12, Unit: "each", Priority: domain.Express}, allocated)
+	})
+}
+
+func TestHandlers_Bundles(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+		repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+	)
+	giftSet := domain.Bundle{Sku: "GIFT-SET", Components: []domain.BundleComponent{{Sku: "RETRO-CLOCK", Quantity: 1}, {Sku: "BLUE-LAMP", Quantity: 2}}}
+	bus := NewMessageBus(uow, WithBundle(giftSet))
+
+	result, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "GIFT-SET", Quantity: 2})
+	assert.Nil(t, err)
+	assert.Len(t, result, 2)
+
+	result, err = bus.Handle(commands.DeallocateOrderLine{OrderID: "order-1", Sku: "GIFT-SET"})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.LineAllocation{
+		{Sku: "RETRO-CLOCK", Quantity: 2, BatchRef: "clock-batch"},
+		{Sku: "BLUE-LAMP", Quantity: 4, BatchRef: "lamp-batch"},
+	}, result)
+}
+
+func TestHandlers_Substitutions(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("retro-batch", "RETRO-CLOCK", 5, time.Time{}),
+		repos.WithBatch("modern-batch", "MODERN-CLOCK", 20, time.Time{}),
+	)
+	bus := NewMessageBus(uow, WithSubstitutes("RETRO-CLOCK", "MODERN-CLOCK"), WithSubstitutionOptIn("customer-1", "RETRO-CLOCK"))
+
+	var events []domain.Event
+	recordEvents[domain.OrderLineSubstituted](bus, &events)
+
+	result, err := bus.Handle(commands.Allocate{OrderID: "order-1", CustomerID: "customer-1", Sku: "RETRO-CLOCK", Quantity: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.Reference("modern-batch"), result.(domain.AllocationResult).BatchRef)
+	assert.True(t, result.(domain.AllocationResult).Substituted())
+	assert.Equal(t, []domain.Event{domain.OrderLineSubstituted{
+		OrderID:       "order-1",
+		CustomerID:    "customer-1",
+		Sku:           "RETRO-CLOCK",
+		SubstituteSku: "MODERN-CLOCK",
+		Quantity:      10,
+		BatchRef:      "modern-batch",
+	}}, events)
+}
+
+func TestHandlers_CancelOrder(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 20, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.OrderCancelled](bus, &events)
+
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 10})
+	assert.Nil(t, err)
+
+	result, err := bus.Handle(commands.CancelOrder{OrderID: "order-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.LineAllocation{{Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "batch-001"}}, result)
+	assert.Equal(t, []domain.Event{domain.OrderCancelled{OrderID: "order-1", Sku: "RETRO-CLOCK"}}, events)
+}
+
+func TestHandlers_AmendOrderLine(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "RETRO-CLOCK", 20, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.OrderLineAmended](bus, &events)
+
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 10})
+	assert.Nil(t, err)
+
+	result, err := bus.Handle(commands.AmendOrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 12})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.Reference("batch-001"), result)
+	assert.Equal(t, []domain.Event{domain.OrderLineAmended{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 12, BatchRef: "batch-001"}}, events)
+}
+
+func TestHandlers_Rebalance(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("batch-001", "RETRO-CLOCK", 10, time.Time{}),
+		repos.WithBatch("batch-002", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+	)
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.StockRebalanced](bus, &events)
+
+	for _, orderLine := range []commands.Allocate{
+		{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 3},
+		{OrderID: "order-2", Sku: "RETRO-CLOCK", Quantity: 3},
+		{OrderID: "order-3", Sku: "RETRO-CLOCK", Quantity: 7},
+	} {
+		_, err := bus.Handle(orderLine)
+		assert.Nil(t, err)
+	}
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-4", Sku: "RETRO-CLOCK", Quantity: 6})
+	assert.ErrorAs(t, err, &BackorderedError{})
+
+	result, err := bus.Handle(commands.Rebalance{Sku: "RETRO-CLOCK"})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, result.(domain.RebalancePlan).Fulfilled())
+	assert.Equal(t, []domain.Event{domain.StockRebalanced{Sku: "RETRO-CLOCK", Fulfilled: 4, Moved: 2}}, events)
+}
+
+func TestHandlers_ReviewExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	uow := repos.NewFakeUnitOfWork()
+	bus := NewMessageBus(uow, WithClock(func() time.Time { return now }))
+
+	var events []domain.Event
+	recordEvents[domain.BatchExpiring](bus, &events)
+
+	_, err := bus.Handle(commands.CreateBatch{Reference: "batch-001", Sku: "FRESH-MILK", Quantity: 20, BestBefore: now.AddDate(0, 0, 2)})
+	assert.Nil(t, err)
+
+	_, err = bus.Handle(commands.ReviewExpiry{})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.Event{domain.BatchExpiring{Reference: "batch-001", Sku: "FRESH-MILK", BestBefore: now.AddDate(0, 0, 2)}}, events)
+}
+
+func TestHandlers_ChangeBatchQuantity(t *testing.T) {
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "LARGE-TABLE", 30, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.BatchQuantityChanged](bus, &events)
+
+	_, err := bus.Handle(commands.ChangeBatchQuantity{Reference: "batch-001", Quantity: 20})
+	assert.Nil(t, err)
+
+	batch, _ := uow.GetBatch("batch-001")
+	assert.Equal(t, 20, batch.Quantity)
+	assert.Equal(t, []domain.Event{domain.BatchQuantityChanged{Reference: "batch-001", Sku: "LARGE-TABLE", Quantity: 20}}, events)
+}
+
+func TestHandlers_ChangeBatchQuantityReallocates(t *testing.T) {
+	sku := domain.Sku("INDIFFERENT-TABLE")
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("batch-001", sku, 50, time.Time{}),
+		repos.WithBatch("batch-002", sku, 50, time.Time{}.AddDate(0, 0, 1)),
+	)
+	bus := NewMessageBus(uow)
+
+	for _, orderID := range []domain.Reference{"order-001", "order-002"} {
+		result, err := bus.Handle(commands.Allocate{OrderID: orderID, Sku: sku, Quantity: 20})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), result.(domain.AllocationResult).BatchRef)
+	}
+
+	var events []domain.Event
+	recordEvents[domain.Deallocated](bus, &events)
+	recordEvents[domain.Allocated](bus, &events)
+
+	_, err := bus.Handle(commands.ChangeBatchQuantity{Reference: "batch-001", Quantity: 25})
+	assert.Nil(t, err)
+
+	batch1, _ := uow.GetBatch("batch-001")
+	batch2, _ := uow.GetBatch("batch-002")
+	assert.Equal(t, 20, batch1.AllocatedQuantity())
+	assert.Equal(t, 20, batch2.AllocatedQuantity())
+	assert.Equal(t, []domain.Event{
+		domain.Deallocated{OrderID: "order-001", Sku: sku, Quantity: 20, BatchRef: "batch-001"},
+		domain.Allocated{OrderID: "order-001", Sku: sku, Quantity: 20, BatchRef: "batch-002"},
+	}, events)
+}
+
+func TestHandlers_Deallocate(t *testing.T) {
+	sku := domain.Sku("DISCONTINUED-LAMP")
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 30, time.Time{}))
+	bus := NewMessageBus(uow)
+
+	var events []domain.Event
+	recordEvents[domain.Deallocated](bus, &events)
+
+	_, err := bus.Handle(commands.Allocate{OrderID: "order-1", Sku: sku, Quantity: 10})
+	assert.Nil(t, err)
+
+	_, err = bus.Handle(commands.Deallocate{OrderID: "order-1", Sku: sku, Quantity: 10, BatchRef: "batch-123"})
+	assert.Nil(t, err)
+
+	batch, _ := uow.GetBatch("batch-123")
+	assert.Equal(t, 30, batch.AvailableQuantity())
+	assert.Equal(t, []domain.Event{domain.Deallocated{OrderID: "order-1", Sku: sku, Quantity: 10, BatchRef: "batch-123"}}, events)
+}
+
+func TestHandlers_AllocateSplit(t *testing.T) {
+	sku := domain.Sku("MASSIVE-LAMP")
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("batch-001", sku, 20, time.Time{}),
+		repos.WithBatch("batch-002", sku, 20, time.Time{}.AddDate(0, 1, 0)),
+	)
+	bus := NewMessageBus(uow)
+
+	allocations, err := bus.Handle(commands.AllocateSplit{OrderID: "order-1", Sku: sku, Quantity: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.BatchAllocation{{BatchRef: "batch-001", Quantity: 20}, {BatchRef: "batch-002", Quantity: 10}}, allocations)
+
+	deallocated, err := bus.Handle(commands.DeallocateOrderLine{OrderID: "order-1", Sku: sku})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []domain.BatchAllocation{{BatchRef: "batch-001", Quantity: 20}, {BatchRef: "batch-002", Quantity: 10}}, deallocated)
+
+	batch1, _ := uow.GetBatch("batch-001")
+	batch2, _ := uow.GetBatch("batch-002")
+	assert.Equal(t, 0, batch1.AllocatedQuantity())
+	assert.Equal(t, 0, batch2.AllocatedQuantity())
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"slices"
 	"time"
@@ -9,75 +10,1281 @@ import (
 )
 
 type Repository interface {
-	AddBatch(domain.Batch) error
-	ListBatches() ([]domain.Batch, error)
-	GetBatch(reference domain.Reference) (domain.Batch, error)
-	AllocateToBatch(domain.Batch, domain.OrderLine) error
-	DeallocateFromBatch(domain.Batch, domain.OrderLine) error
-	AddOrderLine(domain.OrderLine) error
+	AddProduct(*domain.Product) error
+	// GetProduct returns the product with all its batches, or nil if the sku is unknown
+	GetProduct(sku domain.Sku) (*domain.Product, error)
+	// GetProductByBatchRef returns the product the batch belongs to, or nil if the batch is unknown
+	GetProductByBatchRef(reference domain.Reference) (*domain.Product, error)
+	SaveProduct(*domain.Product) error
+	// ListSkusWithExpiredHolds returns the skus of the products holding stock for holds that have expired by now
+	ListSkusWithExpiredHolds(now time.Time) ([]domain.Sku, error)
+	// ListSkusWithBatchesExpiringBy returns the skus of the products with perishable batches that expire by the given time
+	ListSkusWithBatchesExpiringBy(by time.Time) ([]domain.Sku, error)
+	// ListOrderSkus returns the skus the order has lines of that are allocated, held or backordered, sorted by sku
+	ListOrderSkus(orderID domain.Reference) ([]domain.Sku, error)
+	// CancelOrderLines marks the order lines of the order as cancelled
+	CancelOrderLines(orderID domain.Reference) error
+	// ListWarehouseBatches returns the batches kept at the warehouse, sorted by sku and reference
+	ListWarehouseBatches(warehouse domain.Warehouse) ([]domain.Batch, error)
+	AddRecallReport(report domain.RecallReport) error
+	// GetRecallReport returns the report of the recall, or nil if the recall is unknown
+	GetRecallReport(recallID domain.Reference) (*domain.RecallReport, error)
 }
 
+// UnitOfWork groups the repository calls of a use case so that they are committed or rolled back together
+type UnitOfWork interface {
+	Repository
+	Begin() error
+	Commit() error
+	Rollback() error
+	// CollectNewEvents pops the events recorded by the products seen in the unit of work
+	CollectNewEvents() []domain.Event
+}
+
+// DefaultHoldDuration is how long a hold reserves stock when no duration is given
+const DefaultHoldDuration = 15 * time.Minute
+
+// DefaultExpiryWarning is how long before its best before date a batch is flagged as expiring when no warning is given
+const DefaultExpiryWarning = 72 * time.Hour
+
 type StockService struct {
-	repo Repository
+	uow           UnitOfWork
+	strategy      domain.AllocationStrategy
+	skuStrategies map[domain.Sku]domain.AllocationStrategy
+	units         map[domain.Sku]domain.UnitsOfMeasure
+	leadTimes     map[domain.Sku]domain.LeadTime
+	bundles       map[domain.Sku]domain.Bundle
+	substitutions domain.Substitutions
+	preferences   domain.WarehousePreferences
+	clock         func() time.Time
+}
+
+func NewStockService(uow UnitOfWork, options ...func(*StockService)) StockService {
+	service := StockService{
+		uow:           uow,
+		skuStrategies: make(map[domain.Sku]domain.AllocationStrategy),
+		units:         make(map[domain.Sku]domain.UnitsOfMeasure),
+		leadTimes:     make(map[domain.Sku]domain.LeadTime),
+		bundles:       make(map[domain.Sku]domain.Bundle),
+		substitutions: domain.Substitutions{
+			Substitutes: make(map[domain.Sku][]domain.Sku),
+			OptIns:      make(map[domain.Reference][]domain.Sku),
+		},
+	}
+	for _, o := range options {
+		o(&service)
+	}
+	return service
+}
+
+// WithAllocationStrategy sets the strategy used to allocate every sku without a strategy of its own
+func WithAllocationStrategy(strategy domain.AllocationStrategy) func(*StockService) {
+	return func(s *StockService) {
+		s.strategy = strategy
+	}
+}
+
+// WithSkuAllocationStrategy sets the strategy used to allocate a single sku
+func WithSkuAllocationStrategy(sku domain.Sku, strategy domain.AllocationStrategy) func(*StockService) {
+	return func(s *StockService) {
+		s.skuStrategies[sku] = strategy
+	}
 }
 
-func NewStockService(repo Repository) StockService {
-	return StockService{
-		repo: repo,
+// WithUnitsOfMeasure sets the units the sku of the units of measure can be counted in
+func WithUnitsOfMeasure(units domain.UnitsOfMeasure) func(*StockService) {
+	return func(s *StockService) {
+		s.units[units.Sku] = units
 	}
 }
+
+// WithLeadTime sets how long it takes to dispatch the sku of the lead time once its stock is available
+func WithLeadTime(leadTime domain.LeadTime) func(*StockService) {
+	return func(s *StockService) {
+		s.leadTimes[leadTime.Sku] = leadTime
+	}
+}
+
+// WithBundle sets the components the sku of the bundle is made up of
+func WithBundle(bundle domain.Bundle) func(*StockService) {
+	return func(s *StockService) {
+		s.bundles[bundle.Sku] = bundle
+	}
+}
+
+// WithSubstitutes sets the skus, in order of preference, that may be allocated in place of the sku when it is out of stock
+func WithSubstitutes(sku domain.Sku, substitutes ...domain.Sku) func(*StockService) {
+	return func(s *StockService) {
+		s.substitutions.Substitutes[sku] = substitutes
+	}
+}
+
+// WithSubstitutionOptIn opts the customer in to substitutes for the skus, or for every sku when none are given
+func WithSubstitutionOptIn(customer domain.Reference, skus ...domain.Sku) func(*StockService) {
+	return func(s *StockService) {
+		s.substitutions.OptIns[customer] = skus
+	}
+}
+
+// WithWarehousePreferences sets the warehouses that order lines are allocated from first for each delivery region
+func WithWarehousePreferences(preferences domain.WarehousePreferences) func(*StockService) {
+	return func(s *StockService) {
+		s.preferences = preferences
+	}
+}
+
+// WithClock sets the clock used to time holds, time.Now is used by default
+func WithClock(clock func() time.Time) func(*StockService) {
+	return func(s *StockService) {
+		s.clock = clock
+	}
+}
+
 func (s *StockService) AddBatch(reference domain.Reference, sku domain.Sku, quantity int, eta time.Time) error {
-	return s.repo.AddBatch(domain.NewBatch(reference, sku, quantity, eta))
+	return s.AddStock(domain.NewBatch(reference, sku, quantity, eta))
+}
+
+// AddStock adds the batch to its product, creating the product for a new sku.
+// A batch delivered in another unit than the base unit of the sku is kept in the base unit.
+func (s *StockService) AddStock(batch domain.Batch) error {
+	if err := s.uow.Begin(); err != nil {
+		return fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(batch.Sku)
+	if err != nil {
+		return fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		newProduct := domain.NewProduct(batch.Sku, nil)
+		s.configure(&newProduct)
+		if err = newProduct.AddBatch(batch); err != nil {
+			return fmt.Errorf("could not add batch to product: %w", err)
+		}
+		if err = s.uow.AddProduct(&newProduct); err != nil {
+			return fmt.Errorf("could not add product: %w", err)
+		}
+		return s.commit()
+	}
+	s.configure(product)
+
+	if err = product.AddBatch(batch); err != nil {
+		return fmt.Errorf("could not add batch to product: %w", err)
+	}
+	if err = s.uow.SaveProduct(product); err != nil {
+		return fmt.Errorf("could not save product: %w", err)
+	}
+	return s.commit()
 }
 
 func (s *StockService) Allocate(orderId domain.Reference, sku domain.Sku, quantity int) (domain.Reference, error) {
-	batches, err := s.repo.ListBatches()
+	return s.AllocateLine(domain.OrderLine{OrderID: orderId, Sku: sku, Quantity: quantity}, "")
+}
+
+// AllocateLine allocates the order line like Allocate, keeping its priority so that it can be pre-empted by lines of
+// a higher priority and preferring the warehouses nearest the delivery region. An empty or unknown region has no
+// preferred warehouses. The line may be ordered in any unit of its sku.
+// A bundle has no batch of its own, so a line of a bundle is refused with a BundleLineError.
+func (s *StockService) AllocateLine(orderLine domain.OrderLine, region domain.Region) (domain.Reference, error) {
+	if !orderLine.Priority.IsValid() {
+		return "", fmt.Errorf("unknown priority %q", orderLine.Priority)
+	}
+	if s.IsBundle(orderLine.Sku) {
+		return "", BundleLineError{Sku: orderLine.Sku}
+	}
+	result, err := s.allocateLine(orderLine, region)
+	return result.BatchRef, err
+}
+
+// allocateLine allocates the order line of a sku that is not a bundle and promises when it can be dispatched
+func (s *StockService) allocateLine(orderLine domain.OrderLine, region domain.Region) (domain.AllocationResult, error) {
+	if err := s.uow.Begin(); err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(orderLine.Sku)
+	if err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return domain.AllocationResult{}, InvalidSkuError{sku: orderLine.Sku}
+	}
+	s.configure(product)
+	s.preferRegion(product, region)
+
+	batchRef, err := product.Allocate(orderLine)
+	if errors.As(err, &domain.OutOfStockError{}) {
+		return domain.AllocationResult{}, s.backorder(product, orderLine, err)
+	}
+	if err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not allocate order line to any batch: %w", err)
+	}
+	promise, err := product.Promise(batchRef)
+	if err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not promise order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not persist order line allocation: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return domain.AllocationResult{}, err
+	}
+	return domain.AllocationResult{BatchRef: batchRef, Sku: orderLine.Sku, OrderedSku: orderLine.Sku, Promise: promise}, nil
+}
+
+// AllocateForCustomer allocates the customer's order line like AllocateLine. When the sku is out of stock, the first
+// substitute the customer accepts that can take the line is allocated in its place, the line is only backordered
+// when none can. The result promises when the line can be dispatched. A line of a bundle is refused like AllocateLine.
+func (s *StockService) AllocateForCustomer(orderLine domain.OrderLine, customer domain.Reference, region domain.Region) (domain.AllocationResult, error) {
+	if !orderLine.Priority.IsValid() {
+		return domain.AllocationResult{}, fmt.Errorf("unknown priority %q", orderLine.Priority)
+	}
+	if s.IsBundle(orderLine.Sku) {
+		return domain.AllocationResult{}, BundleLineError{Sku: orderLine.Sku}
+	}
+	result := domain.AllocationResult{Sku: orderLine.Sku, OrderedSku: orderLine.Sku}
+	substitutes := s.substitutions.For(customer, orderLine.Sku)
+	if len(substitutes) == 0 {
+		return s.allocateLine(orderLine, region)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(orderLine.Sku)
+	if err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return domain.AllocationResult{}, InvalidSkuError{sku: orderLine.Sku}
+	}
+	s.configure(product)
+	s.preferRegion(product, region)
+
+	result.BatchRef, err = product.Allocate(orderLine)
+	if errors.As(err, &domain.OutOfStockError{}) {
+		outOfStock := err
+		for _, sku := range substitutes {
+			substitute, err := s.uow.GetProduct(sku)
+			if err != nil {
+				return domain.AllocationResult{}, fmt.Errorf("could not get product: %w", err)
+			}
+			if substitute == nil {
+				continue
+			}
+			s.configure(substitute)
+			s.preferRegion(substitute, region)
+
+			batchRef, err := substitute.AllocateSubstitute(orderLine, customer)
+			if errors.As(err, &domain.OutOfStockError{}) || errors.As(err, &domain.UnitConversionError{}) {
+				continue
+			}
+			if err != nil {
+				return domain.AllocationResult{}, fmt.Errorf("could not allocate substitute %s: %w", sku, err)
+			}
+			result.BatchRef, result.Sku, product = batchRef, sku, substitute
+			break
+		}
+		if !result.Substituted() {
+			return domain.AllocationResult{}, s.backorder(product, orderLine, outOfStock)
+		}
+	} else if err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not allocate order line to any batch: %w", err)
+	}
+	if result.Promise, err = product.Promise(result.BatchRef); err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not promise order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return domain.AllocationResult{}, fmt.Errorf("could not persist order line allocation: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return domain.AllocationResult{}, err
+	}
+	return result, nil
+}
+
+// IsBundle returns true if the sku is a bundle made up of other skus
+func (s *StockService) IsBundle(sku domain.Sku) bool {
+	_, ok := s.bundles[sku]
+	return ok
+}
+
+// AllocateBundle allocates a line of every component of the bundle in one unit of work,
+// no component is allocated unless they all can be
+func (s *StockService) AllocateBundle(orderLine domain.OrderLine, region domain.Region) ([]domain.LineAllocation, error) {
+	bundle, err := s.bundle(orderLine.Sku)
+	if err != nil {
+		return nil, err
+	}
+	if !orderLine.Priority.IsValid() {
+		return nil, fmt.Errorf("unknown priority %q", orderLine.Priority)
+	}
+	lines, err := bundle.Lines(orderLine)
+	if err != nil {
+		return nil, fmt.Errorf("could not allocate bundle: %w", err)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	products := make([]*domain.Product, 0, len(lines))
+	allocations := make([]domain.LineAllocation, 0, len(lines))
+	for _, line := range lines {
+		product, err := s.uow.GetProduct(line.Sku)
+		if err != nil {
+			return nil, fmt.Errorf("could not get product: %w", err)
+		}
+		if product == nil {
+			return nil, InvalidSkuError{sku: line.Sku}
+		}
+		s.configure(product)
+		s.preferRegion(product, region)
+
+		// Leaving the unit of work uncommitted rolls back the components that were allocated
+		batchRef, err := product.Allocate(line)
+		if err != nil {
+			return nil, fmt.Errorf("could not allocate component %s of bundle %s: %w", line.Sku, bundle.Sku, err)
+		}
+		products = append(products, product)
+		allocations = append(allocations, domain.LineAllocation{Sku: line.Sku, Quantity: line.Quantity, BatchRef: batchRef})
+	}
+
+	for _, product := range products {
+		if err := s.uow.SaveProduct(product); err != nil {
+			return nil, fmt.Errorf("could not persist order line allocation: %w", err)
+		}
+	}
+
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
+
+// AllocatePreempting allocates the order line to warehouse stock, displacing lines of a lower priority to shipments
+// when there is no room for it
+func (s *StockService) AllocatePreempting(orderId domain.Reference, sku domain.Sku, quantity int, priority domain.Priority) (domain.PreemptiveAllocation, error) {
+	return s.AllocatePreemptingLine(domain.OrderLine{OrderID: orderId, Sku: sku, Quantity: quantity, Priority: priority})
+}
+
+// AllocatePreemptingLine allocates the order line like AllocatePreempting, the line may be ordered in any unit of its sku
+func (s *StockService) AllocatePreemptingLine(orderLine domain.OrderLine) (domain.PreemptiveAllocation, error) {
+	if !orderLine.Priority.IsValid() {
+		return domain.PreemptiveAllocation{}, fmt.Errorf("unknown priority %q", orderLine.Priority)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return domain.PreemptiveAllocation{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(orderLine.Sku)
+	if err != nil {
+		return domain.PreemptiveAllocation{}, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return domain.PreemptiveAllocation{}, InvalidSkuError{sku: orderLine.Sku}
+	}
+	s.configure(product)
+
+	allocation, err := product.AllocatePreempting(orderLine)
+	if errors.As(err, &domain.OutOfStockError{}) {
+		return domain.PreemptiveAllocation{}, s.backorder(product, orderLine, err)
+	}
+	if err != nil {
+		return domain.PreemptiveAllocation{}, fmt.Errorf("could not allocate order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return domain.PreemptiveAllocation{}, fmt.Errorf("could not persist order line allocation: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return domain.PreemptiveAllocation{}, err
+	}
+	return allocation, nil
+}
+
+// AllocateSplit allocates the order line to a single batch if possible, otherwise splits it across several batches
+func (s *StockService) AllocateSplit(orderId domain.Reference, sku domain.Sku, quantity int) ([]domain.BatchAllocation, error) {
+	return s.AllocateSplitLine(domain.OrderLine{OrderID: orderId, Sku: sku, Quantity: quantity})
+}
+
+// AllocateSplitLine allocates the order line like AllocateSplit, keeping its priority and unit
+func (s *StockService) AllocateSplitLine(orderLine domain.OrderLine) ([]domain.BatchAllocation, error) {
+	if !orderLine.Priority.IsValid() {
+		return nil, fmt.Errorf("unknown priority %q", orderLine.Priority)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(orderLine.Sku)
+	if err != nil {
+		return nil, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return nil, InvalidSkuError{sku: orderLine.Sku}
+	}
+	s.configure(product)
+
+	allocations, err := product.AllocateSplit(orderLine)
+	if errors.As(err, &domain.OutOfStockError{}) {
+		return s.backorderSplit(product, orderLine, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not allocate order line across batches: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return nil, fmt.Errorf("could not persist order line allocation: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
+
+// AllocateOrder allocates every line of the order in one unit of work.
+// In the all-or-nothing mode no line is allocated unless they all can be, in the best-effort mode
+// every line that can be allocated is, the lines that are out of stock are backordered
+// and the lines that could not be allocated carry the reason why.
+func (s *StockService) AllocateOrder(order domain.Order, mode domain.AllocationMode) ([]domain.LineAllocation, error) {
+	if err := order.Validate(); err != nil {
+		return nil, fmt.Errorf("could not allocate order: %w", err)
+	}
+	if mode == "" {
+		mode = domain.AllOrNothing
+	}
+	if mode != domain.AllOrNothing && mode != domain.BestEffort {
+		return nil, fmt.Errorf("unknown allocation mode %q", mode)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	var changed []*domain.Product
+	var allocated int
+	results := make([]domain.LineAllocation, len(order.Lines))
+	for i, orderLine := range order.Lines {
+		results[i] = domain.LineAllocation{Sku: orderLine.Sku, Quantity: orderLine.Quantity}
+
+		product, err := s.uow.GetProduct(orderLine.Sku)
+		if err != nil {
+			return nil, fmt.Errorf("could not get product: %w", err)
+		}
+		if product == nil {
+			results[i].Err = InvalidSkuError{sku: orderLine.Sku}
+			continue
+		}
+		s.configure(product)
+
+		batchRef, err := product.Allocate(orderLine)
+		if err != nil {
+			results[i].Err = err
+			if mode == domain.BestEffort && errors.As(err, &domain.OutOfStockError{}) && product.Backorder(orderLine) == nil {
+				results[i].Err = BackorderedError{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Err: err}
+				changed = append(changed, product)
+			}
+			continue
+		}
+		results[i].BatchRef = batchRef
+		allocated++
+		changed = append(changed, product)
+	}
+
+	if allocated < len(order.Lines) && (mode == domain.AllOrNothing || len(changed) == 0) {
+		// Leaving the unit of work uncommitted rolls back the lines that were allocated
+		for i := range results {
+			results[i].BatchRef = ""
+		}
+		return nil, OrderNotAllocatedError{OrderID: order.OrderID, Lines: results}
+	}
+
+	for _, product := range changed {
+		if err := s.uow.SaveProduct(product); err != nil {
+			return nil, fmt.Errorf("could not persist order line allocation: %w", err)
+		}
+	}
+
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *StockService) Deallocate(batch domain.Batch, orderLine domain.OrderLine) error {
+	if err := s.uow.Begin(); err != nil {
+		return fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(orderLine.Sku)
+	if err != nil {
+		return fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return InvalidSkuError{sku: orderLine.Sku}
+	}
+	s.configure(product)
+
+	if err = product.Deallocate(batch.Reference, orderLine); err != nil {
+		return fmt.Errorf("could not deallocate order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return fmt.Errorf("could not persist order line deallocation: %w", err)
+	}
+	return s.commit()
+}
+
+// DeallocateOrderLine removes every part of the order line from the batches it was allocated to in one go,
+// for a bundle every part of its components is removed like DeallocateBundle
+func (s *StockService) DeallocateOrderLine(orderId domain.Reference, sku domain.Sku) ([]domain.BatchAllocation, error) {
+	if s.IsBundle(sku) {
+		components, err := s.DeallocateBundle(orderId, sku)
+		if err != nil {
+			return nil, err
+		}
+		deallocated := make([]domain.BatchAllocation, 0, len(components))
+		for _, component := range components {
+			deallocated = append(deallocated, domain.BatchAllocation{BatchRef: component.BatchRef, Quantity: component.Quantity})
+		}
+		return deallocated, nil
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(sku)
+	if err != nil {
+		return nil, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return nil, InvalidSkuError{sku: sku}
+	}
+	s.configure(product)
+
+	deallocated, err := product.DeallocateOrderLine(orderId)
+	if err != nil {
+		return nil, fmt.Errorf("could not deallocate order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return nil, fmt.Errorf("could not persist order line deallocation: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return nil, err
+	}
+	return deallocated, nil
+}
+
+// AmendOrderLine changes the quantity of the order's allocated line, keeping it in its batch when the batch has room
+// and reallocating it otherwise, a line split across batches keeps its parts where they are.
+// Nothing is changed when the new quantity cannot be allocated.
+func (s *StockService) AmendOrderLine(orderLine domain.OrderLine) (domain.Reference, error) {
+	if err := s.uow.Begin(); err != nil {
+		return "", fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(orderLine.Sku)
+	if err != nil {
+		return "", fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return "", InvalidSkuError{sku: orderLine.Sku}
+	}
+	s.configure(product)
+
+	batchRef, err := product.AmendOrderLine(orderLine)
+	if err != nil {
+		return "", fmt.Errorf("could not amend order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return "", fmt.Errorf("could not persist amended order line: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return "", err
+	}
+	return batchRef, nil
+}
+
+// PlanRebalance works out how the order lines of the sku would be reallocated to fulfil as many as possible,
+// without changing any stock
+func (s *StockService) PlanRebalance(sku domain.Sku) (domain.RebalancePlan, error) {
+	if err := s.uow.Begin(); err != nil {
+		return domain.RebalancePlan{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(sku)
+	if err != nil {
+		return domain.RebalancePlan{}, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return domain.RebalancePlan{}, InvalidSkuError{sku: sku}
+	}
+	s.configure(product)
+	return product.PlanRebalance(), nil
+}
+
+// Rebalance reallocates the order lines of the sku as planned by PlanRebalance, in one transaction
+func (s *StockService) Rebalance(sku domain.Sku) (domain.RebalancePlan, error) {
+	if err := s.uow.Begin(); err != nil {
+		return domain.RebalancePlan{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
 
+	product, err := s.uow.GetProduct(sku)
 	if err != nil {
-		return "", fmt.Errorf("could not list batches: %w", err)
+		return domain.RebalancePlan{}, fmt.Errorf("could not get product: %w", err)
 	}
 
+	if product == nil {
+		return domain.RebalancePlan{}, InvalidSkuError{sku: sku}
+	}
+	s.configure(product)
+
+	plan, err := product.Rebalance()
+	if err != nil {
+		return domain.RebalancePlan{}, fmt.Errorf("could not rebalance stock: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return domain.RebalancePlan{}, fmt.Errorf("could not persist rebalanced stock: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return domain.RebalancePlan{}, err
+	}
+	return plan, nil
+}
+
+// CancelOrder takes every line of the order off the products in one unit of work, deallocating its allocations,
+// releasing its holds and dropping its backorders. It returns the parts of the lines that were deallocated.
+func (s *StockService) CancelOrder(orderID domain.Reference) ([]domain.LineAllocation, error) {
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	skus, err := s.uow.ListOrderSkus(orderID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list lines of order: %w", err)
+	}
+	if len(skus) == 0 {
+		return nil, UnknownOrderError{OrderID: orderID}
+	}
+
+	deallocated := []domain.LineAllocation{}
+	for _, sku := range skus {
+		product, err := s.uow.GetProduct(sku)
+		if err != nil {
+			return nil, fmt.Errorf("could not get product: %w", err)
+		}
+		if product == nil {
+			return nil, InvalidSkuError{sku: sku}
+		}
+		s.configure(product)
+
+		batchAllocations, _ := product.CancelOrder(orderID)
+		for _, batchAllocation := range batchAllocations {
+			deallocated = append(deallocated, domain.LineAllocation{Sku: sku, Quantity: batchAllocation.Quantity, BatchRef: batchAllocation.BatchRef})
+		}
+		if err = s.uow.SaveProduct(product); err != nil {
+			return nil, fmt.Errorf("could not persist cancelled order: %w", err)
+		}
+	}
+
+	if err = s.uow.CancelOrderLines(orderID); err != nil {
+		return nil, fmt.Errorf("could not cancel order lines: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return nil, err
+	}
+	return deallocated, nil
+}
+
+// DeallocateBundle removes every component of the order's bundle from the batches it was allocated to in one unit
+// of work, nothing is deallocated unless every component was allocated
+func (s *StockService) DeallocateBundle(orderId domain.Reference, sku domain.Sku) ([]domain.LineAllocation, error) {
+	bundle, err := s.bundle(sku)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	var products []*domain.Product
+	var deallocated []domain.LineAllocation
+	for _, component := range bundle.Components {
+		product, err := s.uow.GetProduct(component.Sku)
+		if err != nil {
+			return nil, fmt.Errorf("could not get product: %w", err)
+		}
+		if product == nil {
+			return nil, InvalidSkuError{sku: component.Sku}
+		}
+		s.configure(product)
+
+		batchAllocations, err := product.DeallocateOrderLine(orderId)
+		if err != nil {
+			return nil, fmt.Errorf("could not deallocate component %s of bundle %s: %w", component.Sku, bundle.Sku, err)
+		}
+		for _, batchAllocation := range batchAllocations {
+			deallocated = append(deallocated, domain.LineAllocation{Sku: component.Sku, Quantity: batchAllocation.Quantity, BatchRef: batchAllocation.BatchRef})
+		}
+		products = append(products, product)
+	}
+
+	for _, product := range products {
+		if err := s.uow.SaveProduct(product); err != nil {
+			return nil, fmt.Errorf("could not persist order line deallocation: %w", err)
+		}
+	}
+
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return deallocated, nil
+}
+
+// BundleAvailability returns the number of whole bundles that can be made up from the available stock of its components
+func (s *StockService) BundleAvailability(sku domain.Sku) (int, error) {
+	bundle, err := s.bundle(sku)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return 0, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	products := make(map[domain.Sku]*domain.Product, len(bundle.Components))
+	for _, component := range bundle.Components {
+		product, err := s.uow.GetProduct(component.Sku)
+		if err != nil {
+			return 0, fmt.Errorf("could not get product: %w", err)
+		}
+		if product != nil {
+			s.configure(product)
+			products[component.Sku] = product
+		}
+	}
+	return bundle.Available(products)
+}
+
+// DeliveryPromise returns the earliest date the quantity of the sku could be dispatched, from the stock available now
+// and without allocating it. A quantity without a unit is in the base unit of the sku.
+func (s *StockService) DeliveryPromise(sku domain.Sku, quantity int, unit domain.Unit) (domain.Promise, error) {
+	if err := s.uow.Begin(); err != nil {
+		return domain.Promise{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProduct(sku)
+	if err != nil {
+		return domain.Promise{}, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return domain.Promise{}, InvalidSkuError{sku: sku}
+	}
+	s.configure(product)
+	return product.PromiseQuantity(domain.OrderLine{Sku: sku, Quantity: quantity, Unit: unit})
+}
+
+func (s *StockService) ChangeBatchQuantity(reference domain.Reference, quantity int) error {
+	if err := s.uow.Begin(); err != nil {
+		return fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProductByBatchRef(reference)
+	if err != nil {
+		return fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return fmt.Errorf("batch %s does not exist", reference)
+	}
+	s.configure(product)
+
+	if err = product.ChangeBatchQuantity(reference, quantity); err != nil {
+		return fmt.Errorf("could not change batch quantity: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return fmt.Errorf("could not persist batch quantity: %w", err)
+	}
+	return s.commit()
+}
+
+// QuarantineBatch stops the batch from being allocated to until quality control releases it, its order lines are
+// moved to the other batches of the product and the lines that could not be moved are reported
+func (s *StockService) QuarantineBatch(reference domain.Reference) (domain.StatusChange, error) {
+	return s.ChangeBatchStatus(reference, domain.Quarantined)
+}
+
+// ChangeBatchStatus sets the status of the batch, moving its order lines off it when it can no longer be allocated to
+func (s *StockService) ChangeBatchStatus(reference domain.Reference, status domain.BatchStatus) (domain.StatusChange, error) {
+	if err := s.uow.Begin(); err != nil {
+		return domain.StatusChange{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	product, err := s.uow.GetProductByBatchRef(reference)
+	if err != nil {
+		return domain.StatusChange{}, fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return domain.StatusChange{}, fmt.Errorf("batch %s does not exist", reference)
+	}
+	s.configure(product)
+
+	change, err := product.ChangeBatchStatus(reference, status)
+	if err != nil {
+		return domain.StatusChange{}, fmt.Errorf("could not change batch status: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return domain.StatusChange{}, fmt.Errorf("could not persist batch status: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return domain.StatusChange{}, err
+	}
+	return change, nil
+}
+
+// Recall marks every batch as recalled and deals with their order lines according to the policy.
+// The report of the recall lists the affected order lines and is persisted so that it can be looked up later.
+func (s *StockService) Recall(recallID domain.Reference, references []domain.Reference, policy domain.RecallPolicy) (domain.RecallReport, error) {
+	if recallID == "" || len(references) == 0 {
+		return domain.RecallReport{}, fmt.Errorf("a recall needs an id and at least one batch")
+	}
+	if !policy.IsValid() {
+		return domain.RecallReport{}, fmt.Errorf("unknown recall policy %q", policy)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return domain.RecallReport{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	existing, err := s.uow.GetRecallReport(recallID)
+	if err != nil {
+		return domain.RecallReport{}, fmt.Errorf("could not get recall: %w", err)
+	}
+	if existing != nil {
+		return domain.RecallReport{}, fmt.Errorf("recall %s already exists", recallID)
+	}
+
+	report := domain.RecallReport{RecallID: recallID, Policy: policy, RecalledAt: s.now()}
+	var products []*domain.Product
+	for _, reference := range references {
+		i := slices.IndexFunc(products, func(product *domain.Product) bool {
+			_, ok := product.Batch(reference)
+			return ok
+		})
+		if i < 0 {
+			product, err := s.uow.GetProductByBatchRef(reference)
+			if err != nil {
+				return domain.RecallReport{}, fmt.Errorf("could not get product: %w", err)
+			}
+			if product == nil {
+				return domain.RecallReport{}, fmt.Errorf("batch %s does not exist", reference)
+			}
+			s.configure(product)
+			products = append(products, product)
+			i = len(products) - 1
+		}
+
+		lines, err := products[i].Recall(reference, policy)
+		if err != nil {
+			return domain.RecallReport{}, fmt.Errorf("could not recall batch: %w", err)
+		}
+		report.Batches = append(report.Batches, domain.RecalledBatch{Reference: reference, Sku: products[i].Sku})
+		report.Lines = append(report.Lines, lines...)
+	}
+
+	for _, product := range products {
+		if err = s.uow.SaveProduct(product); err != nil {
+			return domain.RecallReport{}, fmt.Errorf("could not persist recalled batches: %w", err)
+		}
+	}
+	if err = s.uow.AddRecallReport(report); err != nil {
+		return domain.RecallReport{}, fmt.Errorf("could not persist recall report: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return domain.RecallReport{}, err
+	}
+	return report, nil
+}
+
+// RecallReport returns the report of a recall
+func (s *StockService) RecallReport(recallID domain.Reference) (domain.RecallReport, error) {
+	if err := s.uow.Begin(); err != nil {
+		return domain.RecallReport{}, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	report, err := s.uow.GetRecallReport(recallID)
+	if err != nil {
+		return domain.RecallReport{}, fmt.Errorf("could not get recall: %w", err)
+	}
+	if report == nil {
+		return domain.RecallReport{}, UnknownRecallError{RecallID: recallID}
+	}
+	return *report, nil
+}
+
+// TransferStock moves quantity of the batch to a new batch in transit to the destination warehouse, arriving at the eta
+func (s *StockService) TransferStock(reference domain.Reference, transferRef domain.Reference, quantity int, destination domain.Warehouse, eta time.Time) error {
+	if err := s.uow.Begin(); err != nil {
+		return fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	existing, err := s.uow.GetProductByBatchRef(transferRef)
+	if err != nil {
+		return fmt.Errorf("could not get product: %w", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("batch %s already exists", transferRef)
+	}
+
+	product, err := s.uow.GetProductByBatchRef(reference)
+	if err != nil {
+		return fmt.Errorf("could not get product: %w", err)
+	}
+
+	if product == nil {
+		return fmt.Errorf("batch %s does not exist", reference)
+	}
+	s.configure(product)
+
+	if err = product.TransferStock(reference, transferRef, quantity, destination, eta); err != nil {
+		return fmt.Errorf("could not transfer stock: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return fmt.Errorf("could not persist stock transfer: %w", err)
+	}
+	return s.commit()
+}
+
+// Hold reserves stock for the order line for the given duration, or the DefaultHoldDuration if it is not positive
+func (s *StockService) Hold(orderId domain.Reference, sku domain.Sku, quantity int, duration time.Duration) (domain.Reference, error) {
 	orderLine := domain.OrderLine{
 		OrderID:  orderId,
 		Sku:      sku,
 		Quantity: quantity,
 	}
+	if duration <= 0 {
+		duration = DefaultHoldDuration
+	}
 
-	if !s.isValidSku(orderLine.Sku, batches) {
-		return "", InvalidSkuError{sku: orderLine.Sku}
+	if err := s.uow.Begin(); err != nil {
+		return "", fmt.Errorf("could not begin unit of work: %w", err)
 	}
+	defer s.uow.Rollback()
 
-	batchRef, err := domain.Allocate(orderLine, batches)
+	product, err := s.uow.GetProduct(sku)
+	if err != nil {
+		return "", fmt.Errorf("could not get product: %w", err)
+	}
 
+	if product == nil {
+		return "", InvalidSkuError{sku: sku}
+	}
+	s.configure(product)
+
+	batchRef, err := product.Hold(orderLine, s.now().Add(duration))
+	if errors.As(err, &domain.OutOfStockError{}) {
+		// Nothing has changed but the out of stock event still needs to be handled
+		if commitErr := s.commit(); commitErr != nil {
+			return "", commitErr
+		}
+	}
 	if err != nil {
-		return "", fmt.Errorf("could not allocate order line to any batch: %w", err)
+		return "", fmt.Errorf("could not hold stock for order line: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return "", fmt.Errorf("could not persist hold: %w", err)
 	}
 
-	if err = s.repo.AddOrderLine(orderLine); err != nil {
-		return "", fmt.Errorf("could not add order line: %w", err)
+	if err = s.commit(); err != nil {
+		return "", err
 	}
+	return batchRef, nil
+}
 
-	batchToAllocate, err := s.repo.GetBatch(batchRef)
+// ConfirmHold turns the hold of the order on the sku into an allocation
+func (s *StockService) ConfirmHold(orderId domain.Reference, sku domain.Sku) (domain.Reference, error) {
+	if err := s.uow.Begin(); err != nil {
+		return "", fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
 
+	product, err := s.uow.GetProduct(sku)
 	if err != nil {
-		return "", fmt.Errorf("could not find batch to allocate order line to: %w", err)
+		return "", fmt.Errorf("could not get product: %w", err)
 	}
 
-	if err = s.repo.AllocateToBatch(batchToAllocate, orderLine); err != nil {
-		return "", fmt.Errorf("could not persist order line allocation")
+	if product == nil {
+		return "", InvalidSkuError{sku: sku}
+	}
+
+	batchRef, err := product.ConfirmHold(orderId, s.now())
+	if err != nil {
+		return "", fmt.Errorf("could not confirm hold: %w", err)
+	}
+
+	if err = s.uow.SaveProduct(product); err != nil {
+		return "", fmt.Errorf("could not persist order line allocation: %w", err)
+	}
+
+	if err = s.commit(); err != nil {
+		return "", err
 	}
 	return batchRef, nil
 }
 
-func (s *StockService) Deallocate(batch domain.Batch, orderLine domain.OrderLine) error {
-	batchEnriched, err := s.repo.GetBatch(batch.Reference)
+// ReleaseExpiredHolds releases every hold that has expired, the stock they free is used to fill backorders
+func (s *StockService) ReleaseExpiredHolds() ([]domain.Hold, error) {
+	now := s.now()
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	skus, err := s.uow.ListSkusWithExpiredHolds(now)
 	if err != nil {
-		return fmt.Errorf("could not retrieve batch")
+		return nil, fmt.Errorf("could not list expired holds: %w", err)
 	}
-	if isAllocated := batchEnriched.IsAllocated(orderLine); !isAllocated {
-		return fmt.Errorf("order line is not allocated to this batch")
+
+	var released []domain.Hold
+	for _, sku := range skus {
+		product, err := s.uow.GetProduct(sku)
+		if err != nil {
+			return nil, fmt.Errorf("could not get product: %w", err)
+		}
+		s.configure(product)
+
+		released = append(released, product.ReleaseExpiredHolds(now)...)
+		if err = s.uow.SaveProduct(product); err != nil {
+			return nil, fmt.Errorf("could not persist released holds: %w", err)
+		}
 	}
-	return s.repo.DeallocateFromBatch(batch, orderLine)
+
+	if err = s.commit(); err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+// ReviewExpiry flags the batches that expire within the warning period, or the DefaultExpiryWarning if it is not positive,
+// and moves the order lines off the batches that have expired
+func (s *StockService) ReviewExpiry(warning time.Duration) error {
+	if warning <= 0 {
+		warning = DefaultExpiryWarning
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	skus, err := s.uow.ListSkusWithBatchesExpiringBy(s.now().Add(warning))
+	if err != nil {
+		return fmt.Errorf("could not list expiring batches: %w", err)
+	}
+
+	for _, sku := range skus {
+		product, err := s.uow.GetProduct(sku)
+		if err != nil {
+			return fmt.Errorf("could not get product: %w", err)
+		}
+		s.configure(product)
+
+		if !product.ReviewExpiry(warning) {
+			continue
+		}
+		if err = s.uow.SaveProduct(product); err != nil {
+			return fmt.Errorf("could not persist expiry review: %w", err)
+		}
+	}
+
+	return s.commit()
+}
+
+// WarehouseStock reports the stock of every sku kept at the warehouse
+func (s *StockService) WarehouseStock(warehouse domain.Warehouse) ([]domain.WarehouseStock, error) {
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	defer s.uow.Rollback()
+
+	batches, err := s.uow.ListWarehouseBatches(warehouse)
+	if err != nil {
+		return nil, fmt.Errorf("could not list batches of warehouse: %w", err)
+	}
+	return domain.StockByWarehouse(batches), nil
+}
+
+// backorder parks the out of stock order line on the product and commits it,
+// so that the line is allocated once stock arrives and the out of stock event is handled
+func (s *StockService) backorder(product *domain.Product, orderLine domain.OrderLine, outOfStock error) error {
+	if err := product.Backorder(orderLine); err != nil {
+		return fmt.Errorf("could not backorder order line: %w", err)
+	}
+	if err := s.uow.SaveProduct(product); err != nil {
+		return fmt.Errorf("could not persist backorder: %w", err)
+	}
+	if err := s.commit(); err != nil {
+		return err
+	}
+	return BackorderedError{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Err: outOfStock}
+}
+
+// backorderSplit allocates what the batches have room for of the order line that is out of stock and backorders the
+// rest, it returns the parts allocated with a BackorderedError once the unit of work is committed
+func (s *StockService) backorderSplit(product *domain.Product, orderLine domain.OrderLine, outOfStock error) ([]domain.BatchAllocation, error) {
+	allocations, err := product.BackorderSplit(orderLine)
+	if err != nil {
+		return nil, fmt.Errorf("could not backorder order line: %w", err)
+	}
+	if err := s.uow.SaveProduct(product); err != nil {
+		return nil, fmt.Errorf("could not persist backorder: %w", err)
+	}
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return allocations, BackorderedError{OrderID: orderLine.OrderID, Sku: orderLine.Sku, Err: outOfStock}
+}
+
+// configure sets the clock of the service and the units and allocation strategy configured for the sku on the product,
+// a nil strategy or clock leaves the product to its default
+func (s *StockService) configure(product *domain.Product) {
+	product.Clock = s.clock
+	product.Units = s.units[product.Sku]
+	product.LeadTime = s.leadTimes[product.Sku]
+	if strategy, ok := s.skuStrategies[product.Sku]; ok {
+		product.Strategy = strategy
+		return
+	}
+	product.Strategy = s.strategy
+}
+
+// bundle returns the bundle of the sku once it has been checked to be valid
+func (s *StockService) bundle(sku domain.Sku) (domain.Bundle, error) {
+	bundle, ok := s.bundles[sku]
+	if !ok {
+		return domain.Bundle{}, UnknownBundleError{Sku: sku}
+	}
+	if err := bundle.Validate(); err != nil {
+		return domain.Bundle{}, err
+	}
+	return bundle, nil
+}
+
+// preferRegion has the product allocate from the warehouses nearest the region first, then by its own strategy
+func (s *StockService) preferRegion(product *domain.Product, region domain.Region) {
+	if region == "" {
+		return
+	}
+	product.Strategy = domain.NearestWarehouseStrategy{Warehouses: s.preferences.For(region), Then: product.Strategy}
+}
+
+func (s *StockService) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+// commit commits the unit of work, leaving the events it recorded to be collected
+func (s *StockService) commit() error {
+	if err := s.uow.Commit(); err != nil {
+		return fmt.Errorf("could not commit unit of work: %w", err)
+	}
+	return nil
+}
+
+// UnknownRecallError is returned when the report of a recall that never happened is asked for
+type UnknownRecallError struct {
+	RecallID domain.Reference
+}
+
+func (u UnknownRecallError) Error() string {
+	return fmt.Sprintf("recall %s does not exist", u.RecallID)
+}
+
+// UnknownOrderError is returned when an order has no lines allocated, held or backordered
+type UnknownOrderError struct {
+	OrderID domain.Reference
+}
+
+func (u UnknownOrderError) Error() string {
+	return fmt.Sprintf("order %s does not exist", u.OrderID)
+}
+
+// UnknownBundleError is returned when a bundle use case is given a sku that is not a bundle
+type UnknownBundleError struct {
+	Sku domain.Sku
+}
+
+func (u UnknownBundleError) Error() string {
+	return fmt.Sprintf("%s is not a bundle", u.Sku)
+}
+
+// BundleLineError is returned when a line of a bundle is given to a use case that allocates a single sku
+type BundleLineError struct {
+	Sku domain.Sku
+}
+
+func (b BundleLineError) Error() string {
+	return fmt.Sprintf("%s is a bundle, allocate it with AllocateBundle", b.Sku)
 }
 
 type InvalidSkuError struct {
@@ -88,8 +1295,28 @@ func (i InvalidSkuError) Error() string {
 	return fmt.Sprintf("%s sku is invalid", i.sku)
 }
 
-func (s StockService) isValidSku(sku domain.Sku, batches []domain.Batch) bool {
-	return slices.ContainsFunc[[]domain.Batch](batches, func(batch domain.Batch) bool {
-		return batch.Sku == sku
-	})
+// BackorderedError is returned when an order line is out of stock and has been backordered instead of allocated
+type BackorderedError struct {
+	OrderID domain.Reference
+	Sku     domain.Sku
+	Err     error
+}
+
+func (b BackorderedError) Error() string {
+	return fmt.Sprintf("order %s of %s has been backordered: %s", b.OrderID, b.Sku, b.Err)
+}
+
+func (b BackorderedError) Unwrap() error {
+	return b.Err
+}
+
+// OrderNotAllocatedError is returned when none of the lines of an order have been allocated,
+// Lines holds the reason each line could not be
+type OrderNotAllocatedError struct {
+	OrderID domain.Reference
+	Lines   []domain.LineAllocation
+}
+
+func (o OrderNotAllocatedError) Error() string {
+	return fmt.Sprintf("order %s could not be allocated", o.OrderID)
 }
@@ -14,52 +14,1295 @@ func TestService_Allocate(t *testing.T) {
 		batchRef := domain.Reference("batch-123")
 		sku := domain.Sku("MASSIVE-LAMP")
 
-		repo := repos.NewFakeRepository(repos.WithBatch(batchRef, sku, 100, time.Now()))
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch(batchRef, sku, 100, time.Now()))
 
 		service := StockService{
-			repo: repo,
+			uow: uow,
 		}
 		allocatedBatchRef, err := service.Allocate("order-1", sku, 12)
 		assert.Nil(t, err)
 		assert.Equal(t, batchRef, allocatedBatchRef)
 	})
 
+	t.Run("commits the unit of work", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 100, time.Now()))
+
+		service := StockService{
+			uow: uow,
+		}
+		_, err := service.Allocate("order-1", sku, 12)
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+	})
+
+	t.Run("does not allocate when out of stock", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 10, time.Now()))
+
+		service := StockService{
+			uow: uow,
+		}
+		_, err := service.Allocate("order-1", sku, 12)
+		var outOfStockError domain.OutOfStockError
+		assert.ErrorAs(t, err, &outOfStockError)
+
+		batch, _ := uow.GetBatch("batch-123")
+		assert.Equal(t, 10, batch.AvailableQuantity())
+	})
+
 	t.Run("returns error for an invalid sku", func(t *testing.T) {
 		batchRef := domain.Reference("batch-123")
 		invalidSku := domain.Sku("INVALID-SKU")
 
-		repo := repos.NewFakeRepository(repos.WithBatch(batchRef, "VALID-SKU", 100, time.Now()))
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch(batchRef, "VALID-SKU", 100, time.Now()))
+
+		service := StockService{
+			uow: uow,
+		}
+		_, err := service.Allocate("order-1", invalidSku, 12)
+		assert.ErrorIs(t, err, InvalidSkuError{sku: invalidSku})
+	})
+
+	t.Run("allocate prefers warehouse batches to shipments", func(t *testing.T) {
+
+		inStockBatchRef := domain.Reference("in-stock-batch-001")
+		shipmentBatchRef := domain.Reference("shipment-batch-001")
+		sku := domain.Sku("RETRO-CLOCK")
+
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch(inStockBatchRef, sku, 100, time.Time{}),
+			repos.WithBatch(shipmentBatchRef, sku, 100, time.Time{}.AddDate(0, 4, 1)),
+		)
+
+		service := StockService{
+			uow: uow,
+		}
+
+		_, err := service.Allocate("order-002", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+
+		inStockBatch, _ := uow.GetBatch(inStockBatchRef)
+		shipmentBatch, _ := uow.GetBatch(shipmentBatchRef)
+
+		assert.Equal(t, 90, inStockBatch.AvailableQuantity())
+		assert.Equal(t, 100, shipmentBatch.AvailableQuantity())
+	})
+
+	t.Run("allocates only to batches of the same sku", func(t *testing.T) {
+		sku := domain.Sku("RETRO-CLOCK")
+
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("other-sku-batch-001", "TEDDY-BEAR", 100, time.Time{}),
+			repos.WithBatch("sku-batch-001", sku, 100, time.Time{}.AddDate(0, 4, 1)),
+		)
+
+		service := StockService{
+			uow: uow,
+		}
+
+		batchRef, err := service.Allocate("order-002", sku, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("sku-batch-001"), batchRef)
+	})
+}
+
+func TestService_Backorders(t *testing.T) {
+	t.Run("backorders an order line that is out of stock", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 10, time.Time{}))
+		service := StockService{uow: uow}
+
+		_, err := service.Allocate("order-1", sku, 12)
+		var backorderedError BackorderedError
+		assert.ErrorAs(t, err, &backorderedError)
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+		assert.True(t, uow.Committed)
+
+		product, _ := uow.GetProduct(sku)
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-1", Sku: sku, Quantity: 12}}, product.Backorders)
+	})
+
+	t.Run("fills the backorder when a batch is added", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 10, time.Time{}))
+		service := StockService{uow: uow}
+
+		_, err := service.Allocate("order-1", sku, 12)
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		err = service.AddBatch("batch-456", sku, 20, time.Time{}.AddDate(0, 1, 0))
+		assert.Nil(t, err)
+
+		batch, _ := uow.GetBatch("batch-456")
+		assert.Equal(t, 12, batch.AllocatedQuantity())
+		product, _ := uow.GetProduct(sku)
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("fills the backorder when the quantity of a batch is increased", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-123", sku, 10, time.Time{}))
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateSplit("order-1", sku, 12)
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		err = service.ChangeBatchQuantity("batch-123", 15)
+		assert.Nil(t, err)
+
+		batch, _ := uow.GetBatch("batch-123")
+		assert.Equal(t, 12, batch.AllocatedQuantity())
+	})
+}
+
+func TestService_Holds(t *testing.T) {
+	sku := domain.Sku("MASSIVE-LAMP")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("holds stock for the default duration", func(t *testing.T) {
+		clock := now
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 10, time.Time{}))
+		service := NewStockService(uow, WithClock(func() time.Time { return clock }))
+
+		batchRef, err := service.Hold("order-1", sku, 4, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), batchRef)
+		assert.True(t, uow.Committed)
+
+		batch, _ := uow.GetBatch("batch-001")
+		hold, _ := batch.HoldOf("order-1")
+		assert.Equal(t, now.Add(DefaultHoldDuration), hold.ExpiresAt)
+		assert.Equal(t, 6, batch.AvailableQuantity())
+	})
+
+	t.Run("confirms a hold before it expires", func(t *testing.T) {
+		clock := now
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 10, time.Time{}))
+		service := NewStockService(uow, WithClock(func() time.Time { return clock }))
+		_, err := service.Hold("order-1", sku, 4, time.Minute)
+		assert.Nil(t, err)
+
+		clock = now.Add(59 * time.Second)
+		batchRef, err := service.ConfirmHold("order-1", sku)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), batchRef)
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 4, batch.AllocatedQuantity())
+		assert.Equal(t, 0, batch.HeldQuantity())
+	})
+
+	t.Run("does not confirm a hold that has expired", func(t *testing.T) {
+		clock := now
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 10, time.Time{}))
+		service := NewStockService(uow, WithClock(func() time.Time { return clock }))
+		_, err := service.Hold("order-1", sku, 4, time.Minute)
+		assert.Nil(t, err)
+
+		clock = now.Add(time.Minute)
+		_, err = service.ConfirmHold("order-1", sku)
+		assert.ErrorAs(t, err, &domain.HoldExpiredError{})
+	})
+
+	t.Run("releases expired holds", func(t *testing.T) {
+		clock := now
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 10, time.Time{}))
+		service := NewStockService(uow, WithClock(func() time.Time { return clock }))
+		_, err := service.Hold("order-1", sku, 4, time.Minute)
+		assert.Nil(t, err)
+		_, err = service.Hold("order-2", sku, 3, time.Hour)
+		assert.Nil(t, err)
+
+		clock = now.Add(time.Minute)
+		released, err := service.ReleaseExpiredHolds()
+		assert.Nil(t, err)
+		assert.Len(t, released, 1)
+		assert.Equal(t, domain.Reference("order-1"), released[0].OrderID)
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 3, batch.HeldQuantity())
+	})
+}
+
+func TestService_AllocatePreempting(t *testing.T) {
+	sku := domain.Sku("MASSIVE-LAMP")
+
+	t.Run("displaces a bulk line to a shipment for an express line", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", sku, 10, time.Time{}),
+			repos.WithBatch("shipment-batch", sku, 10, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := StockService{uow: uow}
+		_, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: sku, Quantity: 8, Priority: domain.Bulk}, "")
+		assert.Nil(t, err)
+
+		allocation, err := service.AllocatePreempting("order-2", sku, 5, domain.Express)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("in-stock-batch"), allocation.BatchRef)
+		assert.Equal(t, []domain.Displacement{{
+			OrderLine:    domain.OrderLine{OrderID: "order-1", Sku: sku, Quantity: 8, Priority: domain.Bulk},
+			FromBatchRef: "in-stock-batch",
+			ToBatchRef:   "shipment-batch",
+		}}, allocation.Displacements)
+		assert.True(t, uow.Committed)
+
+		shipmentBatch, _ := uow.GetBatch("shipment-batch")
+		assert.Equal(t, 8, shipmentBatch.AllocatedQuantity())
+	})
+
+	t.Run("returns error for an unknown priority", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", sku, 10, time.Time{}),
+			repos.WithBatch("shipment-batch", sku, 10, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := StockService{uow: uow}
+
+		_, err := service.AllocatePreempting("order-1", sku, 5, "overnight")
+		assert.Error(t, err)
+	})
+}
+
+func TestService_Warehouses(t *testing.T) {
+	sku := domain.Sku("RETRO-CLOCK")
+	preferences := domain.WarehousePreferences{
+		"north": {"leeds", "london"},
+		"south": {"london", "leeds"},
+	}
+
+	t.Run("allocates from the warehouse nearest the region", func(t *testing.T) {
+		service := NewStockService(repos.NewFakeUnitOfWork(), WithWarehousePreferences(preferences))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "london-batch", Sku: sku, Quantity: 20, Warehouse: "london"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-batch", Sku: sku, Quantity: 20, Warehouse: "leeds"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-shipment", Sku: sku, Quantity: 100, ETA: time.Time{}.AddDate(0, 1, 0), Warehouse: "leeds"}))
+
+		batchRef, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: sku, Quantity: 5}, "north")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("leeds-batch"), batchRef)
+
+		batchRef, err = service.AllocateLine(domain.OrderLine{OrderID: "order-2", Sku: sku, Quantity: 5}, "south")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("london-batch"), batchRef)
+	})
+
+	t.Run("falls back to the next nearest warehouse", func(t *testing.T) {
+		service := NewStockService(repos.NewFakeUnitOfWork(), WithWarehousePreferences(preferences))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "london-batch", Sku: sku, Quantity: 20, Warehouse: "london"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-batch", Sku: sku, Quantity: 20, Warehouse: "leeds"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-shipment", Sku: sku, Quantity: 100, ETA: time.Time{}.AddDate(0, 1, 0), Warehouse: "leeds"}))
+
+		_, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: sku, Quantity: 15}, "south")
+		assert.Nil(t, err)
+
+		batchRef, err := service.AllocateLine(domain.OrderLine{OrderID: "order-2", Sku: sku, Quantity: 15}, "south")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("leeds-batch"), batchRef)
+	})
+
+	t.Run("ranks batches of the same warehouse with the allocation strategy", func(t *testing.T) {
+		service := NewStockService(repos.NewFakeUnitOfWork(), WithAllocationStrategy(domain.LargestRemainingStrategy{}), WithWarehousePreferences(preferences))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "london-batch", Sku: sku, Quantity: 20, Warehouse: "london"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-batch", Sku: sku, Quantity: 20, Warehouse: "leeds"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-shipment", Sku: sku, Quantity: 100, ETA: time.Time{}.AddDate(0, 1, 0), Warehouse: "leeds"}))
+
+		batchRef, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: sku, Quantity: 5}, "north")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("leeds-shipment"), batchRef)
+	})
+
+	t.Run("allocates without a region as before", func(t *testing.T) {
+		service := NewStockService(repos.NewFakeUnitOfWork(), WithWarehousePreferences(preferences))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "london-batch", Sku: sku, Quantity: 20, Warehouse: "london"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-batch", Sku: sku, Quantity: 20, Warehouse: "leeds"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-shipment", Sku: sku, Quantity: 100, ETA: time.Time{}.AddDate(0, 1, 0), Warehouse: "leeds"}))
+
+		batchRef, err := service.Allocate("order-1", sku, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("london-batch"), batchRef)
+	})
+
+	t.Run("reports the stock kept at a warehouse", func(t *testing.T) {
+		service := NewStockService(repos.NewFakeUnitOfWork(), WithWarehousePreferences(preferences))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "london-batch", Sku: sku, Quantity: 20, Warehouse: "london"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-batch", Sku: sku, Quantity: 20, Warehouse: "leeds"}))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-shipment", Sku: sku, Quantity: 100, ETA: time.Time{}.AddDate(0, 1, 0), Warehouse: "leeds"}))
+		_, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: sku, Quantity: 5}, "north")
+		assert.Nil(t, err)
+
+		report, err := service.WarehouseStock("leeds")
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.WarehouseStock{{Warehouse: "leeds", Sku: sku, Quantity: 120, Allocated: 5, Available: 115}}, report)
+	})
+}
+
+func TestService_QuarantineBatch(t *testing.T) {
+	sku := domain.Sku("RETRO-CLOCK")
+
+	t.Run("moves the order lines and reports the ones that could not be moved", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", sku, 50, time.Time{}),
+			repos.WithBatch("shipment-batch", sku, 25, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", sku, 10)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", sku, 30)
+		assert.Nil(t, err)
+
+		change, err := service.QuarantineBatch("in-stock-batch")
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-2", Sku: sku, Quantity: 30}}, change.Unmoved())
+
+		shipmentBatch, _ := uow.GetBatch("shipment-batch")
+		assert.Equal(t, 10, shipmentBatch.AllocatedQuantity())
+
+		_, err = service.Allocate("order-3", sku, 20)
+		assert.ErrorAs(t, err, &BackorderedError{})
+	})
+
+	t.Run("returns error for an unknown batch", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow)
+
+		_, err := service.QuarantineBatch("batch-404")
+		assert.Error(t, err)
+		assert.False(t, uow.Committed)
+	})
+
+	t.Run("allocates to the batch once it is available again", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("in-stock-batch", sku, 50, time.Time{}))
+		service := NewStockService(uow)
+		_, err := service.QuarantineBatch("in-stock-batch")
+		assert.Nil(t, err)
+
+		_, err = service.ChangeBatchStatus("in-stock-batch", domain.Available)
+		assert.Nil(t, err)
+
+		batchRef, err := service.Allocate("order-1", sku, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("in-stock-batch"), batchRef)
+	})
+}
+
+func TestService_Units(t *testing.T) {
+	clockUnits := domain.UnitsOfMeasure{Sku: "RETRO-CLOCK", Base: "each", Factors: map[domain.Unit]int{"case": 12}}
+	t.Run("keeps a new product's batches in its base unit", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow, WithUnitsOfMeasure(clockUnits))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 24, batch.Quantity)
+		assert.Equal(t, domain.Unit("each"), batch.Unit)
+	})
+
+	t.Run("allocates lines ordered in eaches and cases", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow, WithUnitsOfMeasure(clockUnits))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+
+		batchRef, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "case"}, "")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), batchRef)
+		_, err = service.AllocateLine(domain.OrderLine{OrderID: "order-2", Sku: "RETRO-CLOCK", Quantity: 5, Unit: "each"}, "")
+		assert.Nil(t, err)
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 7, batch.AvailableQuantity())
+	})
+
+	t.Run("returns error for a unit the sku is not counted in", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow, WithUnitsOfMeasure(clockUnits))
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "batch-001", Sku: "RETRO-CLOCK", Quantity: 2, Unit: "case"}))
+
+		_, err := service.AllocateLine(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 1, Unit: "kg"}, "")
+		assert.ErrorAs(t, err, &domain.UnitConversionError{})
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 24, batch.AvailableQuantity())
+	})
+}
+
+func TestService_Bundles(t *testing.T) {
+	giftSet := domain.Bundle{Sku: "GIFT-SET", Components: []domain.BundleComponent{{Sku: "RETRO-CLOCK", Quantity: 1}, {Sku: "BLUE-LAMP", Quantity: 2}}}
+	t.Run("allocates every component of the bundle", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+
+		allocations, err := service.AllocateBundle(domain.OrderLine{OrderID: "order-1", Sku: "GIFT-SET", Quantity: 2}, "")
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+		assert.Equal(t, []domain.LineAllocation{
+			{Sku: "RETRO-CLOCK", Quantity: 2, BatchRef: "clock-batch"},
+			{Sku: "BLUE-LAMP", Quantity: 4, BatchRef: "lamp-batch"},
+		}, allocations)
+
+		lampBatch, _ := uow.GetBatch("lamp-batch")
+		assert.Equal(t, 6, lampBatch.AvailableQuantity())
+	})
+
+	t.Run("refuses to allocate a bundle as a single sku", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+
+		_, err := service.Allocate("order-1", "GIFT-SET", 1)
+		assert.ErrorAs(t, err, &BundleLineError{})
+
+		_, err = service.AllocateForCustomer(domain.OrderLine{OrderID: "order-1", Sku: "GIFT-SET", Quantity: 1}, "customer-1", "")
+		assert.ErrorAs(t, err, &BundleLineError{})
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		assert.Equal(t, 10, clockBatch.AvailableQuantity())
+		assert.False(t, uow.Committed)
+	})
+
+	t.Run("allocates no component unless they all can be", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+
+		_, err := service.AllocateBundle(domain.OrderLine{OrderID: "order-1", Sku: "GIFT-SET", Quantity: 6}, "")
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+		assert.False(t, uow.Committed)
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		assert.Equal(t, 10, clockBatch.AvailableQuantity())
+	})
+
+	t.Run("deallocates every component of the bundle", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+		_, err := service.AllocateBundle(domain.OrderLine{OrderID: "order-1", Sku: "GIFT-SET", Quantity: 2}, "")
+		assert.Nil(t, err)
+
+		deallocated, err := service.DeallocateBundle("order-1", "GIFT-SET")
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.LineAllocation{
+			{Sku: "RETRO-CLOCK", Quantity: 2, BatchRef: "clock-batch"},
+			{Sku: "BLUE-LAMP", Quantity: 4, BatchRef: "lamp-batch"},
+		}, deallocated)
+
+		lampBatch, _ := uow.GetBatch("lamp-batch")
+		assert.Equal(t, 10, lampBatch.AvailableQuantity())
+	})
+
+	t.Run("fills the backorders of the components with the stock freed", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+		_, err := service.AllocateBundle(domain.OrderLine{OrderID: "order-1", Sku: "GIFT-SET", Quantity: 2}, "")
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", "BLUE-LAMP", 8)
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		_, err = service.DeallocateBundle("order-1", "GIFT-SET")
+		assert.Nil(t, err)
+
+		lampBatch, _ := uow.GetBatch("lamp-batch")
+		assert.True(t, lampBatch.IsAllocated(domain.OrderLine{OrderID: "order-2", Sku: "BLUE-LAMP", Quantity: 8}))
+		product, _ := uow.GetProduct("BLUE-LAMP")
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("deallocates nothing unless every component was allocated", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 1)
+		assert.Nil(t, err)
+
+		_, err = service.DeallocateOrderLine("order-1", "GIFT-SET")
+		assert.Error(t, err)
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		assert.Equal(t, 9, clockBatch.AvailableQuantity())
+	})
+
+	t.Run("counts the bundles that can be made up from the components", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+		_, err := service.Allocate("order-1", "BLUE-LAMP", 3)
+		assert.Nil(t, err)
+
+		available, err := service.BundleAvailability("GIFT-SET")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, available)
+	})
+
+	t.Run("returns error for a sku that is not a bundle", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow, WithBundle(giftSet))
+
+		_, err := service.BundleAvailability("RETRO-CLOCK")
+		assert.Equal(t, UnknownBundleError{Sku: "RETRO-CLOCK"}, err)
+	})
+}
+
+func TestService_Substitutions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orderLine := domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 10}
+
+	t.Run("allocates the first substitute that can take an out of stock line", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("retro-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("modern-batch", "MODERN-CLOCK", 5, time.Time{}),
+			repos.WithBatch("cuckoo-batch", "CUCKOO-CLOCK", 20, time.Time{}),
+		)
+		service := NewStockService(uow,
+			WithSubstitutes("RETRO-CLOCK", "MODERN-CLOCK", "CUCKOO-CLOCK"),
+			WithSubstitutionOptIn("customer-1"),
+			WithClock(func() time.Time { return now }),
+		)
+
+		result, err := service.AllocateForCustomer(orderLine, "customer-1", "")
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+		assert.Equal(t, domain.AllocationResult{
+			BatchRef:   "cuckoo-batch",
+			Sku:        "CUCKOO-CLOCK",
+			OrderedSku: "RETRO-CLOCK",
+			Promise:    domain.Promise{BatchRef: "cuckoo-batch", InStock: true, DispatchDate: now},
+		}, result)
+		assert.True(t, result.Substituted())
+
+		batch, _ := uow.GetBatch("cuckoo-batch")
+		assert.Equal(t, 10, batch.AllocatedQuantity())
+		events := uow.CollectNewEvents()
+		assert.Contains(t, events, domain.OutOfStock{Sku: "RETRO-CLOCK"})
+		assert.Contains(t, events, domain.OrderLineSubstituted{
+			OrderID:       "order-1",
+			CustomerID:    "customer-1",
+			Sku:           "RETRO-CLOCK",
+			SubstituteSku: "CUCKOO-CLOCK",
+			Quantity:      10,
+			BatchRef:      "cuckoo-batch",
+		})
+	})
+
+	t.Run("allocates the sku ordered when it is in stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("retro-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("modern-batch", "MODERN-CLOCK", 5, time.Time{}),
+			repos.WithBatch("cuckoo-batch", "CUCKOO-CLOCK", 20, time.Time{}),
+		)
+		service := NewStockService(uow,
+			WithSubstitutes("RETRO-CLOCK", "MODERN-CLOCK", "CUCKOO-CLOCK"),
+			WithSubstitutionOptIn("customer-1"),
+			WithClock(func() time.Time { return now }),
+		)
+
+		result, err := service.AllocateForCustomer(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 5}, "customer-1", "")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.AllocationResult{
+			BatchRef:   "retro-batch",
+			Sku:        "RETRO-CLOCK",
+			OrderedSku: "RETRO-CLOCK",
+			Promise:    domain.Promise{BatchRef: "retro-batch", InStock: true, DispatchDate: now},
+		}, result)
+		assert.False(t, result.Substituted())
+	})
+
+	t.Run("backorders the line for a customer that has not opted in", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("retro-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("modern-batch", "MODERN-CLOCK", 5, time.Time{}),
+			repos.WithBatch("cuckoo-batch", "CUCKOO-CLOCK", 20, time.Time{}),
+		)
+		service := NewStockService(uow,
+			WithSubstitutes("RETRO-CLOCK", "MODERN-CLOCK", "CUCKOO-CLOCK"),
+			WithSubstitutionOptIn("customer-1"),
+			WithClock(func() time.Time { return now }),
+		)
+
+		_, err := service.AllocateForCustomer(orderLine, "customer-2", "")
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		batch, _ := uow.GetBatch("cuckoo-batch")
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+		product, _ := uow.GetProduct("RETRO-CLOCK")
+		assert.Equal(t, []domain.OrderLine{orderLine}, product.Backorders)
+	})
+
+	t.Run("backorders the line when every substitute is out of stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("retro-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("modern-batch", "MODERN-CLOCK", 5, time.Time{}),
+			repos.WithBatch("cuckoo-batch", "CUCKOO-CLOCK", 20, time.Time{}),
+		)
+		service := NewStockService(uow,
+			WithSubstitutes("RETRO-CLOCK", "MODERN-CLOCK", "CUCKOO-CLOCK"),
+			WithSubstitutionOptIn("customer-1"),
+			WithClock(func() time.Time { return now }),
+		)
+
+		_, err := service.AllocateForCustomer(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 30}, "customer-1", "")
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		product, _ := uow.GetProduct("RETRO-CLOCK")
+		assert.Len(t, product.Backorders, 1)
+	})
+}
+
+func TestService_CancelOrder(t *testing.T) {
+	t.Run("deallocates every line of the order", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 20, time.Time{}),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-1", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+
+		deallocated, err := service.CancelOrder("order-1")
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+		assert.Equal(t, []domain.LineAllocation{
+			{Sku: "BLUE-LAMP", Quantity: 5, BatchRef: "lamp-batch"},
+			{Sku: "RETRO-CLOCK", Quantity: 10, BatchRef: "clock-batch"},
+		}, deallocated)
+		assert.True(t, uow.CancelledOrders["order-1"])
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		lampBatch, _ := uow.GetBatch("lamp-batch")
+		assert.Equal(t, 20, clockBatch.AvailableQuantity())
+		assert.Equal(t, 15, lampBatch.AvailableQuantity())
+	})
+
+	t.Run("drops the backorders of the order", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 20, time.Time{}),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-1", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+
+		_, err = service.Allocate("order-3", "RETRO-CLOCK", 50)
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		deallocated, err := service.CancelOrder("order-3")
+		assert.Nil(t, err)
+		assert.Empty(t, deallocated)
+
+		product, _ := uow.GetProduct("RETRO-CLOCK")
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("returns error for an unknown order", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 20, time.Time{}),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-1", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+
+		_, err = service.CancelOrder("order-404")
+		assert.Equal(t, UnknownOrderError{OrderID: "order-404"}, err)
+		assert.False(t, uow.Committed)
+	})
+
+	t.Run("returns error for an order that has already been cancelled", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 20, time.Time{}),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-1", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", "BLUE-LAMP", 5)
+		assert.Nil(t, err)
+
+		_, err = service.CancelOrder("order-1")
+		assert.Nil(t, err)
+
+		_, err = service.CancelOrder("order-1")
+		assert.ErrorAs(t, err, &UnknownOrderError{})
+	})
+}
+
+func TestService_AmendOrderLine(t *testing.T) {
+	t.Run("keeps the line in its batch when it has room", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+
+		batchRef, err := service.AmendOrderLine(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 15})
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+		assert.Equal(t, domain.Reference("in-stock-batch"), batchRef)
+
+		batch, _ := uow.GetBatch("in-stock-batch")
+		assert.Equal(t, 15, batch.AllocatedQuantity())
+	})
+
+	t.Run("reallocates the line when its batch has no room", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+
+		batchRef, err := service.AmendOrderLine(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 25})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("shipment-batch"), batchRef)
+
+		batch, _ := uow.GetBatch("in-stock-batch")
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+	})
+
+	t.Run("changes nothing when the new quantity cannot be allocated", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+
+		_, err = service.AmendOrderLine(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 40})
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+		assert.False(t, uow.Committed)
+
+		batch, _ := uow.GetBatch("in-stock-batch")
+		assert.Equal(t, 10, batch.AllocatedQuantity())
+	})
+
+	t.Run("returns error for an unknown sku", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 30, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+
+		_, err = service.AmendOrderLine(domain.OrderLine{OrderID: "order-1", Sku: "BLUE-LAMP", Quantity: 5})
+		assert.ErrorAs(t, err, &InvalidSkuError{})
+	})
+}
 
-		service := StockService{
-			repo: repo,
-		}
-		_, err := service.Allocate("order-1", invalidSku, 12)
-		assert.ErrorIs(t, err, InvalidSkuError{sku: invalidSku})
+func TestService_Simulate(t *testing.T) {
+	orders := []domain.Order{
+		domain.NewOrder("order-1", domain.OrderLine{Sku: "RETRO-CLOCK", Quantity: 15}, domain.OrderLine{Sku: "BLUE-LAMP", Quantity: 5}),
+		domain.NewOrder("order-2", domain.OrderLine{Sku: "RETRO-CLOCK", Quantity: 10}, domain.OrderLine{Sku: "BLUE-LAMP", Quantity: 5}),
+		domain.NewOrder("order-3", domain.OrderLine{Sku: "BLUE-LAMP", Quantity: 5}),
+	}
+
+	t.Run("reports the outcome of every line with the stock left by the orders before", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow)
+
+		results, err := service.Simulate(orders, domain.BestEffort)
+		assert.Nil(t, err)
+		assert.Len(t, results, 3)
+
+		assert.Equal(t, []domain.LineAllocation{
+			{Sku: "RETRO-CLOCK", Quantity: 15, BatchRef: "clock-batch"},
+			{Sku: "BLUE-LAMP", Quantity: 5, BatchRef: "lamp-batch"},
+		}, results[0].Lines)
+
+		assert.Equal(t, domain.Reference("order-2"), results[1].OrderID)
+		assert.ErrorAs(t, results[1].Lines[0].Err, &domain.OutOfStockError{})
+		assert.Equal(t, domain.Reference("lamp-batch"), results[1].Lines[1].BatchRef)
+
+		assert.ErrorAs(t, results[2].Lines[0].Err, &domain.OutOfStockError{})
 	})
 
-	t.Run("allocate prefers warehouse batches to shipments", func(t *testing.T) {
+	t.Run("rolls back an order that cannot be allocated whole in all-or-nothing mode", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow)
 
-		inStockBatchRef := domain.Reference("in-stock-batch-001")
-		shipmentBatchRef := domain.Reference("shipment-batch-001")
-		sku := domain.Sku("RETRO-CLOCK")
+		results, err := service.Simulate(orders, domain.AllOrNothing)
+		assert.Nil(t, err)
+		assert.Empty(t, results[1].Lines[1].BatchRef)
+		assert.Equal(t, domain.Reference("lamp-batch"), results[2].Lines[0].BatchRef)
+	})
 
-		repo := repos.NewFakeRepository(
-			repos.WithBatch(inStockBatchRef, sku, 100, time.Time{}),
-			repos.WithBatch(shipmentBatchRef, sku, 100, time.Time{}.AddDate(0, 4, 1)),
+	t.Run("never changes the stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
 		)
+		service := NewStockService(uow)
 
-		service := StockService{
-			repo: repo,
+		_, err := service.Simulate(orders, domain.BestEffort)
+		assert.Nil(t, err)
+		assert.False(t, uow.Committed)
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		assert.Equal(t, 0, clockBatch.AllocatedQuantity())
+		product, _ := uow.GetProduct("RETRO-CLOCK")
+		assert.Empty(t, product.Backorders)
+		assert.Empty(t, product.Events)
+		assert.Empty(t, uow.CollectNewEvents())
+	})
+
+	t.Run("reports invalid orders on their lines", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow)
+
+		results, err := service.Simulate([]domain.Order{domain.NewOrder("order-1", domain.OrderLine{Sku: "RETRO-CLOCK"})}, "")
+		assert.Nil(t, err)
+		assert.Error(t, results[0].Lines[0].Err)
+	})
+
+	t.Run("returns error for an unknown mode", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 20, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 10, time.Time{}),
+		)
+		service := NewStockService(uow)
+
+		_, err := service.Simulate(orders, "most")
+		assert.Error(t, err)
+	})
+}
+
+func TestService_Rebalance(t *testing.T) {
+	t.Run("plans without changing any stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		for _, orderLine := range []domain.OrderLine{
+			{OrderID: "order-1", Quantity: 3},
+			{OrderID: "order-2", Quantity: 3},
+			{OrderID: "order-3", Quantity: 7},
+		} {
+			_, err := service.Allocate(orderLine.OrderID, "RETRO-CLOCK", orderLine.Quantity)
+			assert.Nil(t, err)
 		}
+		_, err := service.Allocate("order-4", "RETRO-CLOCK", 6)
+		assert.ErrorAs(t, err, &BackorderedError{})
+		uow.Committed = false
 
-		_, err := service.Allocate("order-002", "RETRO-CLOCK", 10)
+		plan, err := service.PlanRebalance("RETRO-CLOCK")
 		assert.Nil(t, err)
+		assert.Equal(t, 4, plan.Fulfilled())
+		assert.False(t, uow.Committed)
 
-		inStockBatch, _ := repo.GetBatch(inStockBatchRef)
-		shipmentBatch, _ := repo.GetBatch(shipmentBatchRef)
+		product, _ := uow.GetProduct("RETRO-CLOCK")
+		assert.Len(t, product.Backorders, 1)
+	})
 
-		assert.Equal(t, 90, inStockBatch.AvailableQuantity())
-		assert.Equal(t, 100, shipmentBatch.AvailableQuantity())
+	t.Run("reallocates the lines to fill the backorder", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		for _, orderLine := range []domain.OrderLine{
+			{OrderID: "order-1", Quantity: 3},
+			{OrderID: "order-2", Quantity: 3},
+			{OrderID: "order-3", Quantity: 7},
+		} {
+			_, err := service.Allocate(orderLine.OrderID, "RETRO-CLOCK", orderLine.Quantity)
+			assert.Nil(t, err)
+		}
+		_, err := service.Allocate("order-4", "RETRO-CLOCK", 6)
+		assert.ErrorAs(t, err, &BackorderedError{})
+		uow.Committed = false
+
+		plan, err := service.Rebalance("RETRO-CLOCK")
+		assert.Nil(t, err)
+		assert.Equal(t, 4, plan.Fulfilled())
+		assert.True(t, uow.Committed)
+
+		product, _ := uow.GetProduct("RETRO-CLOCK")
+		assert.Empty(t, product.Backorders)
+		inStock, _ := uow.GetBatch("in-stock-batch")
+		shipment, _ := uow.GetBatch("shipment-batch")
+		assert.Equal(t, 19, inStock.AllocatedQuantity()+shipment.AllocatedQuantity())
+	})
+
+	t.Run("returns error for an unknown sku", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 10, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 10, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+		for _, orderLine := range []domain.OrderLine{
+			{OrderID: "order-1", Quantity: 3},
+			{OrderID: "order-2", Quantity: 3},
+			{OrderID: "order-3", Quantity: 7},
+		} {
+			_, err := service.Allocate(orderLine.OrderID, "RETRO-CLOCK", orderLine.Quantity)
+			assert.Nil(t, err)
+		}
+		_, err := service.Allocate("order-4", "RETRO-CLOCK", 6)
+		assert.ErrorAs(t, err, &BackorderedError{})
+		uow.Committed = false
+
+		_, err = service.Rebalance("BLUE-LAMP")
+		assert.ErrorAs(t, err, &InvalidSkuError{})
+	})
+}
+
+func TestService_DeliveryPromise(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Run("promises the earliest dispatch date with the lead time of the sku", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		)
+		service := NewStockService(uow,
+			WithClock(func() time.Time { return now }),
+			WithLeadTime(domain.LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}),
+		)
+
+		promise, err := service.DeliveryPromise("RETRO-CLOCK", 10, "")
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Promise{BatchRef: "shipment-batch", ETA: now.AddDate(0, 0, 7), DispatchDate: now.AddDate(0, 0, 9)}, promise)
+		assert.False(t, uow.Committed)
+	})
+
+	t.Run("promises the allocated line like the delivery promise", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		)
+		service := NewStockService(uow,
+			WithClock(func() time.Time { return now }),
+			WithLeadTime(domain.LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}),
+		)
+
+		promise, err := service.DeliveryPromise("RETRO-CLOCK", 5, "")
+		assert.Nil(t, err)
+
+		result, err := service.AllocateForCustomer(domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 5}, "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, promise, result.Promise)
+		assert.Equal(t, domain.Promise{BatchRef: "in-stock-batch", InStock: true, DispatchDate: now.AddDate(0, 0, 1)}, promise)
+	})
+
+	t.Run("returns error when no batch has room", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		)
+		service := NewStockService(uow,
+			WithClock(func() time.Time { return now }),
+			WithLeadTime(domain.LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}),
+		)
+
+		_, err := service.DeliveryPromise("RETRO-CLOCK", 25, "")
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+	})
+
+	t.Run("returns error for an unknown sku", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", "RETRO-CLOCK", 5, time.Time{}),
+			repos.WithBatch("shipment-batch", "RETRO-CLOCK", 20, now.AddDate(0, 0, 7)),
+		)
+		service := NewStockService(uow,
+			WithClock(func() time.Time { return now }),
+			WithLeadTime(domain.LeadTime{Sku: "RETRO-CLOCK", InStock: 24 * time.Hour, Inbound: 48 * time.Hour}),
+		)
+
+		_, err := service.DeliveryPromise("BLUE-LAMP", 1, "")
+		assert.ErrorAs(t, err, &InvalidSkuError{})
+	})
+}
+
+func TestService_Recall(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("recalls batches of several products and persists the report", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			repos.WithBatch("clock-shipment", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 50, time.Time{}),
+		)
+		service := NewStockService(uow, WithClock(func() time.Time { return now }))
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", "BLUE-LAMP", 10)
+		assert.Nil(t, err)
+
+		report, err := service.Recall("recall-001", []domain.Reference{"clock-batch", "lamp-batch"}, domain.DeallocateRecalled)
+		assert.Nil(t, err)
+		assert.True(t, uow.Committed)
+		assert.Equal(t, domain.RecallReport{
+			RecallID:   "recall-001",
+			Policy:     domain.DeallocateRecalled,
+			RecalledAt: now,
+			Batches:    []domain.RecalledBatch{{Reference: "clock-batch", Sku: "RETRO-CLOCK"}, {Reference: "lamp-batch", Sku: "BLUE-LAMP"}},
+			Lines: []domain.RecalledLine{
+				{OrderLine: domain.OrderLine{OrderID: "order-1", Sku: "RETRO-CLOCK", Quantity: 10}, BatchRef: "clock-batch", ToBatchRef: "clock-shipment"},
+				{OrderLine: domain.OrderLine{OrderID: "order-2", Sku: "BLUE-LAMP", Quantity: 10}, BatchRef: "lamp-batch"},
+			},
+		}, report)
+		assert.Equal(t, []domain.Reference{"order-1", "order-2"}, report.OrderIDs())
+
+		savedReport, err := service.RecallReport("recall-001")
+		assert.Nil(t, err)
+		assert.Equal(t, report, savedReport)
+
+		lampBatch, _ := uow.GetBatch("lamp-batch")
+		assert.Equal(t, domain.Recalled, lampBatch.Status)
+		lamps, _ := uow.GetProduct("BLUE-LAMP")
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-2", Sku: "BLUE-LAMP", Quantity: 10}}, lamps.Backorders)
+	})
+
+	t.Run("recalls several batches of the same product", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			repos.WithBatch("clock-shipment", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 50, time.Time{}),
+		)
+		service := NewStockService(uow)
+		_, err := service.Allocate("order-1", "RETRO-CLOCK", 10)
+		assert.Nil(t, err)
+
+		report, err := service.Recall("recall-001", []domain.Reference{"clock-batch", "clock-shipment"}, domain.FlagRecalled)
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.Reference{"order-1"}, report.OrderIDs())
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		clockShipment, _ := uow.GetBatch("clock-shipment")
+		assert.Equal(t, domain.Recalled, clockShipment.Status)
+		assert.Equal(t, 10, clockBatch.AllocatedQuantity())
+	})
+
+	t.Run("recalls nothing when one of the batches cannot be recalled", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			repos.WithBatch("clock-shipment", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 50, time.Time{}),
+		)
+		service := NewStockService(uow)
+
+		_, err := service.Recall("recall-001", []domain.Reference{"clock-batch", "batch-404"}, domain.FlagRecalled)
+		assert.Error(t, err)
+		assert.False(t, uow.Committed)
+
+		clockBatch, _ := uow.GetBatch("clock-batch")
+		assert.True(t, clockBatch.Status.IsAvailable())
+		_, err = service.RecallReport("recall-001")
+		assert.ErrorAs(t, err, &UnknownRecallError{})
+	})
+
+	t.Run("returns error for a recall that already exists", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			repos.WithBatch("clock-shipment", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 50, time.Time{}),
+		)
+		service := NewStockService(uow)
+		_, err := service.Recall("recall-001", []domain.Reference{"clock-batch"}, domain.FlagRecalled)
+		assert.Nil(t, err)
+
+		_, err = service.Recall("recall-001", []domain.Reference{"lamp-batch"}, domain.FlagRecalled)
+		assert.Error(t, err)
+	})
+
+	t.Run("returns error for an unknown policy or no batches", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", "RETRO-CLOCK", 50, time.Time{}),
+			repos.WithBatch("clock-shipment", "RETRO-CLOCK", 50, time.Time{}.AddDate(0, 1, 0)),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 50, time.Time{}),
+		)
+		service := NewStockService(uow)
+
+		_, err := service.Recall("recall-001", []domain.Reference{"clock-batch"}, "destroy")
+		assert.Error(t, err)
+
+		_, err = service.Recall("recall-001", nil, domain.FlagRecalled)
+		assert.Error(t, err)
+	})
+}
+
+func TestService_TransferStock(t *testing.T) {
+	sku := domain.Sku("RETRO-CLOCK")
+	eta := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("moves stock to a batch in transit to the destination", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow)
+		assert.Nil(t, service.AddStock(domain.Batch{Reference: "leeds-batch", Sku: sku, Quantity: 50, Warehouse: "leeds"}))
+		_, err := service.Allocate("order-1", sku, 30)
+		assert.Nil(t, err)
+
+		assert.Nil(t, service.TransferStock("leeds-batch", "transfer-001", 40, "london", eta))
+		assert.True(t, uow.Committed)
+
+		source, _ := uow.GetBatch("leeds-batch")
+		transfer, _ := uow.GetBatch("transfer-001")
+		assert.Equal(t, 10, source.Quantity)
+		assert.Equal(t, domain.Warehouse("london"), transfer.Warehouse)
+		assert.Equal(t, 30, transfer.AllocatedQuantity())
+	})
+
+	t.Run("returns error for an unknown batch", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow)
+
+		err := service.TransferStock("batch-404", "transfer-001", 10, "london", eta)
+		assert.Error(t, err)
+		assert.False(t, uow.Committed)
+	})
+
+	t.Run("returns error for a transfer reference used by another product", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("clock-batch", sku, 50, time.Time{}),
+			repos.WithBatch("lamp-batch", "BLUE-LAMP", 50, time.Time{}),
+		)
+		service := NewStockService(uow)
+
+		err := service.TransferStock("clock-batch", "lamp-batch", 10, "london", eta)
+		assert.Error(t, err)
+		assert.False(t, uow.Committed)
+
+		source, _ := uow.GetBatch("clock-batch")
+		assert.Equal(t, 50, source.Quantity)
+	})
+}
+
+func TestService_Expiry(t *testing.T) {
+	sku := domain.Sku("FRESH-MILK")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("does not allocate to expired stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow, WithClock(func() time.Time { return now }))
+		assert.Nil(t, service.AddStock(domain.NewPerishableBatch("batch-001", sku, 20, time.Time{}, now)))
+
+		_, err := service.Allocate("order-1", sku, 5)
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+	})
+
+	t.Run("allocates perishable stock with the fefo strategy", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow,
+			WithClock(func() time.Time { return now }),
+			WithSkuAllocationStrategy(sku, domain.FEFOStrategy{MinShelfLife: 48 * time.Hour}),
+		)
+		assert.Nil(t, service.AddStock(domain.NewPerishableBatch("soon-batch", sku, 20, time.Time{}, now.AddDate(0, 0, 1))))
+		assert.Nil(t, service.AddStock(domain.NewPerishableBatch("later-batch", sku, 20, time.Time{}, now.AddDate(0, 0, 3))))
+		assert.Nil(t, service.AddStock(domain.NewPerishableBatch("latest-batch", sku, 20, time.Time{}, now.AddDate(0, 0, 5))))
+
+		batchRef, err := service.Allocate("order-1", sku, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("later-batch"), batchRef)
+	})
+
+	t.Run("reviewing expiry moves order lines off expired batches", func(t *testing.T) {
+		clock := now
+		uow := repos.NewFakeUnitOfWork()
+		service := NewStockService(uow, WithClock(func() time.Time { return clock }))
+		assert.Nil(t, service.AddStock(domain.NewPerishableBatch("soon-batch", sku, 20, time.Time{}, now.AddDate(0, 0, 1))))
+		assert.Nil(t, service.AddStock(domain.NewPerishableBatch("later-batch", sku, 20, time.Time{}, now.AddDate(0, 0, 10))))
+		_, err := service.Allocate("order-1", sku, 5)
+		assert.Nil(t, err)
+
+		clock = now.AddDate(0, 0, 1)
+		assert.Nil(t, service.ReviewExpiry(0))
+		assert.True(t, uow.Committed)
+
+		soonBatch, _ := uow.GetBatch("soon-batch")
+		laterBatch, _ := uow.GetBatch("later-batch")
+		assert.Equal(t, 0, soonBatch.AllocatedQuantity())
+		assert.Equal(t, 5, laterBatch.AllocatedQuantity())
+		assert.True(t, soonBatch.FlaggedExpiring)
+	})
+}
+
+func TestService_AllocationStrategy(t *testing.T) {
+	sku := domain.Sku("RETRO-CLOCK")
+	otherSku := domain.Sku("TEDDY-BEAR")
+
+	uow := repos.NewFakeUnitOfWork(
+		repos.WithBatch("in-stock-batch", sku, 20, time.Time{}),
+		repos.WithBatch("shipment-batch", sku, 100, time.Time{}.AddDate(0, 1, 0)),
+		repos.WithBatch("other-in-stock-batch", otherSku, 20, time.Time{}),
+		repos.WithBatch("other-shipment-batch", otherSku, 100, time.Time{}.AddDate(0, 1, 0)),
+	)
+
+	t.Run("uses the eta strategy by default", func(t *testing.T) {
+		service := NewStockService(uow)
+
+		batchRef, err := service.Allocate("order-001", sku, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("in-stock-batch"), batchRef)
+	})
+
+	t.Run("uses the strategy of the service", func(t *testing.T) {
+		service := NewStockService(uow, WithAllocationStrategy(domain.LargestRemainingStrategy{}))
+
+		batchRef, err := service.Allocate("order-002", sku, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("shipment-batch"), batchRef)
+	})
+
+	t.Run("a sku strategy overrides the strategy of the service", func(t *testing.T) {
+		service := NewStockService(uow,
+			WithAllocationStrategy(domain.LargestRemainingStrategy{}),
+			WithSkuAllocationStrategy(otherSku, domain.ETAStrategy{}),
+		)
+
+		batchRef, err := service.Allocate("order-003", otherSku, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("other-in-stock-batch"), batchRef)
+
+		batchRef, err = service.Allocate("order-004", sku, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("shipment-batch"), batchRef)
 	})
 }
 
@@ -70,15 +1313,15 @@ func TestService_Deallocate(t *testing.T) {
 		allocatedBatch := domain.Batch{Reference: "batch-123", Sku: sku, Quantity: 30, ETA: time.Time{}.AddDate(2025, 10, 2)}
 		allocatedOrderLine := domain.OrderLine{OrderID: "order001", Sku: sku, Quantity: 10}
 
-		repo := repos.NewFakeRepository()
+		uow := repos.NewFakeUnitOfWork()
 
-		service := StockService{repo: repo}
+		service := StockService{uow: uow}
 		service.AddBatch(allocatedBatch.Reference, allocatedBatch.Sku, allocatedBatch.Quantity, allocatedBatch.ETA)
 
 		batchRef, err := service.Allocate(allocatedOrderLine.OrderID, allocatedBatch.Sku, allocatedOrderLine.Quantity)
 		assert.Nil(t, err)
 
-		batch, err := repo.GetBatch(batchRef)
+		batch, err := uow.GetBatch(batchRef)
 		assert.Nil(t, err)
 
 		assert.Equal(t, (allocatedBatch.Quantity - allocatedOrderLine.Quantity), batch.AvailableQuantity())
@@ -86,7 +1329,7 @@ func TestService_Deallocate(t *testing.T) {
 		err = service.Deallocate(batch, allocatedOrderLine)
 		assert.Nil(t, err)
 
-		deallocatedBatch, err := repo.GetBatch(batchRef)
+		deallocatedBatch, err := uow.GetBatch(batchRef)
 		assert.Nil(t, err)
 
 		assert.Equal(t, deallocatedBatch.Quantity, deallocatedBatch.AvailableQuantity())
@@ -98,27 +1341,264 @@ func TestService_Deallocate(t *testing.T) {
 		batch := domain.Batch{Reference: "batch-123", Sku: sku, Quantity: 30, ETA: time.Time{}.AddDate(2025, 10, 2)}
 		orderLine := domain.OrderLine{OrderID: "order001", Sku: sku, Quantity: 10}
 
-		repo := repos.NewFakeRepository()
+		uow := repos.NewFakeUnitOfWork()
 
-		service := StockService{repo: repo}
+		service := StockService{uow: uow}
 		service.AddBatch(batch.Reference, batch.Sku, batch.Quantity, batch.ETA)
 
 		err := service.Deallocate(batch, orderLine)
 		assert.Error(t, err)
 	})
+
+	t.Run("fills backorders with the strategy of the service when deallocating a whole line", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("in-stock-batch", sku, 10, time.Time{}),
+			repos.WithBatch("shipment-batch", sku, 20, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := NewStockService(uow)
+
+		_, err := service.AllocateSplit("order-1", sku, 30)
+		assert.Nil(t, err)
+		_, err = service.Allocate("order-2", sku, 5)
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		service = NewStockService(uow, WithAllocationStrategy(domain.LargestRemainingStrategy{}))
+		_, err = service.DeallocateOrderLine("order-1", sku)
+		assert.Nil(t, err)
+
+		batch, _ := uow.GetBatch("shipment-batch")
+		assert.True(t, batch.IsAllocated(domain.OrderLine{OrderID: "order-2", Sku: sku, Quantity: 5}))
+	})
+}
+
+func TestService_AllocateSplit(t *testing.T) {
+	t.Run("splits an order line no single batch can take", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", sku, 20, time.Time{}),
+			repos.WithBatch("batch-002", sku, 20, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := StockService{uow: uow}
+
+		allocations, err := service.AllocateSplit("order-1", sku, 30)
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.BatchAllocation{{BatchRef: "batch-001", Quantity: 20}, {BatchRef: "batch-002", Quantity: 10}}, allocations)
+		assert.True(t, uow.Committed)
+	})
+
+	t.Run("allocates what fits and backorders the rest when the batches together are short", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", sku, 20, time.Time{}),
+			repos.WithBatch("batch-002", sku, 5, time.Time{}.AddDate(0, 1, 0)),
+		)
+		service := StockService{uow: uow}
+
+		allocations, err := service.AllocateSplit("order-1", sku, 30)
+		assert.ErrorAs(t, err, &BackorderedError{})
+		assert.ErrorAs(t, err, &domain.OutOfStockError{})
+		assert.Equal(t, []domain.BatchAllocation{{BatchRef: "batch-001", Quantity: 20}, {BatchRef: "batch-002", Quantity: 5}}, allocations)
+		assert.True(t, uow.Committed)
+
+		product, _ := uow.GetProduct(sku)
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-1", Sku: sku, Quantity: 5, Split: true}}, product.Backorders)
+	})
+
+	t.Run("splits the backordered rest across the batches that arrive", func(t *testing.T) {
+		sku := domain.Sku("MASSIVE-LAMP")
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 20, time.Time{}))
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateSplit("order-1", sku, 30)
+		assert.ErrorAs(t, err, &BackorderedError{})
+
+		assert.Nil(t, service.AddBatch("batch-002", sku, 4, time.Time{}.AddDate(0, 1, 0)))
+		product, _ := uow.GetProduct(sku)
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-1", Sku: sku, Quantity: 6, Split: true}}, product.Backorders)
+
+		assert.Nil(t, service.AddBatch("batch-003", sku, 10, time.Time{}.AddDate(0, 2, 0)))
+		product, _ = uow.GetProduct(sku)
+		assert.Empty(t, product.Backorders)
+
+		batch, _ := uow.GetBatch("batch-003")
+		assert.Equal(t, 6, batch.AllocatedQuantity())
+	})
+}
+
+func TestService_AllocateOrder(t *testing.T) {
+	order := domain.NewOrder("order-1",
+		domain.OrderLine{Sku: "SMALL-TABLE", Quantity: 4},
+		domain.OrderLine{Sku: "BIG-LAMP", Quantity: 8},
+	)
+
+	t.Run("allocates every line of the order", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		lines, err := service.AllocateOrder(domain.NewOrder("order-1",
+			domain.OrderLine{Sku: "SMALL-TABLE", Quantity: 4},
+			domain.OrderLine{Sku: "BIG-LAMP", Quantity: 2},
+		), domain.AllOrNothing)
+		assert.Nil(t, err)
+		assert.Equal(t, []domain.LineAllocation{
+			{Sku: "SMALL-TABLE", Quantity: 4, BatchRef: "batch-001"},
+			{Sku: "BIG-LAMP", Quantity: 2, BatchRef: "batch-002"},
+		}, lines)
+		assert.True(t, uow.Committed)
+	})
+
+	t.Run("all-or-nothing rolls back every line when one is out of stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateOrder(order, domain.AllOrNothing)
+		var notAllocatedError OrderNotAllocatedError
+		assert.ErrorAs(t, err, &notAllocatedError)
+		assert.Nil(t, notAllocatedError.Lines[0].Err)
+		assert.ErrorAs(t, notAllocatedError.Lines[1].Err, &domain.OutOfStockError{})
+		assert.False(t, uow.Committed)
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 0, batch.AllocatedQuantity())
+	})
+
+	t.Run("all-or-nothing is the default mode", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateOrder(order, "")
+		assert.ErrorAs(t, err, &OrderNotAllocatedError{})
+	})
+
+	t.Run("best-effort allocates the lines that can be allocated", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		lines, err := service.AllocateOrder(order, domain.BestEffort)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.Reference("batch-001"), lines[0].BatchRef)
+		assert.Empty(t, lines[1].BatchRef)
+		assert.ErrorAs(t, lines[1].Err, &domain.OutOfStockError{})
+		assert.True(t, uow.Committed)
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 4, batch.AllocatedQuantity())
+	})
+
+	t.Run("best-effort backorders the lines that are out of stock", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		lines, err := service.AllocateOrder(order, domain.BestEffort)
+		assert.Nil(t, err)
+		assert.ErrorAs(t, lines[1].Err, &BackorderedError{})
+
+		product, _ := uow.GetProduct("BIG-LAMP")
+		assert.Equal(t, []domain.OrderLine{{OrderID: "order-1", Sku: "BIG-LAMP", Quantity: 8}}, product.Backorders)
+	})
+
+	t.Run("all-or-nothing does not backorder any line", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateOrder(order, domain.AllOrNothing)
+		assert.Error(t, err)
+
+		product, _ := uow.GetProduct("BIG-LAMP")
+		assert.Empty(t, product.Backorders)
+	})
+
+	t.Run("best-effort reports an invalid sku against its line", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		lines, err := service.AllocateOrder(domain.NewOrder("order-1",
+			domain.OrderLine{Sku: "SMALL-TABLE", Quantity: 4},
+			domain.OrderLine{Sku: "INVALID-SKU", Quantity: 1},
+		), domain.BestEffort)
+		assert.Nil(t, err)
+		assert.ErrorAs(t, lines[1].Err, &InvalidSkuError{})
+	})
+
+	t.Run("best-effort returns error when no line can be allocated or backordered", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateOrder(domain.NewOrder("order-1", domain.OrderLine{Sku: "INVALID-SKU", Quantity: 8}), domain.BestEffort)
+		assert.ErrorAs(t, err, &OrderNotAllocatedError{})
+	})
+
+	t.Run("returns error for an unknown mode", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(
+			repos.WithBatch("batch-001", "SMALL-TABLE", 10, time.Time{}),
+			repos.WithBatch("batch-002", "BIG-LAMP", 5, time.Time{}),
+		)
+		service := StockService{uow: uow}
+
+		_, err := service.AllocateOrder(order, "most-lines")
+		assert.Error(t, err)
+	})
 }
 
 func TestService_AddBatch(t *testing.T) {
 	batchToAdd := domain.NewBatch("batch-001", "LARGE-TABLE", 30, time.Time{})
-	repo := repos.NewFakeRepository()
+	uow := repos.NewFakeUnitOfWork()
 	service := StockService{
-		repo: repo,
+		uow: uow,
 	}
 	err := service.AddBatch(batchToAdd.Reference, batchToAdd.Sku, batchToAdd.Quantity, batchToAdd.ETA)
 	assert.Nil(t, err)
 
-	addedBatch, err := repo.GetBatch(batchToAdd.Reference)
+	addedBatch, err := uow.GetBatch(batchToAdd.Reference)
 	assert.Nil(t, err)
 
 	assert.EqualExportedValues(t, batchToAdd, addedBatch)
 }
+
+func TestService_ChangeBatchQuantity(t *testing.T) {
+	t.Run("changes the quantity of the batch", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "LARGE-TABLE", 30, time.Time{}))
+		service := StockService{uow: uow}
+
+		err := service.ChangeBatchQuantity("batch-001", 20)
+		assert.Nil(t, err)
+
+		batch, _ := uow.GetBatch("batch-001")
+		assert.Equal(t, 20, batch.Quantity)
+		assert.True(t, uow.Committed)
+	})
+
+	t.Run("returns error for an unknown batch", func(t *testing.T) {
+		uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", "LARGE-TABLE", 30, time.Time{}))
+		service := StockService{uow: uow}
+
+		err := service.ChangeBatchQuantity("batch-404", 20)
+		assert.Error(t, err)
+		assert.False(t, uow.Committed)
+	})
+}
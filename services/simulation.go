@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+)
+
+// SimulatedOrder is the outcome of allocating an order in a simulation, every line carries the batch it would be
+// allocated to or the reason it would not be
+type SimulatedOrder struct {
+	OrderID domain.Reference
+	Lines   []domain.LineAllocation
+}
+
+// Simulate allocates the orders one after another like AllocateOrder, each seeing the stock left by the orders before
+// it, and reports the outcome of every line. The orders are allocated against a snapshot of the stock that is thrown
+// away afterwards, nothing is ever persisted and no events are published.
+func (s *StockService) Simulate(orders []domain.Order, mode domain.AllocationMode) ([]SimulatedOrder, error) {
+	if mode == "" {
+		mode = domain.AllOrNothing
+	}
+	if mode != domain.AllOrNothing && mode != domain.BestEffort {
+		return nil, fmt.Errorf("unknown allocation mode %q", mode)
+	}
+
+	if err := s.uow.Begin(); err != nil {
+		return nil, fmt.Errorf("could not begin unit of work: %w", err)
+	}
+	// The unit of work is never committed, rolling it back leaves the stock as it was
+	defer s.uow.Rollback()
+
+	simulation := *s
+	simulation.uow = newSimulationUnitOfWork(s.uow)
+
+	results := make([]SimulatedOrder, 0, len(orders))
+	for _, order := range orders {
+		result := SimulatedOrder{OrderID: order.OrderID}
+		if err := order.Validate(); err != nil {
+			for _, orderLine := range order.Lines {
+				result.Lines = append(result.Lines, domain.LineAllocation{Sku: orderLine.Sku, Quantity: orderLine.Quantity, Err: err})
+			}
+			results = append(results, result)
+			continue
+		}
+
+		lines, err := simulation.AllocateOrder(order, mode)
+		var notAllocated OrderNotAllocatedError
+		if errors.As(err, &notAllocated) {
+			lines = notAllocated.Lines
+		} else if err != nil {
+			return nil, fmt.Errorf("could not simulate order %s: %w", order.OrderID, err)
+		}
+		result.Lines = lines
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// simulationUnitOfWork runs use cases against a copy-on-write snapshot of the repository of another unit of work.
+// Products are cloned the first time they are read and every write stays in the snapshot, so nothing done in it
+// reaches the repository. Committing keeps the changes in the snapshot, rolling back restores it to how it was when
+// the work began.
+type simulationUnitOfWork struct {
+	Repository
+	products map[domain.Sku]*domain.Product
+	snapshot map[domain.Sku]*domain.Product
+}
+
+func newSimulationUnitOfWork(repository Repository) *simulationUnitOfWork {
+	return &simulationUnitOfWork{
+		Repository: repository,
+		products:   make(map[domain.Sku]*domain.Product),
+	}
+}
+
+func (u *simulationUnitOfWork) AddProduct(product *domain.Product) error {
+	if existing, err := u.GetProduct(product.Sku); err != nil || existing != nil {
+		return fmt.Errorf("product %s already exists", product.Sku)
+	}
+	u.products[product.Sku] = product
+	return nil
+}
+
+// GetProduct returns the product from the snapshot, cloning it from the repository the first time it is read
+func (u *simulationUnitOfWork) GetProduct(sku domain.Sku) (*domain.Product, error) {
+	if product, ok := u.products[sku]; ok {
+		return product, nil
+	}
+	product, err := u.Repository.GetProduct(sku)
+	if err != nil || product == nil {
+		return product, err
+	}
+	clone := product.Clone()
+	clone.Events = nil
+	u.products[sku] = &clone
+	return &clone, nil
+}
+
+func (u *simulationUnitOfWork) GetProductByBatchRef(reference domain.Reference) (*domain.Product, error) {
+	for _, product := range u.products {
+		if _, ok := product.Batch(reference); ok {
+			return product, nil
+		}
+	}
+	product, err := u.Repository.GetProductByBatchRef(reference)
+	if err != nil || product == nil {
+		return product, err
+	}
+	return u.GetProduct(product.Sku)
+}
+
+func (u *simulationUnitOfWork) SaveProduct(product *domain.Product) error {
+	u.products[product.Sku] = product
+	return nil
+}
+
+func (u *simulationUnitOfWork) CancelOrderLines(orderID domain.Reference) error {
+	return nil
+}
+
+func (u *simulationUnitOfWork) AddRecallReport(report domain.RecallReport) error {
+	return nil
+}
+
+func (u *simulationUnitOfWork) Begin() error {
+	u.snapshot = make(map[domain.Sku]*domain.Product, len(u.products))
+	for sku, product := range u.products {
+		clone := product.Clone()
+		u.snapshot[sku] = &clone
+	}
+	return nil
+}
+
+func (u *simulationUnitOfWork) Commit() error {
+	u.snapshot = nil
+	return nil
+}
+
+func (u *simulationUnitOfWork) Rollback() error {
+	if u.snapshot == nil {
+		return nil
+	}
+	u.products = u.snapshot
+	u.snapshot = nil
+	return nil
+}
+
+// CollectNewEvents drops the events recorded in the simulation, they describe changes that never happened
+func (u *simulationUnitOfWork) CollectNewEvents() []domain.Event {
+	for _, product := range u.products {
+		product.PopEvents()
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/messagebus"
+)
+
+// Schedule handles the command on the bus every time ticks fires, until the context is done or ticks is closed.
+// Going through the bus means the events recorded along the way are handled like those of any other command.
+// Pass the channel of a time.Ticker to run the command on an interval.
+func Schedule(ctx context.Context, bus *messagebus.MessageBus, ticks <-chan time.Time, command commands.Command) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-ticks:
+			if !ok {
+				return
+			}
+			if _, err := bus.Handle(command); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// SweepExpiredHolds releases the expired holds every time ticks fires
+func SweepExpiredHolds(ctx context.Context, bus *messagebus.MessageBus, ticks <-chan time.Time) {
+	Schedule(ctx, bus, ticks, commands.ReleaseExpiredHolds{})
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abbasegbeyemi/cosmic-python-go/commands"
+	"github.com/abbasegbeyemi/cosmic-python-go/domain"
+	"github.com/abbasegbeyemi/cosmic-python-go/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepExpiredHolds(t *testing.T) {
+	sku := domain.Sku("MASSIVE-LAMP")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var clock atomic.Pointer[time.Time]
+	clock.Store(&now)
+	uow := repos.NewFakeUnitOfWork(repos.WithBatch("batch-001", sku, 10, time.Time{}))
+	bus := NewMessageBus(uow, WithClock(func() time.Time { return *clock.Load() }))
+
+	var events []domain.Event
+	recordEvents[domain.HoldReleased](bus, &events)
+
+	_, err := bus.Handle(commands.Hold{OrderID: "order-1", Sku: sku, Quantity: 4})
+	assert.Nil(t, err)
+
+	ticks := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		SweepExpiredHolds(context.Background(), bus, ticks)
+		close(done)
+	}()
+
+	ticks <- now
+	expired := now.Add(DefaultHoldDuration)
+	clock.Store(&expired)
+	ticks <- expired
+	close(ticks)
+	<-done
+
+	batch, _ := uow.GetBatch("batch-001")
+	assert.Equal(t, 0, batch.HeldQuantity())
+	assert.Equal(t, []domain.Event{domain.HoldReleased{OrderID: "order-1", Sku: sku, Quantity: 4, BatchRef: "batch-001"}}, events)
+}